@@ -1,16 +1,48 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"figenn/internal/blob"
 	"figenn/internal/database"
+	"figenn/internal/encryption"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/media"
 	"figenn/internal/server"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("La clé secrète JWT n'a pas été définie")
+	jwtKeys, err := jwtkeys.KeySetFromEnv()
+	if err != nil {
+		log.Fatalf("Clés JWT invalides (JWT_SIGNING_KEYS, JWT_SECRET): %v", err)
+	}
+
+	keyring, err := encryption.KeyringFromEnv()
+	if err != nil {
+		log.Fatalf("Clés de chiffrement invalides (ENCRYPTION_KEYS): %v", err)
+	}
+
+	blobs, err := blob.StoreFromEnv()
+	if err != nil {
+		log.Fatalf("Stockage des fichiers invalide (BLOB_STORE): %v", err)
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// MEDIA_URL is the public URL of the /media route, or of the bucket when
+	// it is served directly.
+	mediaURL := os.Getenv("MEDIA_URL")
+	if mediaURL == "" {
+		mediaURL = "http://localhost:" + port + "/media"
 	}
 
 	log.Println("Initialisation de la base de données...")
@@ -19,18 +51,40 @@ func main() {
 
 	log.Println("Création du serveur...")
 	config := server.Config{
-		JWTSecret: jwtSecret,
+		JWTKeys: jwtKeys,
+		Keyring: keyring,
+		Media:   media.NewUploader(blobs, mediaURL),
 	}
 	srv := server.NewServer(db, config)
 	srv.SetupRoutes()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	sched := srv.StartScheduler(ctx)
+
+	// shutdownDone is closed once the in-flight requests are over.
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Println("Arrêt du serveur...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Erreur lors de l'arrêt du serveur: %v", err)
+		}
+	}()
 
 	log.Printf("Tentative de démarrage du serveur sur le port %s...", port)
-	if err := srv.Start(port); err != nil {
+	if err := srv.Start(port); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Erreur lors du démarrage du serveur: %v", err)
 	}
+
+	// Start returns as soon as the shutdown begins: let the requests and the
+	// running jobs finish before closing the database.
+	stop()
+	<-shutdownDone
+	if sched != nil {
+		sched.Wait()
+	}
 }
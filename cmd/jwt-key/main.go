@@ -0,0 +1,23 @@
+// Command jwt-key prints a new JWT signing key in the JWT_SIGNING_KEYS
+// format. Prepend it to JWT_SIGNING_KEYS to make it the primary key; keep the
+// previous keys until the tokens they signed expired.
+package main
+
+import (
+	"figenn/internal/jwtkeys"
+	"flag"
+	"fmt"
+	"log"
+)
+
+func main() {
+	id := flag.String("id", "", "id of the key, published as kid")
+	alg := flag.String("alg", string(jwtkeys.EdDSA), "signing algorithm: EdDSA or RS256")
+	flag.Parse()
+
+	key, err := jwtkeys.GenerateKey(*id, jwtkeys.Algorithm(*alg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(key)
+}
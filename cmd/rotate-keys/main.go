@@ -0,0 +1,50 @@
+// Command rotate-keys re-encrypts the secrets stored in the database with the
+// primary key of ENCRYPTION_KEYS, encrypting the values still in plaintext.
+// Run it after prepending a new key; older keys can be removed once it
+// succeeds.
+//
+// With -generate <id>, it prints a new key to add to ENCRYPTION_KEYS instead.
+package main
+
+import (
+	"context"
+	"figenn/internal/auth"
+	"figenn/internal/database"
+	"figenn/internal/encryption"
+	"figenn/internal/powens"
+	"flag"
+	"fmt"
+	"log"
+)
+
+func main() {
+	generate := flag.String("generate", "", "print a new key with this id and exit")
+	flag.Parse()
+
+	if *generate != "" {
+		key, err := encryption.GenerateKey(*generate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	keyring, err := encryption.KeyringFromEnv()
+	if err != nil {
+		log.Fatalf("invalid ENCRYPTION_KEYS: %v", err)
+	}
+
+	db := database.New()
+	defer db.Close()
+
+	columns := append(append([]encryption.Column{}, powens.EncryptedColumns...), auth.EncryptedColumns...)
+	ctx := context.Background()
+	for _, col := range columns {
+		rotated, err := keyring.RotateColumn(ctx, db.Pool(), col)
+		if err != nil {
+			log.Fatalf("rotating %s: %v", col.Field(), err)
+		}
+		log.Printf("%s: %d value(s) re-encrypted with key %q", col.Field(), rotated, keyring.PrimaryKeyID())
+	}
+}
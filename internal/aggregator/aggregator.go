@@ -0,0 +1,156 @@
+// Package aggregator defines what Figenn needs from a bank aggregation
+// provider, independently of the provider used.
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidAccessToken = errors.New("invalid aggregator access token")
+	ErrConnectionNotFound = errors.New("aggregator connection not found")
+)
+
+// BankAggregator gives access to the bank data of users through a provider
+// such as Powens. Every call but InitUser acts on behalf of the user owning
+// accessToken.
+type BankAggregator interface {
+	// InitUser creates a user at the provider and returns its permanent
+	// access token.
+	InitUser(ctx context.Context) (*User, error)
+	// CreateTemporaryToken returns a short-lived token to hand to the
+	// connection webview.
+	CreateTemporaryToken(ctx context.Context, accessToken string) (string, error)
+	// ConnectURL returns the URL of the webview where the user links a bank,
+	// which then redirects to redirectURI.
+	ConnectURL(temporaryToken, redirectURI string) (string, error)
+	// ReconnectURL returns the URL of the webview where the user repairs a
+	// connection, by authenticating again or completing a strong customer
+	// authentication.
+	ReconnectURL(temporaryToken, redirectURI string, connectionID int64) (string, error)
+	ListConnections(ctx context.Context, accessToken string) ([]*Connection, error)
+	// SyncConnection asks the bank for fresh data and returns the connection
+	// with its new state.
+	SyncConnection(ctx context.Context, accessToken string, connectionID int64) (*Connection, error)
+	// DeleteConnection removes a connection along with its accounts and
+	// transactions at the provider.
+	DeleteConnection(ctx context.Context, accessToken string, connectionID int64) error
+	// ListAccounts returns the accounts of a connection, including disabled
+	// and deleted ones.
+	ListAccounts(ctx context.Context, accessToken string, connectionID int64) ([]*Account, error)
+	// ListTransactions returns every transaction of an account matching
+	// query, deleted ones included when query.LastUpdate is set.
+	ListTransactions(ctx context.Context, accessToken string, accountID int64, query TransactionsQuery) ([]*Transaction, error)
+}
+
+// User is a user at the provider.
+type User struct {
+	ID          int64
+	AccessToken string
+}
+
+// Connection states, in the Powens vocabulary. A nil state means the
+// connection is valid.
+const (
+	StateSCARequired        = "SCARequired"
+	StateWebauthRequired    = "webauthRequired"
+	StateDecoupled          = "decoupled"
+	StateValidating         = "validating"
+	StateWrongPass          = "wrongpass"
+	StatePasswordExpired    = "passwordExpired"
+	StateActionNeeded       = "actionNeeded"
+	StateAdditionalInfo     = "additionalInformationNeeded"
+	StateWebsiteUnavailable = "websiteUnavailable"
+	StateBug                = "bug"
+)
+
+// ConnectionStatus groups the connection states by what the user has to do.
+type ConnectionStatus string
+
+const (
+	StatusValid ConnectionStatus = "valid"
+	// StatusSCARequired asks for a strong customer authentication in the
+	// reconnection webview.
+	StatusSCARequired ConnectionStatus = "sca_required"
+	// StatusWrongPass asks for new credentials in the reconnection webview.
+	StatusWrongPass ConnectionStatus = "wrongpass"
+	// StatusDecoupled waits for the user to validate the access in their
+	// banking app.
+	StatusDecoupled ConnectionStatus = "decoupled"
+	// StatusActionNeeded asks the user to act on the bank website, to accept
+	// new terms for instance.
+	StatusActionNeeded ConnectionStatus = "action_needed"
+	// StatusError is a failure on the bank or provider side; it usually
+	// resolves itself.
+	StatusError ConnectionStatus = "error"
+)
+
+// StatusOf returns the status matching a connection state.
+func StatusOf(state *string) ConnectionStatus {
+	if state == nil || *state == "" {
+		return StatusValid
+	}
+	switch *state {
+	case StateSCARequired, StateWebauthRequired:
+		return StatusSCARequired
+	case StateWrongPass, StatePasswordExpired:
+		return StatusWrongPass
+	case StateDecoupled, StateValidating:
+		return StatusDecoupled
+	case StateActionNeeded, StateAdditionalInfo:
+		return StatusActionNeeded
+	default:
+		return StatusError
+	}
+}
+
+// Connection is the link between a user and one of their banks.
+type Connection struct {
+	ID           int64
+	ConnectorID  int64
+	State        *string
+	ErrorMessage *string
+	LastUpdate   *time.Time
+	Active       bool
+}
+
+type Account struct {
+	ID           int64
+	ConnectionID int64
+	Name         string
+	Number       *string
+	IBAN         *string
+	Type         string
+	Balance      float64
+	Currency     string
+	Disabled     bool
+	Deleted      bool
+}
+
+// Transaction is a bank transaction. Debits have a negative value, in the
+// currency of their account. OriginalValue and OriginalCurrency are set for
+// transactions made in another currency.
+type Transaction struct {
+	ID               int64
+	AccountID        int64
+	Date             time.Time
+	Value            float64
+	OriginalValue    *float64
+	OriginalCurrency string
+	Wording          string
+	OriginalWording  string
+	Type             string
+	Coming           bool
+	LastUpdate       *time.Time
+	Deleted          bool
+}
+
+// TransactionsQuery restricts the transactions listed. MinDate filters on
+// the transaction date while LastUpdate only returns the transactions
+// created, changed or deleted since then.
+type TransactionsQuery struct {
+	MinDate    *time.Time
+	LastUpdate *time.Time
+}
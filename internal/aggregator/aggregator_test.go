@@ -0,0 +1,20 @@
+package aggregator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusOf(t *testing.T) {
+	state := func(s string) *string { return &s }
+
+	assert.Equal(t, StatusValid, StatusOf(nil))
+	assert.Equal(t, StatusValid, StatusOf(state("")))
+	assert.Equal(t, StatusSCARequired, StatusOf(state(StateSCARequired)))
+	assert.Equal(t, StatusSCARequired, StatusOf(state(StateWebauthRequired)))
+	assert.Equal(t, StatusWrongPass, StatusOf(state(StateWrongPass)))
+	assert.Equal(t, StatusDecoupled, StatusOf(state(StateDecoupled)))
+	assert.Equal(t, StatusActionNeeded, StatusOf(state(StateAdditionalInfo)))
+	assert.Equal(t, StatusError, StatusOf(state(StateWebsiteUnavailable)))
+}
@@ -0,0 +1,300 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fakeTokenPrefix     = "fake:"
+	fakeTempTokenPrefix = "fake-temp:"
+	// fakeHistoryDays is how far back the fake bank history goes.
+	fakeHistoryDays = 400
+)
+
+// fakeCharge is a recurring movement of the fake bank history.
+type fakeCharge struct {
+	label  string
+	amount float64
+	// month restricts the charge to one month a year when set.
+	month time.Month
+	day   int
+}
+
+var (
+	fakeCheckingCharges = []fakeCharge{
+		{label: "VIR SALAIRE FIGENN DEMO", amount: 2500, day: 28},
+		{label: "PRLV SEPA SPOTIFY AB", amount: -10.99, day: 3},
+		{label: "FREE MOBILE", amount: -19.99, day: 5},
+		{label: "NETFLIX.COM", amount: -13.49, day: 12},
+		{label: "AMAZON PRIME", amount: -69.90, month: time.March, day: 15},
+	}
+	fakeSavingsCharges = []fakeCharge{
+		{label: "VIR EPARGNE MENSUELLE", amount: 100, day: 1},
+	}
+)
+
+// Fake is an in-process BankAggregator for tests and demos. Every user it
+// creates has a single connection with a checking and a savings account,
+// holding a deterministic history: the same user id and day always give the
+// same accounts and transactions, with stable ids.
+type Fake struct {
+	now func() time.Time
+
+	mu         sync.Mutex
+	nextUserID int64
+	// states and deleted track the connections whose state was changed with
+	// SetConnectionState or which were deleted.
+	states  map[int64]string
+	deleted map[int64]bool
+}
+
+// NewFake returns a fake aggregator whose clock is now, time.Now if nil.
+func NewFake(now func() time.Time) *Fake {
+	if now == nil {
+		now = time.Now
+	}
+	// Ids start from the clock so users created before a restart aren't
+	// handed out again.
+	return &Fake{now: now, nextUserID: now().Unix(), states: make(map[int64]string), deleted: make(map[int64]bool)}
+}
+
+func (f *Fake) InitUser(ctx context.Context) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextUserID++
+	return &User{ID: f.nextUserID, AccessToken: fakeTokenPrefix + strconv.FormatInt(f.nextUserID, 10)}, nil
+}
+
+func (f *Fake) CreateTemporaryToken(ctx context.Context, accessToken string) (string, error) {
+	userID, err := fakeUserID(accessToken)
+	if err != nil {
+		return "", err
+	}
+	return fakeTempTokenPrefix + strconv.FormatInt(userID, 10), nil
+}
+
+// ConnectURL skips the webview and redirects straight back, as the bank
+// would after a successful connection.
+func (f *Fake) ConnectURL(temporaryToken, redirectURI string) (string, error) {
+	userID, err := fakeTempUserID(temporaryToken)
+	if err != nil {
+		return "", err
+	}
+	return fakeRedirect(redirectURI, temporaryToken, fakeConnectionID(userID)), nil
+}
+
+// ReconnectURL repairs the connection right away and redirects back.
+func (f *Fake) ReconnectURL(temporaryToken, redirectURI string, connectionID int64) (string, error) {
+	userID, err := fakeTempUserID(temporaryToken)
+	if err != nil {
+		return "", err
+	}
+	if !f.exists(userID, connectionID) {
+		return "", ErrConnectionNotFound
+	}
+
+	f.mu.Lock()
+	delete(f.states, connectionID)
+	f.mu.Unlock()
+	return fakeRedirect(redirectURI, temporaryToken, connectionID), nil
+}
+
+func (f *Fake) ListConnections(ctx context.Context, accessToken string) ([]*Connection, error) {
+	userID, err := fakeUserID(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if !f.exists(userID, fakeConnectionID(userID)) {
+		return []*Connection{}, nil
+	}
+	return []*Connection{f.connection(userID)}, nil
+}
+
+func (f *Fake) SyncConnection(ctx context.Context, accessToken string, connectionID int64) (*Connection, error) {
+	userID, err := fakeUserID(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if !f.exists(userID, connectionID) {
+		return nil, ErrConnectionNotFound
+	}
+	return f.connection(userID), nil
+}
+
+func (f *Fake) DeleteConnection(ctx context.Context, accessToken string, connectionID int64) error {
+	userID, err := fakeUserID(accessToken)
+	if err != nil {
+		return err
+	}
+	if !f.exists(userID, connectionID) {
+		return ErrConnectionNotFound
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted[connectionID] = true
+	return nil
+}
+
+// SetConnectionState puts a connection in the given state, nil making it
+// valid again, to simulate a bank asking for a new authentication.
+func (f *Fake) SetConnectionState(connectionID int64, state *string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if state == nil {
+		delete(f.states, connectionID)
+		return
+	}
+	f.states[connectionID] = *state
+}
+
+func (f *Fake) connection(userID int64) *Connection {
+	id := fakeConnectionID(userID)
+	lastUpdate := f.today()
+	conn := &Connection{ID: id, ConnectorID: 59, LastUpdate: &lastUpdate, Active: true}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if state, ok := f.states[id]; ok {
+		conn.State = &state
+	}
+	return conn
+}
+
+func (f *Fake) exists(userID, connectionID int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return connectionID == fakeConnectionID(userID) && !f.deleted[connectionID]
+}
+
+func (f *Fake) ListAccounts(ctx context.Context, accessToken string, connectionID int64) ([]*Account, error) {
+	userID, err := fakeUserID(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if !f.exists(userID, connectionID) {
+		return []*Account{}, nil
+	}
+
+	accounts := []*Account{
+		{ID: fakeConnectionID(userID) + 1, ConnectionID: connectionID, Name: "Compte courant", Type: "checking", Balance: 1500, Currency: "EUR"},
+		{ID: fakeConnectionID(userID) + 2, ConnectionID: connectionID, Name: "Livret A", Type: "savings", Balance: 5000, Currency: "EUR"},
+	}
+	for _, account := range accounts {
+		for _, tx := range f.history(account.ID) {
+			account.Balance += tx.Value
+		}
+		account.Balance = math.Round(account.Balance*100) / 100
+	}
+	return accounts, nil
+}
+
+func (f *Fake) ListTransactions(ctx context.Context, accessToken string, accountID int64, query TransactionsQuery) ([]*Transaction, error) {
+	userID, err := fakeUserID(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if accountID/100 != userID || !f.exists(userID, fakeConnectionID(userID)) {
+		return []*Transaction{}, nil
+	}
+
+	transactions := []*Transaction{}
+	for _, tx := range f.history(accountID) {
+		if query.MinDate != nil && tx.Date.Before(*query.MinDate) {
+			continue
+		}
+		if query.LastUpdate != nil && !tx.LastUpdate.After(*query.LastUpdate) {
+			continue
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions, nil
+}
+
+func (f *Fake) today() time.Time {
+	now := f.now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// history generates the transactions of an account, oldest first.
+func (f *Fake) history(accountID int64) []*Transaction {
+	charges := fakeCheckingCharges
+	if accountID%100 == 2 {
+		charges = fakeSavingsCharges
+	}
+
+	today := f.today()
+	var transactions []*Transaction
+	for day := today.AddDate(0, 0, -fakeHistoryDays); !day.After(today); day = day.AddDate(0, 0, 1) {
+		for _, charge := range charges {
+			if day.Day() == charge.day && (charge.month == 0 || day.Month() == charge.month) {
+				transactions = append(transactions, fakeTransaction(accountID, day, charge.label, charge.amount))
+			}
+		}
+		// Weekly groceries of a varying amount on the checking account.
+		if accountID%100 == 1 && day.Weekday() == time.Saturday {
+			amount := -(30 + float64(day.YearDay()*37%70) + float64(day.Day())/100)
+			transactions = append(transactions, fakeTransaction(accountID, day, "CARREFOUR MARKET", amount))
+		}
+	}
+	return transactions
+}
+
+func fakeTransaction(accountID int64, day time.Time, label string, amount float64) *Transaction {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%s", accountID, day.Format(time.DateOnly), label)
+	lastUpdate := day.Add(8 * time.Hour)
+	return &Transaction{
+		ID:              int64(h.Sum64() & math.MaxInt64),
+		AccountID:       accountID,
+		Date:            day,
+		Value:           math.Round(amount*100) / 100,
+		Wording:         label,
+		OriginalWording: label,
+		Type:            "card",
+		LastUpdate:      &lastUpdate,
+	}
+}
+
+func fakeUserID(accessToken string) (int64, error) {
+	if !strings.HasPrefix(accessToken, fakeTokenPrefix) {
+		return 0, ErrInvalidAccessToken
+	}
+	userID, err := strconv.ParseInt(strings.TrimPrefix(accessToken, fakeTokenPrefix), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAccessToken
+	}
+	return userID, nil
+}
+
+func fakeTempUserID(temporaryToken string) (int64, error) {
+	if !strings.HasPrefix(temporaryToken, fakeTempTokenPrefix) {
+		return 0, ErrInvalidAccessToken
+	}
+	userID, err := strconv.ParseInt(strings.TrimPrefix(temporaryToken, fakeTempTokenPrefix), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAccessToken
+	}
+	return userID, nil
+}
+
+func fakeRedirect(redirectURI, temporaryToken string, connectionID int64) string {
+	params := url.Values{}
+	params.Set("connection_id", strconv.FormatInt(connectionID, 10))
+	params.Set("code", temporaryToken)
+	return redirectURI + "?" + params.Encode()
+}
+
+// fakeConnectionID derives the connection id of a user. Its accounts follow
+// it, so an account id divided by 100 gives back the user id.
+func fakeConnectionID(userID int64) int64 {
+	return userID * 100
+}
@@ -0,0 +1,91 @@
+package aggregator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, time.May, 14, 10, 0, 0, 0, time.UTC)
+	fake := NewFake(func() time.Time { return now })
+
+	user, err := fake.InitUser(ctx)
+	assert.NoError(t, err)
+
+	connections, err := fake.ListConnections(ctx, user.AccessToken)
+	assert.NoError(t, err)
+	assert.Len(t, connections, 1)
+
+	accounts, err := fake.ListAccounts(ctx, user.AccessToken, connections[0].ID)
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 2)
+
+	first, err := fake.ListTransactions(ctx, user.AccessToken, accounts[0].ID, TransactionsQuery{})
+	assert.NoError(t, err)
+	second, err := fake.ListTransactions(ctx, user.AccessToken, accounts[0].ID, TransactionsQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	var netflix int
+	for _, tx := range first {
+		if tx.Wording == "NETFLIX.COM" {
+			netflix++
+			assert.Equal(t, -13.49, tx.Value)
+			assert.Equal(t, 12, tx.Date.Day())
+		}
+	}
+	assert.Equal(t, 14, netflix)
+}
+
+func TestFakeTransactionsQuery(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, time.May, 14, 10, 0, 0, 0, time.UTC)
+	fake := NewFake(func() time.Time { return now })
+	user, _ := fake.InitUser(ctx)
+	accountID := fakeConnectionID(user.ID) + 1
+
+	minDate := time.Date(2025, time.May, 1, 0, 0, 0, 0, time.UTC)
+	recent, err := fake.ListTransactions(ctx, user.AccessToken, accountID, TransactionsQuery{MinDate: &minDate})
+	assert.NoError(t, err)
+	for _, tx := range recent {
+		assert.False(t, tx.Date.Before(minDate))
+	}
+
+	lastUpdate := time.Date(2025, time.May, 12, 8, 0, 0, 0, time.UTC)
+	updated, err := fake.ListTransactions(ctx, user.AccessToken, accountID, TransactionsQuery{LastUpdate: &lastUpdate})
+	assert.NoError(t, err)
+	for _, tx := range updated {
+		assert.True(t, tx.LastUpdate.After(lastUpdate))
+	}
+
+	_, err = fake.ListTransactions(ctx, "bogus", accountID, TransactionsQuery{})
+	assert.ErrorIs(t, err, ErrInvalidAccessToken)
+}
+
+func TestFakeConnectionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake(nil)
+	user, _ := fake.InitUser(ctx)
+	connectionID := fakeConnectionID(user.ID)
+
+	sca := StateSCARequired
+	fake.SetConnectionState(connectionID, &sca)
+	conn, err := fake.SyncConnection(ctx, user.AccessToken, connectionID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSCARequired, StatusOf(conn.State))
+
+	token, _ := fake.CreateTemporaryToken(ctx, user.AccessToken)
+	_, err = fake.ReconnectURL(token, "https://figenn.test/callback", connectionID)
+	assert.NoError(t, err)
+	conn, _ = fake.SyncConnection(ctx, user.AccessToken, connectionID)
+	assert.Equal(t, StatusValid, StatusOf(conn.State))
+
+	assert.NoError(t, fake.DeleteConnection(ctx, user.AccessToken, connectionID))
+	connections, _ := fake.ListConnections(ctx, user.AccessToken)
+	assert.Empty(t, connections)
+	assert.ErrorIs(t, fake.DeleteConnection(ctx, user.AccessToken, connectionID), ErrConnectionNotFound)
+}
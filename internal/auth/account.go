@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"figenn/internal/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// emailChangeDuration is how long the confirmation link of a new email
+// address stays valid.
+const emailChangeDuration = 24 * time.Hour
+
+// ChangePassword replaces the password of the user given the current one,
+// and signs out every other session: whoever knew the old password loses
+// access. Like RevokeOtherSessions, it needs to know the current session.
+func (s *Service) ChangePassword(ctx context.Context, userID, currentSession uuid.UUID, req ChangePasswordRequest) error {
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		return ErrMissingFields
+	}
+	if currentSession == uuid.Nil {
+		return ErrUnknownSession
+	}
+	if !utils.IsStrongPassword(req.NewPassword) {
+		return ErrPasswordTooWeak
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if !utils.ComparePassword(user.Password, req.CurrentPassword) {
+		return ErrWrongPassword
+	}
+	if req.CurrentPassword == req.NewPassword {
+		return ErrSamePassword
+	}
+
+	hashed, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return ErrInternalServer
+	}
+	if err := s.repo.UpdateUserPassword(ctx, userID, hashed); err != nil {
+		return ErrInternalServer
+	}
+	if _, err := s.repo.RevokeOtherSessions(ctx, userID, currentSession); err != nil {
+		return ErrInternalServer
+	}
+	s.userChanged(ctx, userID)
+	go sendPasswordChangedEmail(s.mailer, user)
+	return nil
+}
+
+// RequestEmailChange sends a confirmation link to the new address, and a
+// notice to the current one. The email only changes once the link is
+// followed, see ConfirmEmailChange.
+func (s *Service) RequestEmailChange(ctx context.Context, userID uuid.UUID, req ChangeEmailRequest) error {
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if newEmail == "" || req.CurrentPassword == "" {
+		return ErrMissingFields
+	}
+	if !utils.IsValidEmail(newEmail) {
+		return ErrInvalidEmail
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if !utils.ComparePassword(user.Password, req.CurrentPassword) {
+		return ErrWrongPassword
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return ErrSameEmail
+	}
+	exists, err := s.repo.CheckUserEmailExists(ctx, newEmail)
+	if err != nil {
+		return ErrInternalServer
+	}
+	if exists {
+		return ErrUserExists
+	}
+
+	token, err := generateEmailChangeToken(user, newEmail, s.config.JWTKeys, emailChangeDuration)
+	if err != nil {
+		return ErrInternalServer
+	}
+	confirmURL := s.config.AppURL + "/user/email/confirm?token=" + token
+	go sendEmailChangeConfirmation(s.mailer, user, newEmail, confirmURL)
+	go sendEmailChangeNotice(s.mailer, user, newEmail)
+	return nil
+}
+
+// ConfirmEmailChange moves the account to the address the token was sent
+// to, updating the Stripe customer as well. The token is refused if the
+// email changed since it was issued.
+func (s *Service) ConfirmEmailChange(ctx context.Context, token string) error {
+	userID, oldEmail, newEmail, err := parseEmailChangeToken(token, s.config.JWTKeys)
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	changed, err := s.repo.UpdateUserEmail(ctx, userID, oldEmail, newEmail)
+	if err != nil {
+		if errors.Is(err, ErrUserExists) {
+			return err
+		}
+		return ErrInternalServer
+	}
+	if !changed {
+		return ErrInvalidToken
+	}
+
+	_ = s.cache.Remove(oldEmail)
+	s.userChanged(ctx, userID)
+	if user.StripeCustomerID != "" {
+		if err := s.s.UpdateCustomerEmail(user.StripeCustomerID, newEmail); err != nil {
+			log.Printf("auth: updating the email of Stripe customer %s: %v", user.StripeCustomerID, err)
+		}
+	}
+	return nil
+}
+
+func generateEmailChangeToken(user *users.User, newEmail string, keys *jwtkeys.KeySet, duration time.Duration) (string, error) {
+	return keys.Sign(jwtkeys.AudienceChangeEmail, jwt.MapClaims{
+		"user_id":   user.ID,
+		"email":     user.Email,
+		"new_email": newEmail,
+		"exp":       time.Now().Add(duration).Unix(),
+		"iat":       time.Now().Unix(),
+	})
+}
+
+func parseEmailChangeToken(changeToken string, keys *jwtkeys.KeySet) (uuid.UUID, string, string, error) {
+	claims, err := keys.Parse(changeToken, jwtkeys.AudienceChangeEmail)
+	if err != nil {
+		return uuid.Nil, "", "", ErrInvalidToken
+	}
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, "", "", ErrInvalidToken
+	}
+	oldEmail, _ := claims["email"].(string)
+	newEmail, _ := claims["new_email"].(string)
+	if oldEmail == "" || newEmail == "" {
+		return uuid.Nil, "", "", ErrInvalidToken
+	}
+	return userID, oldEmail, newEmail, nil
+}
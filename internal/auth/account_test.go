@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailChangeToken(t *testing.T) {
+	keys := jwtkeys.NewHMACKeySet("secret")
+	user := &users.User{ID: uuid.New(), Email: "jane@example.com"}
+
+	token, err := generateEmailChangeToken(user, "jane@new.example.com", keys, time.Minute)
+	assert.NoError(t, err)
+	userID, oldEmail, newEmail, err := parseEmailChangeToken(token, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, userID)
+	assert.Equal(t, "jane@example.com", oldEmail)
+	assert.Equal(t, "jane@new.example.com", newEmail)
+
+	verification, _ := generateVerificationToken(user, keys, time.Minute)
+	_, _, _, err = parseEmailChangeToken(verification, keys)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"figenn/internal/mailer"
+	"figenn/internal/users"
+	"html"
+	"log"
+	"time"
+)
+
+// sendWelcomeEmail welcomes a new user and asks them to confirm their email
+// address through verifyLink.
+func sendWelcomeEmail(mailerClient mailer.Mailer, user *users.User, verifyLink string) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      user.Email,
+		Subject: "Welcome to our application",
+		Html: "<p>Hello " + user.FirstName + ",</p><p>Thank you for signing up for our application.</p>" +
+			"<p>Please <a href=\"" + verifyLink + "\">confirm your email address</a> to link your bank accounts and subscribe to a plan.</p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send welcome email", err)
+	}
+}
+
+// sendVerificationEmail sends a new link to confirm the email address.
+func sendVerificationEmail(mailerClient mailer.Mailer, user *users.User, verifyLink string) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Html:    "<p>Hello " + user.FirstName + ",</p><p>Click the following link to confirm your email address: <a href=\"" + verifyLink + "\">Confirm my email</a></p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send verification email", err)
+	}
+}
+
+func sendResetPasswordEmail(mailerClient mailer.Mailer, user *users.User, resetLink string) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      user.Email,
+		Subject: "Password Reset",
+		Html:    "<p>Hello " + user.FirstName + ",</p><p>Click the following link to reset your password: <a href=\"" + resetLink + "\">Reset Password</a></p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send reset password email", err)
+	}
+}
+
+// sendSessionRevokedEmail warns the user that a stolen refresh token was
+// replayed and the affected session signed out.
+func sendSessionRevokedEmail(mailerClient mailer.Mailer, user *users.User, deviceName, ip string) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      user.Email,
+		Subject: "Security alert: a session was signed out",
+		Html: "<p>Hello " + html.EscapeString(user.FirstName) + ",</p>" +
+			"<p>Someone tried to reuse an old sign-in token of your session on <strong>" + html.EscapeString(deviceName) +
+			"</strong> (request from " + html.EscapeString(ip) + "). We signed this session out to protect your account.</p>" +
+			"<p>If you don't recognize this activity, change your password and review your active sessions.</p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send session revoked email", err)
+	}
+}
+
+// sendAccountLockedEmail lets the user know their account was locked out
+// after repeated failed logins, with a link to unlock it.
+func sendAccountLockedEmail(mailerClient mailer.Mailer, user *users.User, unlockLink string, until time.Time) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      user.Email,
+		Subject: "Your account was temporarily locked",
+		Html: "<p>Hello " + html.EscapeString(user.FirstName) + ",</p>" +
+			"<p>We noticed many failed sign-in attempts on your account, so we locked it until " +
+			until.UTC().Format("January 2, 2006 15:04 MST") + ".</p>" +
+			"<p>If this was you, <a href=\"" + unlockLink + "\">unlock your account</a> now. " +
+			"If it wasn't, your password is safe, but consider changing it and enabling two-factor authentication.</p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send account locked email", err)
+	}
+}
+
+// sendPasswordChangedEmail confirms to the user that their password changed.
+func sendPasswordChangedEmail(mailerClient mailer.Mailer, user *users.User) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Html: "<p>Hello " + html.EscapeString(user.FirstName) + ",</p>" +
+			"<p>The password of your account was just changed, and your other devices were signed out.</p>" +
+			"<p>If you didn't do it, reset your password right away.</p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send password changed email", err)
+	}
+}
+
+// sendEmailChangeConfirmation sends the link confirming a new email address
+// to that address.
+func sendEmailChangeConfirmation(mailerClient mailer.Mailer, user *users.User, newEmail, confirmLink string) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Html: "<p>Hello " + html.EscapeString(user.FirstName) + ",</p>" +
+			"<p>Click the following link to use this address for your account: <a href=\"" + confirmLink + "\">Confirm my new email</a></p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send email change confirmation", err)
+	}
+}
+
+// sendEmailChangeNotice lets the current address know a change to newEmail
+// was requested.
+func sendEmailChangeNotice(mailerClient mailer.Mailer, user *users.User, newEmail string) {
+	ctx := context.Background()
+	emailConfig := mailer.Config{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Html: "<p>Hello " + html.EscapeString(user.FirstName) + ",</p>" +
+			"<p>A request was made to change the email address of your account to <strong>" + html.EscapeString(newEmail) +
+			"</strong>. The change only applies once confirmed from the new address.</p>" +
+			"<p>If you didn't make this request, change your password right away.</p>",
+	}
+
+	_, err := mailerClient.SendMail(ctx, emailConfig)
+	if err != nil {
+		log.Println("Failed to send email change notice", err)
+	}
+}
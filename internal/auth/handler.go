@@ -2,6 +2,8 @@ package auth
 
 import (
 	"errors"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/ratelimit"
 	"figenn/internal/users"
 	"net/http"
 	"time"
@@ -11,29 +13,51 @@ import (
 )
 
 type API struct {
-	service   *Service
-	JWTSecret string
+	service *Service
+	JWTKeys *jwtkeys.KeySet
+	limiter *ratelimit.Limiter
 }
 
-func NewAPI(service *Service, secret string) *API {
+func NewAPI(service *Service, keys *jwtkeys.KeySet, limiter *ratelimit.Limiter) *API {
 	return &API{
-		service:   service,
-		JWTSecret: secret,
+		service: service,
+		JWTKeys: keys,
+		limiter: limiter,
 	}
 }
 
 func (a *API) Bind(rg *echo.Group) {
 	authGroup := rg.Group("/auth")
 	authGroup.POST("/register", a.Register)
-	authGroup.POST("/login", a.Login)
-	authGroup.POST("/forgot-password", a.ForgotPassword)
+	authGroup.POST("/login", a.Login, a.limiter.LoginMiddleware("login"))
+	authGroup.POST("/login/totp", a.LoginTOTP, a.limiter.Middleware("login-totp"))
+	authGroup.POST("/forgot-password", a.ForgotPassword, a.limiter.Middleware("forgot-password"))
+	authGroup.GET("/unlock", a.UnlockAccount)
+	authGroup.GET("/verify-email", a.VerifyEmail)
+	authGroup.POST("/verify-email/resend", a.ResendVerificationEmail,
+		users.CookieAuthMiddleware(a.service.config.JWTKeys), a.limiter.Middleware("verify-email"))
 	authGroup.GET("/validate-reset-token", a.ValidateResetToken)
 	authGroup.POST("/reset-password", a.ResetPassword)
 	authGroup.GET("/logout", a.Logout)
 	authGroup.POST("/refresh", a.RefreshToken)
-	authGroup.POST("/enable-totp", a.EnableTOTP, users.CookieAuthMiddleware(a.service.config.JWTSecret))
-	authGroup.POST("/disable-totp", a.DisableTOTP, users.CookieAuthMiddleware(a.service.config.JWTSecret))
-	authGroup.POST("/verify-totp", a.VerifyTOTP, users.CookieAuthMiddleware(a.service.config.JWTSecret))
+	authGroup.POST("/enable-totp", a.EnableTOTP, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.POST("/disable-totp", a.DisableTOTP, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.POST("/verify-totp", a.VerifyTOTP, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.GET("/totp/recovery-codes", a.RecoveryCodesRemaining, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.POST("/totp/recovery-codes", a.RegenerateRecoveryCodes, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.GET("/sessions", a.ListSessions, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.DELETE("/sessions/:id", a.RevokeSession, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.POST("/sessions/revoke-others", a.RevokeOtherSessions, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+	authGroup.GET("/security-events", a.ListSecurityEvents, users.CookieAuthMiddleware(a.service.config.JWTKeys))
+
+	// Account changes live here as they need the credentials and sessions.
+	userGroup := rg.Group("/user")
+	// A wrong current password answers 403, counted as a failed attempt.
+	userGroup.POST("/password", a.ChangePassword, users.CookieAuthMiddleware(a.service.config.JWTKeys),
+		a.limiter.UserMiddleware("change-password", http.StatusForbidden))
+	userGroup.POST("/email", a.RequestEmailChange, users.CookieAuthMiddleware(a.service.config.JWTKeys),
+		a.limiter.UserMiddleware("change-email", http.StatusForbidden))
+	userGroup.GET("/email/confirm", a.ConfirmEmailChange)
 }
 
 func (a *API) Register(c echo.Context) error {
@@ -65,11 +89,44 @@ func (a *API) Login(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Request format is invalid"})
 	}
-	accessToken, refreshToken, err := a.service.Login(ctx, req, c.Response().Writer)
+	tokens, err := a.service.Login(ctx, req, deviceInfo(c))
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
 	}
-	setTokenCookies(c, *accessToken, *refreshToken, a.service.config)
+	if tokens.ChallengeToken != "" {
+		return c.JSON(http.StatusOK, echo.Map{
+			"message":         "TOTP code required",
+			"mfa_required":    true,
+			"challenge_token": tokens.ChallengeToken,
+		})
+	}
+	setTokenCookies(c, tokens.AccessToken, tokens.RefreshToken, a.service.config)
+	return c.JSON(http.StatusOK, echo.Map{"message": "Login successful"})
+}
+
+// LoginTOTP exchanges the challenge token returned by Login and a TOTP code
+// for the session cookies.
+func (a *API) LoginTOTP(c echo.Context) error {
+	ctx := c.Request().Context()
+	var req LoginTOTPRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Request format is invalid"})
+	}
+	tokens, err := a.service.LoginTOTP(ctx, req, deviceInfo(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrMissingFields):
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrTooManyTOTPAttempts):
+			return c.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrInvalidTOTPCode), errors.Is(err, ErrTOTPCodeReused),
+			errors.Is(err, ErrInvalidRecoveryCode), errors.Is(err, ErrTOTPNotEnabled):
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+		}
+	}
+	setTokenCookies(c, tokens.AccessToken, tokens.RefreshToken, a.service.config)
 	return c.JSON(http.StatusOK, echo.Map{"message": "Login successful"})
 }
 
@@ -94,6 +151,54 @@ func (a *API) ForgotPassword(c echo.Context) error {
 	return c.JSON(http.StatusOK, echo.Map{"message": "Password reset link has been sent to your email"})
 }
 
+// UnlockAccount lifts the lockout of an email, using the token of the email
+// sent when it was locked out.
+func (a *API) UnlockAccount(c echo.Context) error {
+	ctx := c.Request().Context()
+	email, err := a.service.UnlockEmail(c.QueryParam("token"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if err := a.limiter.Unlock(ctx, email); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Account unlocked"})
+}
+
+// VerifyEmail confirms the email address of a user with the token of their
+// welcome or verification email.
+func (a *API) VerifyEmail(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := a.service.VerifyEmail(ctx, c.QueryParam("token")); err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Email address verified"})
+}
+
+// ResendVerificationEmail sends a new verification link to the signed-in
+// user.
+func (a *API) ResendVerificationEmail(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, err := contextUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	if err := a.service.ResendVerificationEmail(ctx, userID); err != nil {
+		switch {
+		case errors.Is(err, ErrEmailAlreadyVerified):
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrUserNotFound):
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Verification email sent"})
+}
+
 func (a *API) ValidateResetToken(c echo.Context) error {
 	ctx := c.Request().Context()
 	token := c.QueryParam("token")
@@ -134,6 +239,11 @@ func (a *API) ResetPassword(c echo.Context) error {
 }
 
 func (a *API) Logout(c echo.Context) error {
+	if refreshCookie, err := c.Cookie("refreshToken"); err == nil && refreshCookie.Value != "" {
+		if err := a.service.LogoutSession(c.Request().Context(), refreshCookie.Value); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+		}
+	}
 	a.service.Logout(c.Response())
 	return c.NoContent(http.StatusOK)
 }
@@ -144,8 +254,11 @@ func (a *API) RefreshToken(c echo.Context) error {
 	if err != nil || refreshCookie.Value == "" {
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": ErrInvalidToken.Error()})
 	}
-	accessToken, refreshToken, err := a.service.RefreshToken(ctx, refreshCookie.Value)
+	accessToken, refreshToken, err := a.service.RefreshToken(ctx, refreshCookie.Value, deviceInfo(c))
 	if err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			a.service.Logout(c.Response())
+		}
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
 	}
 	setTokenCookies(c, *accessToken, *refreshToken, a.service.config)
@@ -163,6 +276,9 @@ func (a *API) EnableTOTP(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid user_id"})
 	}
 	secret, qr, err := a.service.GenerateTOTPSecret(ctx, userID)
+	if errors.Is(err, ErrTOTPAlreadyEnabled) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
@@ -183,13 +299,20 @@ func (a *API) VerifyTOTP(c echo.Context) error {
 	if err := c.Bind(&req); err != nil || req.Code == "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid TOTP payload"})
 	}
-	if err := a.service.EnableTOTP(ctx, userID, req.Code); err != nil {
-		if errors.Is(err, ErrInvalidTOTPCode) {
+	codes, err := a.service.EnableTOTP(ctx, userID, req.Code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) || errors.Is(err, ErrTOTPCodeReused) {
 			return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
 		}
+		if errors.Is(err, ErrTooManyTOTPAttempts) {
+			return c.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		}
+		if errors.Is(err, ErrTOTPAlreadyEnabled) {
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
-	return c.JSON(http.StatusOK, echo.Map{"message": "TOTP enabled"})
+	return c.JSON(http.StatusOK, echo.Map{"message": "TOTP enabled", "recovery_codes": codes})
 }
 
 func (a *API) DisableTOTP(c echo.Context) error {
@@ -203,18 +326,72 @@ func (a *API) DisableTOTP(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid user_id"})
 	}
 	var req TOTPRequest
-	if err := c.Bind(&req); err != nil || req.Code == "" {
+	if err := c.Bind(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid TOTP payload"})
 	}
-	if err := a.service.DisableTOTP(ctx, userID, req.Code); err != nil {
-		if errors.Is(err, ErrInvalidTOTPCode) {
+	if err := a.service.DisableTOTP(ctx, userID, req); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) || errors.Is(err, ErrTOTPCodeReused) || errors.Is(err, ErrInvalidRecoveryCode) {
 			return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
 		}
+		if errors.Is(err, ErrTooManyTOTPAttempts) {
+			return c.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		}
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 	return c.JSON(http.StatusOK, echo.Map{"message": "TOTP disabled"})
 }
 
+// RecoveryCodesRemaining returns how many unused recovery codes the user
+// has left.
+func (a *API) RecoveryCodesRemaining(c echo.Context) error {
+	ctx := c.Request().Context()
+	userIDStr, ok := c.Get("user_id").(string)
+	if !ok || userIDStr == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Missing user_id in context"})
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid user_id"})
+	}
+	remaining, err := a.service.RecoveryCodesRemaining(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+	}
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{Remaining: remaining})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user, given a
+// TOTP or recovery code.
+func (a *API) RegenerateRecoveryCodes(c echo.Context) error {
+	ctx := c.Request().Context()
+	userIDStr, ok := c.Get("user_id").(string)
+	if !ok || userIDStr == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Missing user_id in context"})
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid user_id"})
+	}
+	var req TOTPRequest
+	if err := c.Bind(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid TOTP payload"})
+	}
+	codes, err := a.service.RegenerateRecoveryCodes(ctx, userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTOTPNotEnabled):
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrInvalidTOTPCode), errors.Is(err, ErrTOTPCodeReused), errors.Is(err, ErrInvalidRecoveryCode):
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrTooManyTOTPAttempts):
+			return c.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{Codes: codes, Remaining: len(codes)})
+}
+
 func setTokenCookies(c echo.Context, accessToken, refreshToken string, cfg Config) {
 	secure := cfg.Environment == "production"
 	c.SetCookie(&http.Cookie{
@@ -236,3 +413,173 @@ func setTokenCookies(c echo.Context, accessToken, refreshToken string, cfg Confi
 		Expires:  time.Now().Add(cfg.RefreshTokenDuration),
 	})
 }
+
+// ListSessions returns the devices the user is signed in on.
+func (a *API) ListSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, err := contextUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	sessions, err := a.service.ListSessions(ctx, userID, contextSessionID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession signs one of the user's devices out.
+func (a *API) RevokeSession(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, err := contextUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid session id"})
+	}
+	if err := a.service.RevokeSession(ctx, userID, sessionID); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs out every device but the one making the request.
+func (a *API) RevokeOtherSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, err := contextUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	revoked, err := a.service.RevokeOtherSessions(ctx, userID, contextSessionID(c))
+	if errors.Is(err, ErrUnknownSession) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"revoked": revoked})
+}
+
+// ListSecurityEvents returns the latest security events of the account, such
+// as sessions revoked after a refresh token was replayed.
+func (a *API) ListSecurityEvents(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, err := contextUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	events, err := a.service.ListSecurityEvents(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// ChangePassword replaces the password of the signed-in user, signing out
+// their other sessions.
+func (a *API) ChangePassword(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, err := contextUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	var req ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Request format is invalid"})
+	}
+	if err := a.service.ChangePassword(ctx, userID, contextSessionID(c), req); err != nil {
+		switch {
+		case errors.Is(err, ErrMissingFields):
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrWrongPassword):
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrUnknownSession):
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrPasswordTooWeak), errors.Is(err, ErrSamePassword):
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrUserNotFound):
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Password changed"})
+}
+
+// RequestEmailChange sends a confirmation link to the new email address.
+func (a *API) RequestEmailChange(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, err := contextUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	var req ChangeEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Request format is invalid"})
+	}
+	if err := a.service.RequestEmailChange(ctx, userID, req); err != nil {
+		switch {
+		case errors.Is(err, ErrMissingFields):
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrWrongPassword):
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrUserExists):
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrSameEmail):
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrUserNotFound):
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+		}
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{"message": "Confirmation link sent to the new email address"})
+}
+
+// ConfirmEmailChange applies an email change with the token of the
+// confirmation link.
+func (a *API) ConfirmEmailChange(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := a.service.ConfirmEmailChange(ctx, c.QueryParam("token")); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrUserExists):
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		case errors.Is(err, ErrUserNotFound):
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": ErrInternalServer.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Email address changed"})
+}
+
+func deviceInfo(c echo.Context) DeviceInfo {
+	return DeviceInfo{UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+}
+
+func contextUserID(c echo.Context) (uuid.UUID, error) {
+	userIDStr, ok := c.Get("user_id").(string)
+	if !ok || userIDStr == "" {
+		return uuid.Nil, errors.New("Missing user_id in context")
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, errors.New("Invalid user_id")
+	}
+	return userID, nil
+}
+
+// contextSessionID returns the session of the access token, uuid.Nil for
+// tokens issued before sessions existed.
+func contextSessionID(c echo.Context) uuid.UUID {
+	sessionIDStr, _ := c.Get("session_id").(string)
+	sessionID, _ := uuid.Parse(sessionIDStr)
+	return sessionID
+}
@@ -36,6 +36,14 @@ type LoginRequest struct {
 	Password string `json:"password" form:"password"`
 }
 
+// LoginTOTPRequest is the second login step of users with TOTP enabled.
+type LoginTOTPRequest struct {
+	ChallengeToken string `json:"challenge_token" form:"challenge_token"`
+	Code           string `json:"code" form:"code"`
+	// RecoveryCode replaces Code when the user lost their authenticator.
+	RecoveryCode string `json:"recovery_code" form:"recovery_code"`
+}
+
 type LoginResponse struct {
 	Token string `json:"token"`
 }
@@ -48,12 +56,32 @@ type ResetPasswordRequest struct {
 	Token    string `json:"token" form:"token"`
 	Password string `json:"password" form:"password"`
 }
+
+// ChangePasswordRequest changes the password of the signed-in user.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" form:"current_password"`
+	NewPassword     string `json:"new_password" form:"new_password"`
+}
+
+// ChangeEmailRequest asks to move the account to a new email address.
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email" form:"new_email"`
+	CurrentPassword string `json:"current_password" form:"current_password"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// TOTPRequest carries a TOTP code or, where accepted, a recovery code.
 type TOTPRequest struct {
-	Code string `json:"code" form:"code"  validate:"required"`
+	Code         string `json:"code" form:"code"`
+	RecoveryCode string `json:"recovery_code" form:"recovery_code"`
+}
+
+type RecoveryCodesResponse struct {
+	Codes     []string `json:"codes,omitempty"`
+	Remaining int      `json:"remaining"`
 }
 
 type TOTPSecretResponse struct {
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"figenn/internal/utils"
+	"math/big"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes in a set.
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	// recoveryCodeAlphabet leaves out characters easily mistaken for one
+	// another.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after
+// checking their second factor, and returns the new ones. They are only
+// shown this once.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, req TOTPRequest) ([]string, error) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFAEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	if err := s.verifySecondFactor(ctx, userID, req); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(ctx, userID)
+}
+
+// RecoveryCodesRemaining returns how many recovery codes the user can still
+// use.
+func (s *Service) RecoveryCodesRemaining(ctx context.Context, userID uuid.UUID) (int, error) {
+	return s.repo.CountUnusedRecoveryCodes(ctx, userID)
+}
+
+func (s *Service) newRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes, hashes, err := generateHashedRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateHashedRecoveryCodes returns a new set of recovery codes along with
+// the hashes to store.
+func generateHashedRecoveryCodes() ([]string, []string, error) {
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		if hashes[i], err = utils.HashPassword(normalizeRecoveryCode(code)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return codes, hashes, nil
+}
+
+// verifySecondFactor checks the TOTP code of req or, failing that, its
+// recovery code. Both count towards the same lockout.
+func (s *Service) verifySecondFactor(ctx context.Context, userID uuid.UUID, req TOTPRequest) error {
+	switch {
+	case req.Code != "":
+		return s.VerifyTOTP(ctx, userID, req.Code)
+	case req.RecoveryCode != "":
+		return s.verifyRecoveryCode(ctx, userID, req.RecoveryCode)
+	default:
+		return ErrMissingFields
+	}
+}
+
+// verifyRecoveryCode checks a recovery code of the user and marks it as
+// used.
+func (s *Service) verifyRecoveryCode(ctx context.Context, userID uuid.UUID, code string) error {
+	if err := s.checkTOTPLockout(ctx, userID); err != nil {
+		return err
+	}
+
+	hashes, err := s.repo.ListUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+	code = normalizeRecoveryCode(code)
+	for id, hash := range hashes {
+		if !utils.ComparePassword(hash, code) {
+			continue
+		}
+		used, err := s.repo.UseRecoveryCode(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidRecoveryCode
+		}
+		return s.repo.ResetTOTPFailures(ctx, userID)
+	}
+
+	if err := s.repo.RecordTOTPFailure(ctx, userID, maxTOTPAttempts, totpLockout); err != nil {
+		return err
+	}
+	return ErrInvalidRecoveryCode
+}
+
+// generateRecoveryCodes returns n random codes formatted as xxxxx-xxxxx.
+func generateRecoveryCodes(n int) ([]string, error) {
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	codes := make([]string, n)
+	for i := range codes {
+		var b strings.Builder
+		for j := 0; j < recoveryCodeLength; j++ {
+			if j == recoveryCodeLength/2 {
+				b.WriteByte('-')
+			}
+			k, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(recoveryCodeAlphabet[k.Int64()])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes, which users may
+// type differently.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
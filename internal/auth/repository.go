@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"errors"
+	"figenn/internal/encryption"
 	"figenn/internal/payment"
 	"figenn/internal/users"
 	"time"
@@ -11,16 +12,28 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// uniqueViolation is the Postgres error code of a unique constraint failure.
+const uniqueViolation = "23505"
+
+// EncryptedColumns lists the columns the repository encrypts. Refresh
+// tokens are only stored hashed, see sessions.
+var EncryptedColumns = []encryption.Column{totpSecretColumn}
+
+var totpSecretColumn = encryption.Column{Table: "users", Column: "two_fa_secret"}
+
 type Repository struct {
-	pool *pgxpool.Pool
+	pool    *pgxpool.Pool
+	keyring *encryption.Keyring
 }
 
-func NewRepository(pool *pgxpool.Pool) *Repository {
+func NewRepository(pool *pgxpool.Pool, keyring *encryption.Keyring) *Repository {
 	return &Repository{
-		pool: pool,
+		pool:    pool,
+		keyring: keyring,
 	}
 }
 
@@ -51,7 +64,7 @@ func (r *Repository) CreateUser(ctx context.Context, user *users.User) error {
 }
 
 func (r *Repository) FindUserByEmail(ctx context.Context, email string) (*users.User, error) {
-	q := squirrel.Select("id", "email", "password", "first_name", "last_name", "profile_picture_url", "country", "stripe_customer_id").
+	q := squirrel.Select("id", "email", "password", "first_name", "last_name", "profile_picture_url", "country", "stripe_customer_id", "two_fa_enabled", "email_verified_at").
 		From("users").
 		Where(squirrel.Eq{"email": email}).
 		PlaceholderFormat(squirrel.Dollar)
@@ -62,7 +75,7 @@ func (r *Repository) FindUserByEmail(ctx context.Context, email string) (*users.
 	}
 
 	var u users.User
-	err = r.pool.QueryRow(ctx, query, args...).Scan(&u.ID, &u.Email, &u.Password, &u.FirstName, &u.LastName, &u.ProfilePictureUrl, &u.Country, &u.StripeCustomerID)
+	err = r.pool.QueryRow(ctx, query, args...).Scan(&u.ID, &u.Email, &u.Password, &u.FirstName, &u.LastName, &u.ProfilePictureUrl, &u.Country, &u.StripeCustomerID, &u.TwoFAEnabled, &u.EmailVerifiedAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrUserNotFound
 	}
@@ -172,41 +185,8 @@ func (r *Repository) UpdateUserPassword(ctx context.Context, userID uuid.UUID, h
 	return nil
 }
 
-func (r *Repository) StoreRefreshToken(ctx context.Context, userID uuid.UUID, token string) error {
-	q := squirrel.Update("users").
-		Set("refresh_token", token).
-		Where(squirrel.Eq{"id": userID}).
-		PlaceholderFormat(squirrel.Dollar)
-
-	query, args, err := q.ToSql()
-	if err != nil {
-		return err
-	}
-	_, err = r.pool.Exec(ctx, query, args...)
-	return err
-}
-
-func (r *Repository) CheckRefreshToken(ctx context.Context, userID uuid.UUID, token string) (bool, error) {
-	q := squirrel.Select("1").From("users").
-		Where(squirrel.Eq{"id": userID, "refresh_token": token}).
-		Limit(1).
-		PlaceholderFormat(squirrel.Dollar)
-
-	query, args, err := q.ToSql()
-	if err != nil {
-		return false, err
-	}
-
-	var exists int
-	err = r.pool.QueryRow(ctx, query, args...).Scan(&exists)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return false, nil
-	}
-	return true, err
-}
-
 func (r *Repository) GetUserByID(ctx context.Context, id uuid.UUID) (*users.User, error) {
-	q := squirrel.Select("id", "email", "password", "first_name", "last_name", "profile_picture_url", "country", "stripe_customer_id").
+	q := squirrel.Select("id", "email", "password", "first_name", "last_name", "profile_picture_url", "country", "stripe_customer_id", "two_fa_enabled", "email_verified_at").
 		From("users").
 		Where(squirrel.Eq{"id": id}).
 		PlaceholderFormat(squirrel.Dollar)
@@ -217,13 +197,39 @@ func (r *Repository) GetUserByID(ctx context.Context, id uuid.UUID) (*users.User
 	}
 
 	var u users.User
-	err = r.pool.QueryRow(ctx, query, args...).Scan(&u.ID, &u.Email, &u.Password, &u.FirstName, &u.LastName, &u.ProfilePictureUrl, &u.Country, &u.StripeCustomerID)
+	err = r.pool.QueryRow(ctx, query, args...).Scan(&u.ID, &u.Email, &u.Password, &u.FirstName, &u.LastName, &u.ProfilePictureUrl, &u.Country, &u.StripeCustomerID, &u.TwoFAEnabled, &u.EmailVerifiedAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrUserNotFound
 	}
 	return &u, err
 }
 
+// MarkEmailVerified records that the user confirmed email, unless they
+// changed their address in the meantime. It returns false in that case.
+func (r *Repository) MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string) (bool, error) {
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, $3) WHERE id = $1 AND email = $2`
+	rst, err := r.pool.Exec(ctx, query, userID, email, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return rst.RowsAffected() > 0, nil
+}
+
+// UpdateUserEmail moves the user from oldEmail to newEmail, verified by the
+// confirmation link. It returns false if the email changed in the meantime.
+func (r *Repository) UpdateUserEmail(ctx context.Context, userID uuid.UUID, oldEmail, newEmail string) (bool, error) {
+	query := `UPDATE users SET email = $3, email_verified_at = $4 WHERE id = $1 AND email = $2`
+	rst, err := r.pool.Exec(ctx, query, userID, oldEmail, newEmail, time.Now())
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return false, ErrUserExists
+		}
+		return false, err
+	}
+	return rst.RowsAffected() > 0, nil
+}
+
 func (r *Repository) InitDefaultSubscription(ctx context.Context, stripeCustomerID string) error {
 	q := squirrel.Insert("user_subscriptions").
 		Columns("stripe_customer_id", "subscription_type", "status", "stripe_price_id", "stripe_subscription_id", "cancel_at_period_end", "current_period_start", "current_period_end").
@@ -238,18 +244,33 @@ func (r *Repository) InitDefaultSubscription(ctx context.Context, stripeCustomer
 	return err
 }
 
+// StoreTOTPSecret stages a new TOTP secret, to be confirmed by EnableTOTP.
+// The secret of a user with TOTP already enabled is never replaced: it
+// returns ErrTOTPAlreadyEnabled.
 func (r *Repository) StoreTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	encrypted, err := r.keyring.Encrypt(totpSecretColumn.Field(), secret)
+	if err != nil {
+		return err
+	}
+
 	q := squirrel.Update("users").
-		Set("two_fa_secret", secret).
+		Set("two_fa_secret", encrypted).
 		Where(squirrel.Eq{"id": userID}).
+		Where("two_fa_enabled IS NOT TRUE").
 		PlaceholderFormat(squirrel.Dollar)
 
 	query, args, err := q.ToSql()
 	if err != nil {
 		return err
 	}
-	_, err = r.pool.Exec(ctx, query, args...)
-	return err
+	tag, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+	return nil
 }
 
 func (r *Repository) RetrieveTOTPSecret(ctx context.Context, userID uuid.UUID) (string, error) {
@@ -258,22 +279,40 @@ func (r *Repository) RetrieveTOTPSecret(ctx context.Context, userID uuid.UUID) (
 	if err != nil {
 		return "", err
 	}
-	var secret string
+	var secret *string
 	err = r.pool.QueryRow(ctx, query, args...).Scan(&secret)
-	if errors.Is(err, pgx.ErrNoRows) {
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && secret == nil) {
 		return "", nil
 	}
-	return secret, err
+	if err != nil {
+		return "", err
+	}
+	return r.keyring.Decrypt(totpSecretColumn.Field(), *secret)
 }
 
-func (r *Repository) EnableTOTP(ctx context.Context, userID uuid.UUID) error {
-	q := squirrel.Update("users").Set("two_fa_enabled", true).Where(squirrel.Eq{"id": userID}).PlaceholderFormat(squirrel.Dollar)
-	query, args, err := q.ToSql()
+// EnableTOTP turns TOTP on and stores the first recovery codes, in one
+// transaction. It fails with ErrTOTPAlreadyEnabled when TOTP is already on, so
+// the existing codes are never replaced behind the user's back.
+func (r *Repository) EnableTOTP(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	tx, err := r.pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	_, err = r.pool.Exec(ctx, query, args...)
-	return err
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, `
+		UPDATE users SET two_fa_enabled = TRUE
+		WHERE id = $1 AND two_fa_enabled IS NOT TRUE`, userID)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 func (r *Repository) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
@@ -289,3 +328,336 @@ func (r *Repository) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
 	_, err = r.pool.Exec(ctx, query, args...)
 	return err
 }
+
+// ConsumeTOTPStep records that the code of the given time step was used. It
+// returns false when that step or a later one was already used, so a code
+// can't be replayed.
+func (r *Repository) ConsumeTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	q := squirrel.Update("users").
+		Set("totp_last_step", step).
+		Where(squirrel.Eq{"id": userID}).
+		Where(squirrel.Or{squirrel.Eq{"totp_last_step": nil}, squirrel.Lt{"totp_last_step": step}}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return false, err
+	}
+	rst, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	return rst.RowsAffected() > 0, nil
+}
+
+// TOTPLockedUntil returns the end of the TOTP lockout of the user, nil when
+// the user isn't locked out.
+func (r *Repository) TOTPLockedUntil(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	q := squirrel.Select("totp_locked_until").
+		From("users").
+		Where(squirrel.Eq{"id": userID}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var lockedUntil *time.Time
+	err = r.pool.QueryRow(ctx, query, args...).Scan(&lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && (lockedUntil == nil || lockedUntil.Before(time.Now()))) {
+		return nil, nil
+	}
+	return lockedUntil, err
+}
+
+// RecordTOTPFailure counts a wrong TOTP code. The user is locked out for
+// lockout once maxAttempts failures add up, which resets the count.
+func (r *Repository) RecordTOTPFailure(ctx context.Context, userID uuid.UUID, maxAttempts int, lockout time.Duration) error {
+	query := `
+		UPDATE users
+		SET totp_failed_attempts = CASE WHEN totp_failed_attempts + 1 >= $2 THEN 0 ELSE totp_failed_attempts + 1 END,
+			totp_locked_until = CASE WHEN totp_failed_attempts + 1 >= $2 THEN $3 ELSE totp_locked_until END
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, userID, maxAttempts, time.Now().Add(lockout))
+	return err
+}
+
+func (r *Repository) ResetTOTPFailures(ctx context.Context, userID uuid.UUID) error {
+	q := squirrel.Update("users").
+		Set("totp_failed_attempts", 0).
+		Set("totp_locked_until", nil).
+		Where(squirrel.Eq{"id": userID}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.pool.Exec(ctx, query, args...)
+	return err
+}
+
+// ReplaceRecoveryCodes swaps the recovery codes of the user for the given
+// hashes.
+func (r *Repository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uuid.UUID, hashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	q := squirrel.Insert("totp_recovery_codes").Columns("user_id", "code_hash").PlaceholderFormat(squirrel.Dollar)
+	for _, hash := range hashes {
+		q = q.Values(userID, hash)
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, query, args...)
+	return err
+}
+
+// ListUnusedRecoveryCodes returns the hashes of the recovery codes the user
+// can still use, by id.
+func (r *Repository) ListUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]string, error) {
+	q := squirrel.Select("id", "code_hash").
+		From("totp_recovery_codes").
+		Where(squirrel.Eq{"user_id": userID, "used_at": nil}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		codes[id] = hash
+	}
+	return codes, rows.Err()
+}
+
+// UseRecoveryCode marks a recovery code as used. It returns false when the
+// code was already used, by a concurrent login for instance.
+func (r *Repository) UseRecoveryCode(ctx context.Context, codeID uuid.UUID) (bool, error) {
+	q := squirrel.Update("totp_recovery_codes").
+		Set("used_at", time.Now()).
+		Where(squirrel.Eq{"id": codeID, "used_at": nil}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return false, err
+	}
+	rst, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	return rst.RowsAffected() > 0, nil
+}
+
+func (r *Repository) CountUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	q := squirrel.Select("COUNT(*)").
+		From("totp_recovery_codes").
+		Where(squirrel.Eq{"user_id": userID, "used_at": nil}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = r.pool.QueryRow(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+const sessionColumns = `id, user_id, refresh_token_hash, device_name, user_agent, ip, created_at, last_used_at,
+	expires_at, revoked_at`
+
+func scanSession(row pgx.Row) (*Session, error) {
+	var s Session
+	err := row.Scan(&s.ID, &s.UserID, &s.RefreshTokenHash, &s.DeviceName, &s.UserAgent, &s.IP,
+		&s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *Repository) CreateSession(ctx context.Context, session *Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, refresh_token_hash, device_name, user_agent, ip, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8)
+	`
+	_, err := r.pool.Exec(ctx, query, session.ID, session.UserID, session.RefreshTokenHash, session.DeviceName,
+		session.UserAgent, session.IP, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+func (r *Repository) GetSession(ctx context.Context, id uuid.UUID) (*Session, error) {
+	session, err := scanSession(r.pool.QueryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	return session, err
+}
+
+// RotateSessionToken replaces the refresh token of a session, provided it
+// still is oldHash, and keeps oldHash in the family history. It returns false
+// when the session was revoked or its token already rotated.
+func (r *Repository) RotateSessionToken(ctx context.Context, session *Session, oldHash string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE sessions
+		SET refresh_token_hash = $3, user_agent = $4, ip = $5, last_used_at = $6, expires_at = $7
+		WHERE id = $1 AND refresh_token_hash = $2 AND revoked_at IS NULL
+	`
+	rst, err := tx.Exec(ctx, query, session.ID, oldHash, session.RefreshTokenHash, session.UserAgent, session.IP,
+		session.LastUsedAt, session.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	if rst.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO refresh_token_history (token_hash, session_id, rotated_at) VALUES ($1, $2, $3)`,
+		oldHash, session.ID, session.LastUsedAt)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// IsRotatedRefreshToken reports whether tokenHash was once a refresh token of
+// the session and has since been rotated out.
+func (r *Repository) IsRotatedRefreshToken(ctx context.Context, sessionID uuid.UUID, tokenHash string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM refresh_token_history WHERE token_hash = $1 AND session_id = $2)`
+	err := r.pool.QueryRow(ctx, query, tokenHash, sessionID).Scan(&exists)
+	return exists, err
+}
+
+// ListActiveSessions returns the sessions of the user that are neither
+// revoked nor expired, most recently used first.
+func (r *Repository) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC`
+	rows, err := r.pool.Query(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (r *Repository) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	query := `UPDATE sessions SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	rst, err := r.pool.Exec(ctx, query, sessionID, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if rst.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of the user but keep, and
+// returns how many were revoked.
+func (r *Repository) RevokeOtherSessions(ctx context.Context, userID, keep uuid.UUID) (int, error) {
+	query := `UPDATE sessions SET revoked_at = $3 WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`
+	rst, err := r.pool.Exec(ctx, query, userID, keep, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return int(rst.RowsAffected()), nil
+}
+
+// RevokeAllSessions revokes every session of the user, and returns how many
+// were revoked.
+func (r *Repository) RevokeAllSessions(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := `UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+	rst, err := r.pool.Exec(ctx, query, userID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return int(rst.RowsAffected()), nil
+}
+
+func (r *Repository) RecordSecurityEvent(ctx context.Context, event *SecurityEvent) error {
+	query := `
+		INSERT INTO security_events (user_id, session_id, type, ip, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	return r.pool.QueryRow(ctx, query, event.UserID, event.SessionID, event.Type, event.IP, event.UserAgent,
+		event.CreatedAt).Scan(&event.ID)
+}
+
+// ListSecurityEvents returns the latest security events of the user, most
+// recent first.
+func (r *Repository) ListSecurityEvents(ctx context.Context, userID uuid.UUID, limit int) ([]*SecurityEvent, error) {
+	query := `
+		SELECT id, user_id, session_id, type, ip, user_agent, created_at
+		FROM security_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+	rows, err := r.pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.SessionID, &e.Type, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
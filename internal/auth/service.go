@@ -4,7 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"errors"
+	"figenn/internal/jwtkeys"
 	"figenn/internal/mailer"
 	"figenn/internal/payment"
 	"figenn/internal/users"
@@ -13,7 +13,7 @@ import (
 	"time"
 
 	"github.com/bluele/gcache"
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/pquerna/otp/totp"
 )
@@ -24,8 +24,6 @@ type AuthRepository interface {
 	FindUserByEmail(ctx context.Context, email string) (*users.User, error)
 	GetUserByID(ctx context.Context, id uuid.UUID) (*users.User, error)
 	InitDefaultSubscription(ctx context.Context, stripeCustomerID string) error
-	StoreRefreshToken(ctx context.Context, userID uuid.UUID, token string) error
-	CheckRefreshToken(ctx context.Context, userID uuid.UUID, token string) (bool, error)
 	SaveResetPasswordToken(ctx context.Context, userID uuid.UUID, token string) (uuid.UUID, string, error)
 	IsResetTokenValid(ctx context.Context, token string) (bool, error)
 	FindUserIDByResetToken(ctx context.Context, token string) (uuid.UUID, *string, bool, error)
@@ -33,12 +31,32 @@ type AuthRepository interface {
 	ClearResetToken(ctx context.Context, userID uuid.UUID) error
 	StoreTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error
 	RetrieveTOTPSecret(ctx context.Context, userID uuid.UUID) (string, error)
-	EnableTOTP(ctx context.Context, userID uuid.UUID) error
+	EnableTOTP(ctx context.Context, userID uuid.UUID, recoveryCodeHashes []string) error
 	DisableTOTP(ctx context.Context, userID uuid.UUID) error
+	ConsumeTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	TOTPLockedUntil(ctx context.Context, userID uuid.UUID) (*time.Time, error)
+	RecordTOTPFailure(ctx context.Context, userID uuid.UUID, maxAttempts int, lockout time.Duration) error
+	ResetTOTPFailures(ctx context.Context, userID uuid.UUID) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error
+	ListUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]string, error)
+	UseRecoveryCode(ctx context.Context, codeID uuid.UUID) (bool, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error)
+	CreateSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, id uuid.UUID) (*Session, error)
+	RotateSessionToken(ctx context.Context, session *Session, oldHash string) (bool, error)
+	IsRotatedRefreshToken(ctx context.Context, sessionID uuid.UUID, tokenHash string) (bool, error)
+	ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]*Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error
+	RevokeOtherSessions(ctx context.Context, userID, keep uuid.UUID) (int, error)
+	RevokeAllSessions(ctx context.Context, userID uuid.UUID) (int, error)
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string) (bool, error)
+	UpdateUserEmail(ctx context.Context, userID uuid.UUID, oldEmail, newEmail string) (bool, error)
+	RecordSecurityEvent(ctx context.Context, event *SecurityEvent) error
+	ListSecurityEvents(ctx context.Context, userID uuid.UUID, limit int) ([]*SecurityEvent, error)
 }
 
 type Config struct {
-	JWTSecret            string
+	JWTKeys              *jwtkeys.KeySet
 	TokenDuration        time.Duration
 	RefreshTokenDuration time.Duration
 	AppURL               string
@@ -51,6 +69,8 @@ type Service struct {
 	config Config
 	cache  gcache.Cache
 	mailer mailer.Mailer
+
+	userHooks []UserHook
 }
 
 func NewService(repo AuthRepository, config *Config, mailerClient mailer.Mailer, paymentService *payment.Service) *Service {
@@ -107,17 +127,23 @@ func (s *Service) Register(ctx context.Context, req RegisterRequest) (*RegisterR
 	}
 
 	_ = s.cache.SetWithExpire(newUser.Email, newUser, 5*time.Minute)
-	go utils.SendWelcomeEmail(s.mailer, newUser)
+	verifyURL, err := s.verificationURL(newUser)
+	if err != nil {
+		return nil, ErrInternalServer
+	}
+	go sendWelcomeEmail(s.mailer, newUser, verifyURL)
 
 	return &RegisterResponse{Message: "User created successfully"}, nil
 }
 
-func (s *Service) Login(ctx context.Context, req LoginRequest, w http.ResponseWriter) (*string, *string, error) {
+// Login checks the credentials of a user. Users with TOTP enabled only get a
+// challenge token, see LoginTOTP.
+func (s *Service) Login(ctx context.Context, req LoginRequest, device DeviceInfo) (*LoginTokens, error) {
 	if req.Email == "" || req.Password == "" {
-		return nil, nil, ErrMissingFields
+		return nil, ErrMissingFields
 	}
 	if !utils.IsValidEmail(req.Email) {
-		return nil, nil, ErrInvalidEmail
+		return nil, ErrInvalidEmail
 	}
 
 	var user *users.User
@@ -130,76 +156,108 @@ func (s *Service) Login(ctx context.Context, req LoginRequest, w http.ResponseWr
 	if user == nil {
 		userFromDB, err := s.repo.FindUserByEmail(ctx, req.Email)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		_ = s.cache.SetWithExpire(req.Email, userFromDB, time.Minute*5)
 		user = userFromDB
 	}
 	if !utils.ComparePassword(user.Password, req.Password) {
-		return nil, nil, ErrInvalidCredentials
+		return nil, ErrInvalidCredentials
 	}
 
-	accessToken, err := generateToken(user, s.config.JWTSecret, s.config.TokenDuration)
-	if err != nil {
-		return nil, nil, err
+	if user.TwoFAEnabled {
+		challengeToken, err := generateChallengeToken(user, s.config.JWTKeys, mfaChallengeDuration)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginTokens{ChallengeToken: challengeToken}, nil
 	}
-	refreshToken, err := generateRefreshToken(user, s.config.JWTSecret, s.config.RefreshTokenDuration)
+	return s.issueTokens(ctx, user, device)
+}
+
+// issueTokens opens a new session for the device.
+func (s *Service) issueTokens(ctx context.Context, user *users.User, device DeviceInfo) (*LoginTokens, error) {
+	sessionID := uuid.New()
+	accessToken, err := generateToken(user, sessionID, s.config.JWTKeys, s.config.TokenDuration)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	if err := s.repo.StoreRefreshToken(ctx, user.ID, refreshToken); err != nil {
-		return nil, nil, ErrInternalServer
+	refreshToken, err := generateRefreshToken(user, sessionID, s.config.JWTKeys, s.config.RefreshTokenDuration)
+	if err != nil {
+		return nil, err
 	}
-	return &accessToken, &refreshToken, nil
-}
 
-func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*string, *string, error) {
-	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(s.config.JWTSecret), nil
-	})
-	if err != nil || !token.Valid {
-		return nil, nil, ErrInvalidToken
-	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, nil, ErrInvalidToken
+	now := time.Now()
+	session := &Session{
+		ID:               sessionID,
+		UserID:           user.ID,
+		DeviceName:       describeDevice(device.UserAgent),
+		UserAgent:        device.UserAgent,
+		IP:               device.IP,
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(s.config.RefreshTokenDuration),
+		RefreshTokenHash: hashToken(refreshToken),
 	}
-	userIDStr, ok := claims["user_id"].(string)
-	if !ok {
-		return nil, nil, ErrInvalidToken
+	if err := s.repo.CreateSession(ctx, session); err != nil {
+		return nil, ErrInternalServer
 	}
-	userID, err := uuid.Parse(userIDStr)
+	return &LoginTokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// RefreshToken rotates the refresh token of a session. Presenting a token
+// that was already rotated out means it leaked: see revokeFamily.
+func (s *Service) RefreshToken(ctx context.Context, refreshToken string, device DeviceInfo) (*string, *string, error) {
+	claims, err := parseRefreshToken(refreshToken, s.config.JWTKeys)
 	if err != nil {
-		return nil, nil, ErrInvalidToken
+		return nil, nil, err
 	}
-	tokenType, ok := claims["type"].(string)
-	if !ok || tokenType != "refresh" {
+	session, err := s.repo.GetSession(ctx, claims.sessionID)
+	if err != nil || session.UserID != claims.userID || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
 		return nil, nil, ErrInvalidToken
 	}
 
-	valid, err := s.repo.CheckRefreshToken(ctx, userID, refreshToken)
-	if err != nil || !valid {
-		return nil, nil, ErrInvalidToken
+	tokenHash := hashToken(refreshToken)
+	if session.RefreshTokenHash != tokenHash {
+		reused, err := s.repo.IsRotatedRefreshToken(ctx, session.ID, tokenHash)
+		if err != nil {
+			return nil, nil, ErrInternalServer
+		}
+		if !reused {
+			return nil, nil, ErrInvalidToken
+		}
+		if err := s.revokeFamily(ctx, session, device); err != nil {
+			return nil, nil, ErrInternalServer
+		}
+		return nil, nil, ErrRefreshTokenReused
 	}
-	user, err := s.repo.GetUserByID(ctx, userID)
+
+	user, err := s.repo.GetUserByID(ctx, claims.userID)
 	if err != nil {
 		return nil, nil, ErrUserNotFound
 	}
-
-	newAccessToken, err := generateToken(user, s.config.JWTSecret, s.config.TokenDuration)
+	newAccessToken, err := generateToken(user, session.ID, s.config.JWTKeys, s.config.TokenDuration)
 	if err != nil {
 		return nil, nil, ErrInternalServer
 	}
-	newRefreshToken, err := generateRefreshToken(user, s.config.JWTSecret, s.config.RefreshTokenDuration)
+	newRefreshToken, err := generateRefreshToken(user, session.ID, s.config.JWTKeys, s.config.RefreshTokenDuration)
 	if err != nil {
 		return nil, nil, ErrInternalServer
 	}
-	if err := s.repo.StoreRefreshToken(ctx, user.ID, newRefreshToken); err != nil {
+
+	now := time.Now()
+	session.RefreshTokenHash = hashToken(newRefreshToken)
+	session.UserAgent = device.UserAgent
+	session.IP = device.IP
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(s.config.RefreshTokenDuration)
+	rotated, err := s.repo.RotateSessionToken(ctx, session, tokenHash)
+	if err != nil {
 		return nil, nil, ErrInternalServer
 	}
+	if !rotated {
+		return nil, nil, ErrInvalidToken
+	}
 	return &newAccessToken, &newRefreshToken, nil
 }
 
@@ -227,10 +285,42 @@ func (s *Service) ForgotPassword(ctx context.Context, req ForgotPasswordRequest)
 		return ErrInternalServer
 	}
 	resetURL := s.config.AppURL + "/auth/reset-password?token=" + token
-	go utils.SendResetPasswordEmail(s.mailer, user, resetURL)
+	go sendResetPasswordEmail(s.mailer, user, resetURL)
 	return nil
 }
 
+// SendUnlockEmail lets the owner of a locked out email know, with a link to
+// unlock it. Unknown addresses are ignored.
+func (s *Service) SendUnlockEmail(ctx context.Context, email string, until time.Time) {
+	user, err := s.repo.FindUserByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+	token, err := s.config.JWTKeys.Sign(jwtkeys.AudienceUnlock, jwt.MapClaims{
+		"email": email,
+		"exp":   until.Unix(),
+		"iat":   time.Now().Unix(),
+	})
+	if err != nil {
+		return
+	}
+	unlockURL := s.config.AppURL + "/auth/unlock?token=" + token
+	go sendAccountLockedEmail(s.mailer, user, unlockURL, until)
+}
+
+// UnlockEmail returns the email address an unlock token was issued for.
+func (s *Service) UnlockEmail(token string) (string, error) {
+	claims, err := s.config.JWTKeys.Parse(token, jwtkeys.AudienceUnlock)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", ErrInvalidToken
+	}
+	return email, nil
+}
+
 func (s *Service) IsValidResetToken(ctx context.Context, token string) (bool, error) {
 	if token == "" {
 		return false, nil
@@ -238,6 +328,8 @@ func (s *Service) IsValidResetToken(ctx context.Context, token string) (bool, er
 	return s.repo.IsResetTokenValid(ctx, token)
 }
 
+// ResetPassword sets a new password from a reset link, and signs out every
+// session: whoever knew the old password loses access.
 func (s *Service) ResetPassword(ctx context.Context, req ResetPasswordRequest) error {
 	if req.Token == "" || req.Password == "" || !utils.IsStrongPassword(req.Password) {
 		return ErrPasswordTooWeak
@@ -255,27 +347,55 @@ func (s *Service) ResetPassword(ctx context.Context, req ResetPasswordRequest) e
 		return ErrInternalServer
 	}
 	_ = s.repo.ClearResetToken(ctx, userID)
+	if _, err := s.repo.RevokeAllSessions(ctx, userID); err != nil {
+		return ErrInternalServer
+	}
 	return nil
 }
 
-func generateToken(user *users.User, secret string, duration time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+func generateToken(user *users.User, sessionID uuid.UUID, keys *jwtkeys.KeySet, duration time.Duration) (string, error) {
+	return keys.Sign(jwtkeys.AudienceAccess, jwt.MapClaims{
 		"user_id": user.ID,
+		"sid":     sessionID,
 		"email":   user.Email,
 		"exp":     time.Now().Add(duration).Unix(),
 		"iat":     time.Now().Unix(),
 	})
-	return token.SignedString([]byte(secret))
 }
 
-func generateRefreshToken(user *users.User, secret string, duration time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// generateRefreshToken issues the refresh token of a session. The jti makes
+// each token distinct, even when rotated within the same second.
+func generateRefreshToken(user *users.User, sessionID uuid.UUID, keys *jwtkeys.KeySet, duration time.Duration) (string, error) {
+	return keys.Sign(jwtkeys.AudienceRefresh, jwt.MapClaims{
 		"user_id": user.ID,
+		"sid":     sessionID,
+		"jti":     uuid.NewString(),
 		"exp":     time.Now().Add(duration).Unix(),
-		"type":    "refresh",
 		"iat":     time.Now().Unix(),
 	})
-	return token.SignedString([]byte(secret))
+}
+
+type refreshClaims struct {
+	userID    uuid.UUID
+	sessionID uuid.UUID
+}
+
+func parseRefreshToken(refreshToken string, keys *jwtkeys.KeySet) (*refreshClaims, error) {
+	claims, err := keys.Parse(refreshToken, jwtkeys.AudienceRefresh)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sessionIDStr, _ := claims["sid"].(string)
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return &refreshClaims{userID: userID, sessionID: sessionID}, nil
 }
 
 func generateSecureToken() string {
@@ -286,6 +406,9 @@ func generateSecureToken() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
+// GenerateTOTPSecret starts the TOTP setup with a new secret. It fails with
+// ErrTOTPAlreadyEnabled once TOTP is on: the secret only changes after
+// disabling it.
 func (s *Service) GenerateTOTPSecret(ctx context.Context, userID uuid.UUID) (string, string, error) {
 	secret, err := totp.Generate(totp.GenerateOpts{Issuer: "Figenn", AccountName: "user" + userID.String()})
 	if err != nil {
@@ -297,27 +420,40 @@ func (s *Service) GenerateTOTPSecret(ctx context.Context, userID uuid.UUID) (str
 	return secret.Secret(), secret.URL(), nil
 }
 
-func (s *Service) VerifyTOTP(ctx context.Context, userID uuid.UUID, code string) error {
-	secret, err := s.repo.RetrieveTOTPSecret(ctx, userID)
+// EnableTOTP turns TOTP on once the user proves their authenticator works,
+// and returns their first set of recovery codes.
+func (s *Service) EnableTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	if err := s.VerifyTOTP(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateHashedRecoveryCodes()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !totp.Validate(code, secret) {
-		return ErrInvalidTOTPCode
+	if err := s.repo.EnableTOTP(ctx, userID, hashes); err != nil {
+		return nil, err
 	}
-	return nil
+	s.forgetUser(ctx, userID)
+	return codes, nil
 }
 
-func (s *Service) EnableTOTP(ctx context.Context, userID uuid.UUID, code string) error {
-	if err := s.VerifyTOTP(ctx, userID, code); err != nil {
+// DisableTOTP turns TOTP off given a TOTP or recovery code, and drops the
+// recovery codes.
+func (s *Service) DisableTOTP(ctx context.Context, userID uuid.UUID, req TOTPRequest) error {
+	if err := s.verifySecondFactor(ctx, userID, req); err != nil {
 		return err
 	}
-	return s.repo.EnableTOTP(ctx, userID)
+	if err := s.repo.DisableTOTP(ctx, userID); err != nil {
+		return err
+	}
+	s.forgetUser(ctx, userID)
+	return s.repo.ReplaceRecoveryCodes(ctx, userID, nil)
 }
 
-func (s *Service) DisableTOTP(ctx context.Context, userID uuid.UUID, code string) error {
-	if err := s.VerifyTOTP(ctx, userID, code); err != nil {
-		return err
+// forgetUser drops the user from the login cache so a change of their
+// account applies to the next login.
+func (s *Service) forgetUser(ctx context.Context, userID uuid.UUID) {
+	if user, err := s.repo.GetUserByID(ctx, userID); err == nil {
+		_ = s.cache.Remove(user.Email)
 	}
-	return s.repo.DisableTOTP(ctx, userID)
 }
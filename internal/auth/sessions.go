@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a signed-in device. Each session is a refresh token family: its
+// token is rotated on every refresh and the tokens rotated out are kept, so
+// replaying one revokes the session. Only hashes of the tokens are stored.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+	// Current marks the session making the request.
+	Current bool `json:"current"`
+
+	RefreshTokenHash string `json:"-"`
+}
+
+// SecurityEventType names something that happened to an account which its
+// owner should be able to review.
+type SecurityEventType string
+
+const (
+	// SecurityEventRefreshTokenReuse is recorded when a rotated-out refresh
+	// token is presented, which means it was stolen.
+	SecurityEventRefreshTokenReuse SecurityEventType = "refresh_token_reuse"
+)
+
+// maxSecurityEvents bounds the events returned to the user.
+const maxSecurityEvents = 50
+
+type SecurityEvent struct {
+	ID        uuid.UUID         `json:"id"`
+	UserID    uuid.UUID         `json:"-"`
+	SessionID *uuid.UUID        `json:"session_id,omitempty"`
+	Type      SecurityEventType `json:"type"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// DeviceInfo describes the client signing in or refreshing its session.
+type DeviceInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// ListSessions returns the active sessions of the user, flagging current.
+func (s *Service) ListSessions(ctx context.Context, userID, current uuid.UUID) ([]*Session, error) {
+	sessions, err := s.repo.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == current
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs a device out. Its access token stays valid until it
+// expires, TokenDuration at most.
+func (s *Service) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	return s.repo.RevokeSession(ctx, userID, sessionID)
+}
+
+// RevokeOtherSessions signs out every device of the user but current. Tokens
+// issued before sessions existed don't say which session is current: the
+// user has to sign in again first, or they would be signed out too.
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID, current uuid.UUID) (int, error) {
+	if current == uuid.Nil {
+		return 0, ErrUnknownSession
+	}
+	return s.repo.RevokeOtherSessions(ctx, userID, current)
+}
+
+// LogoutSession revokes the session owning refreshToken, if it is still
+// valid. Logging out with a stale token is not an error.
+func (s *Service) LogoutSession(ctx context.Context, refreshToken string) error {
+	claims, err := parseRefreshToken(refreshToken, s.config.JWTKeys)
+	if err != nil {
+		return nil
+	}
+	session, err := s.repo.GetSession(ctx, claims.sessionID)
+	if err != nil || session.RevokedAt != nil || session.RefreshTokenHash != hashToken(refreshToken) {
+		return nil
+	}
+	return s.repo.RevokeSession(ctx, session.UserID, session.ID)
+}
+
+// ListSecurityEvents returns the latest security events of the user.
+func (s *Service) ListSecurityEvents(ctx context.Context, userID uuid.UUID) ([]*SecurityEvent, error) {
+	return s.repo.ListSecurityEvents(ctx, userID, maxSecurityEvents)
+}
+
+// revokeFamily handles the replay of a rotated-out refresh token. Either the
+// legitimate client or an attacker holds the current token, and there's no
+// telling which: the whole session is revoked and its owner warned.
+func (s *Service) revokeFamily(ctx context.Context, session *Session, device DeviceInfo) error {
+	if err := s.repo.RevokeSession(ctx, session.UserID, session.ID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+
+	sessionID := session.ID
+	event := &SecurityEvent{
+		UserID:    session.UserID,
+		SessionID: &sessionID,
+		Type:      SecurityEventRefreshTokenReuse,
+		IP:        device.IP,
+		UserAgent: device.UserAgent,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.RecordSecurityEvent(ctx, event); err != nil {
+		return err
+	}
+
+	user, err := s.repo.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		return err
+	}
+	go sendSessionRevokedEmail(s.mailer, user, session.DeviceName, device.IP)
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// describeDevice names the browser and system of a user agent, such as
+// "Firefox on Windows", for users to recognize their sessions.
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"}, {"opr/", "Opera"}, {"firefox/", "Firefox"}, {"fxios/", "Firefox"},
+		{"crios/", "Chrome"}, {"chrome/", "Chrome"}, {"safari/", "Safari"}, {"okhttp", "Android app"},
+		{"cfnetwork", "iOS app"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := ""
+	for _, o := range []struct{ token, name string }{
+		{"iphone", "iPhone"}, {"ipad", "iPad"}, {"android", "Android"}, {"windows", "Windows"},
+		{"mac os x", "macOS"}, {"macintosh", "macOS"}, {"cros", "ChromeOS"}, {"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			system = o.name
+			break
+		}
+	}
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeDevice(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:126.0) Gecko/20100101 Firefox/126.0":                                          "Firefox on Windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15":        "Safari on macOS",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/125.0 Mobile/15E148": "Chrome on iPhone",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36 Edg/125.0.0.0":       "Edge on Linux",
+		"": "Unknown browser",
+	}
+	for userAgent, want := range tests {
+		assert.Equal(t, want, describeDevice(userAgent), userAgent)
+	}
+}
+
+func TestRefreshTokenClaims(t *testing.T) {
+	user := &users.User{ID: uuid.New(), Email: "jane@example.com"}
+	sessionID := uuid.New()
+	keys := jwtkeys.NewHMACKeySet("secret")
+
+	first, err := generateRefreshToken(user, sessionID, keys, time.Minute)
+	assert.NoError(t, err)
+	second, err := generateRefreshToken(user, sessionID, keys, time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hashToken(first), hashToken(second))
+
+	claims, err := parseRefreshToken(first, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, claims.userID)
+	assert.Equal(t, sessionID, claims.sessionID)
+
+	access, _ := generateToken(user, sessionID, keys, time.Minute)
+	_, err = parseRefreshToken(access, keys)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRevokeOtherSessionsNeedsTheCurrentSession(t *testing.T) {
+	// Without a repository, reaching it would panic.
+	s := &Service{}
+	_, err := s.RevokeOtherSessions(context.Background(), uuid.New(), uuid.Nil)
+	assert.ErrorIs(t, err, ErrUnknownSession)
+
+	req := ChangePasswordRequest{CurrentPassword: "Old-password1", NewPassword: "New-password1"}
+	assert.ErrorIs(t, s.ChangePassword(context.Background(), uuid.New(), uuid.Nil, req), ErrUnknownSession)
+}
+
+func TestAccessTokenLastsTokenDuration(t *testing.T) {
+	user := &users.User{ID: uuid.New(), Email: "jane@example.com"}
+	keys := jwtkeys.NewHMACKeySet("secret")
+
+	token, err := generateToken(user, uuid.New(), keys, 30*time.Minute)
+	assert.NoError(t, err)
+	claims, err := keys.Parse(token, jwtkeys.AudienceAccess)
+	assert.NoError(t, err)
+	exp, err := claims.GetExpirationTime()
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), exp.Time, 5*time.Second)
+}
+
+// resetPasswordRepository accepts any reset token and records which users
+// had their sessions revoked.
+type resetPasswordRepository struct {
+	AuthRepository
+	userID  uuid.UUID
+	revoked []uuid.UUID
+}
+
+func (r *resetPasswordRepository) FindUserIDByResetToken(ctx context.Context, token string) (uuid.UUID, *string, bool, error) {
+	email := "jane@example.com"
+	return r.userID, &email, true, nil
+}
+
+func (r *resetPasswordRepository) UpdateUserPassword(ctx context.Context, userID uuid.UUID, hashed string) error {
+	return nil
+}
+
+func (r *resetPasswordRepository) ClearResetToken(ctx context.Context, userID uuid.UUID) error {
+	return nil
+}
+
+func (r *resetPasswordRepository) RevokeAllSessions(ctx context.Context, userID uuid.UUID) (int, error) {
+	r.revoked = append(r.revoked, userID)
+	return 1, nil
+}
+
+func TestResetPasswordRevokesAllSessions(t *testing.T) {
+	repo := &resetPasswordRepository{userID: uuid.New()}
+	s := NewService(repo, &Config{}, nil, nil)
+
+	err := s.ResetPassword(context.Background(), ResetPasswordRequest{Token: "token", Password: "New-password1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{repo.userID}, repo.revoked)
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// mfaChallengeDuration is how long a user has to enter their TOTP code
+	// after the password step.
+	mfaChallengeDuration = 5 * time.Minute
+	// maxTOTPAttempts wrong codes in a row lock TOTP verification for
+	// totpLockout.
+	maxTOTPAttempts = 5
+	totpLockout     = 15 * time.Minute
+	totpPeriod      = 30
+	// totpSkew is the number of periods accepted before and after the
+	// current one, to tolerate clock drift.
+	totpSkew = 1
+)
+
+// LoginTokens holds what Login issues. For users with TOTP enabled only
+// ChallengeToken is set, to be exchanged with a code through LoginTOTP.
+type LoginTokens struct {
+	AccessToken    string
+	RefreshToken   string
+	ChallengeToken string
+}
+
+// LoginTOTP completes the login of a user with TOTP enabled, exchanging the
+// challenge token returned by Login and a TOTP or recovery code for the
+// session tokens.
+func (s *Service) LoginTOTP(ctx context.Context, req LoginTOTPRequest, device DeviceInfo) (*LoginTokens, error) {
+	if req.ChallengeToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return nil, ErrMissingFields
+	}
+	userID, err := parseChallengeToken(req.ChallengeToken, s.config.JWTKeys)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !user.TwoFAEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	if err := s.verifySecondFactor(ctx, userID, TOTPRequest{Code: req.Code, RecoveryCode: req.RecoveryCode}); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(ctx, user, device)
+}
+
+// VerifyTOTP checks a TOTP code of the user. Each code is accepted once, and
+// too many wrong codes lock verification for a while.
+func (s *Service) VerifyTOTP(ctx context.Context, userID uuid.UUID, code string) error {
+	if err := s.checkTOTPLockout(ctx, userID); err != nil {
+		return err
+	}
+
+	secret, err := s.repo.RetrieveTOTPSecret(ctx, userID)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTPStep(secret, code, time.Now())
+	if !ok {
+		if err := s.repo.RecordTOTPFailure(ctx, userID, maxTOTPAttempts, totpLockout); err != nil {
+			return err
+		}
+		return ErrInvalidTOTPCode
+	}
+
+	fresh, err := s.repo.ConsumeTOTPStep(ctx, userID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrTOTPCodeReused
+	}
+	return s.repo.ResetTOTPFailures(ctx, userID)
+}
+
+func (s *Service) checkTOTPLockout(ctx context.Context, userID uuid.UUID) error {
+	lockedUntil, err := s.repo.TOTPLockedUntil(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil {
+		return ErrTooManyTOTPAttempts
+	}
+	return nil
+}
+
+// matchTOTPStep returns the time step whose code is code, looking at the
+// steps around now.
+func matchTOTPStep(secret, code string, now time.Time) (int64, bool) {
+	if secret == "" || code == "" {
+		return 0, false
+	}
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), opts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func generateChallengeToken(user *users.User, keys *jwtkeys.KeySet, duration time.Duration) (string, error) {
+	return keys.Sign(jwtkeys.AudienceMFAPending, jwt.MapClaims{
+		"user_id": user.ID,
+		"exp":     time.Now().Add(duration).Unix(),
+		"iat":     time.Now().Unix(),
+	})
+}
+
+func parseChallengeToken(challengeToken string, keys *jwtkeys.KeySet) (uuid.UUID, error) {
+	claims, err := keys.Parse(challengeToken, jwtkeys.AudienceMFAPending)
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return userID, nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTOTPStep(t *testing.T) {
+	secret := "JBSWY3DPEHPK3PXP"
+	now := time.Unix(1747000000, 0)
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+	code, _ := totp.GenerateCodeCustom(secret, now, opts)
+	step, ok := matchTOTPStep(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/totpPeriod, step)
+
+	previous, _ := totp.GenerateCodeCustom(secret, now.Add(-totpPeriod*time.Second), opts)
+	step, ok = matchTOTPStep(secret, previous, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/totpPeriod-1, step)
+
+	stale, _ := totp.GenerateCodeCustom(secret, now.Add(-5*time.Minute), opts)
+	_, ok = matchTOTPStep(secret, stale, now)
+	assert.False(t, ok)
+}
+
+func TestChallengeToken(t *testing.T) {
+	user := &users.User{ID: uuid.New()}
+	keys := jwtkeys.NewHMACKeySet("secret")
+	token, err := generateChallengeToken(user, keys, time.Minute)
+	assert.NoError(t, err)
+
+	userID, err := parseChallengeToken(token, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, userID)
+
+	_, err = parseChallengeToken(token, jwtkeys.NewHMACKeySet("other-secret"))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	refresh, _ := generateRefreshToken(user, uuid.New(), keys, time.Minute)
+	_, err = parseChallengeToken(refresh, keys)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	assert.Regexp(t, `^[a-z2-9]{5}-[a-z2-9]{5}$`, codes[0])
+	assert.NotEqual(t, codes[0], codes[1])
+
+	assert.Equal(t, "abcdefghjk", normalizeRecoveryCode(" ABCDE-fghjk "))
+	assert.Equal(t, normalizeRecoveryCode(codes[0]), normalizeRecoveryCode(strings.ToUpper(codes[0])))
+}
+
+// enabledTOTPRepository is a user with TOTP on: staging a secret fails like
+// the conditional update of Repository.StoreTOTPSecret.
+type enabledTOTPRepository struct {
+	AuthRepository
+}
+
+func (enabledTOTPRepository) StoreTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	return ErrTOTPAlreadyEnabled
+}
+
+func TestGenerateTOTPSecretOnceEnabled(t *testing.T) {
+	s := &Service{repo: enabledTOTPRepository{}}
+	secret, url, err := s.GenerateTOTPSecret(context.Background(), uuid.New())
+	assert.ErrorIs(t, err, ErrTOTPAlreadyEnabled)
+	assert.Empty(t, secret)
+	assert.Empty(t, url)
+}
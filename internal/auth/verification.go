@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// emailVerificationDuration is how long the link of a verification email
+// stays valid.
+const emailVerificationDuration = 48 * time.Hour
+
+// UserHook is called after the account of a user changed, for instance to
+// evict caches holding it.
+type UserHook func(ctx context.Context, userID uuid.UUID)
+
+// AddUserHook registers a hook called whenever the service changes a user.
+func (s *Service) AddUserHook(hook UserHook) {
+	s.userHooks = append(s.userHooks, hook)
+}
+
+// userChanged evicts the user from the login cache and runs the hooks.
+func (s *Service) userChanged(ctx context.Context, userID uuid.UUID) {
+	s.forgetUser(ctx, userID)
+	for _, hook := range s.userHooks {
+		hook(ctx, userID)
+	}
+}
+
+// VerifyEmail confirms the address a verification token was sent to. The
+// token is refused if the user changed their address since.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	userID, email, err := parseVerificationToken(token, s.config.JWTKeys)
+	if err != nil {
+		return err
+	}
+	verified, err := s.repo.MarkEmailVerified(ctx, userID, email)
+	if err != nil {
+		return ErrInternalServer
+	}
+	if !verified {
+		return ErrInvalidToken
+	}
+	s.userChanged(ctx, userID)
+	return nil
+}
+
+// ResendVerificationEmail sends a new verification link to the user.
+func (s *Service) ResendVerificationEmail(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+	verifyURL, err := s.verificationURL(user)
+	if err != nil {
+		return ErrInternalServer
+	}
+	go sendVerificationEmail(s.mailer, user, verifyURL)
+	return nil
+}
+
+func (s *Service) verificationURL(user *users.User) (string, error) {
+	token, err := generateVerificationToken(user, s.config.JWTKeys, emailVerificationDuration)
+	if err != nil {
+		return "", err
+	}
+	return s.config.AppURL + "/auth/verify-email?token=" + token, nil
+}
+
+func generateVerificationToken(user *users.User, keys *jwtkeys.KeySet, duration time.Duration) (string, error) {
+	return keys.Sign(jwtkeys.AudienceVerifyEmail, jwt.MapClaims{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"exp":     time.Now().Add(duration).Unix(),
+		"iat":     time.Now().Unix(),
+	})
+}
+
+func parseVerificationToken(verificationToken string, keys *jwtkeys.KeySet) (uuid.UUID, string, error) {
+	claims, err := keys.Parse(verificationToken, jwtkeys.AudienceVerifyEmail)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	return userID, email, nil
+}
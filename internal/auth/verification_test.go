@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerificationToken(t *testing.T) {
+	keys := jwtkeys.NewHMACKeySet("secret")
+	user := &users.User{ID: uuid.New(), Email: "jane@example.com"}
+
+	token, err := generateVerificationToken(user, keys, time.Minute)
+	assert.NoError(t, err)
+	userID, email, err := parseVerificationToken(token, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, userID)
+	assert.Equal(t, user.Email, email)
+
+	expired, _ := generateVerificationToken(user, keys, -time.Minute)
+	_, _, err = parseVerificationToken(expired, keys)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	challenge, _ := generateChallengeToken(user, keys, time.Minute)
+	_, _, err = parseVerificationToken(challenge, keys)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,9 @@
+package bank
+
+import "errors"
+
+var (
+	ErrNoBankLink         = errors.New("user has no bank link")
+	ErrInvalidFilter      = errors.New("invalid transaction filter")
+	ErrConnectionNotFound = errors.New("bank connection not found")
+)
@@ -0,0 +1,243 @@
+package bank
+
+import (
+	"figenn/internal/errors"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/users"
+	"figenn/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// maxPageSize bounds the number of transactions returned at once.
+const maxPageSize = 100
+
+type API struct {
+	JWTKeys *jwtkeys.KeySet
+	s       *Service
+	users   *users.Service
+}
+
+func NewAPI(keys *jwtkeys.KeySet, service *Service, userService *users.Service) *API {
+	return &API{JWTKeys: keys, s: service, users: userService}
+}
+
+func (a *API) Bind(rg *echo.Group) {
+	bankGroup := rg.Group("/bank", users.CookieAuthMiddleware(a.JWTKeys))
+
+	bankGroup.GET("/accounts", a.ListAccounts)
+	bankGroup.GET("/transactions", a.ListTransactions)
+	bankGroup.POST("/sync", a.Sync)
+
+	bankGroup.GET("/connections", a.ListConnections)
+	bankGroup.DELETE("/connections/:id", a.DeleteConnection)
+	bankGroup.POST("/connections/:id/sync", a.SyncConnection)
+	bankGroup.POST("/connections/:id/reconnect", a.ReconnectConnection, users.VerifiedEmailMiddleware(a.users))
+	bankGroup.GET("/connections/:id/events", a.ListConnectionEvents)
+}
+
+func (a *API) ListAccounts(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := a.s.ListAccounts(c.Request().Context(), userID)
+	if err != nil {
+		return errors.NewInternalServerError("Failed to fetch bank accounts")
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// ListTransactions accepts the account_id, from, to (YYYY-MM-DD), search,
+// min_amount, max_amount and type filters along with page and limit.
+func (a *API) ListTransactions(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	limit, offset, err := utils.GetPaginationParams(c)
+	if err != nil {
+		return errors.NewBadRequestError(err.Error())
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	filter := TransactionFilter{
+		Search: c.QueryParam("search"),
+		Type:   c.QueryParam("type"),
+		Limit:  limit,
+		Offset: offset,
+	}
+	if v := c.QueryParam("account_id"); v != "" {
+		accountID, err := uuid.Parse(v)
+		if err != nil {
+			return errors.NewBadRequestError("Invalid account ID")
+		}
+		filter.AccountID = &accountID
+	}
+	if filter.From, err = optionalDate(c, "from"); err != nil {
+		return errors.NewBadRequestError("Invalid from date, expected YYYY-MM-DD")
+	}
+	if filter.To, err = optionalDate(c, "to"); err != nil {
+		return errors.NewBadRequestError("Invalid to date, expected YYYY-MM-DD")
+	}
+	if filter.MinAmount, err = optionalFloat(c, "min_amount"); err != nil {
+		return errors.NewBadRequestError("Invalid min_amount value")
+	}
+	if filter.MaxAmount, err = optionalFloat(c, "max_amount"); err != nil {
+		return errors.NewBadRequestError("Invalid max_amount value")
+	}
+
+	page, err := a.s.ListTransactions(c.Request().Context(), userID, filter)
+	if err != nil {
+		return handleServiceError(err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func (a *API) Sync(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := a.s.Sync(c.Request().Context(), userID)
+	if err != nil {
+		return handleServiceError(err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (a *API) ListConnections(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	connections, err := a.s.ListConnections(c.Request().Context(), userID)
+	if err != nil {
+		return errors.NewInternalServerError("Failed to fetch bank connections")
+	}
+	return c.JSON(http.StatusOK, connections)
+}
+
+func (a *API) SyncConnection(c echo.Context) error {
+	userID, connectionID, err := getConnectionParams(c)
+	if err != nil {
+		return err
+	}
+
+	conn, err := a.s.SyncConnection(c.Request().Context(), userID, connectionID)
+	if err != nil {
+		return handleServiceError(err)
+	}
+	return c.JSON(http.StatusOK, conn)
+}
+
+// ReconnectConnection returns the webview URL where the user authenticates
+// again on a connection whose status isn't valid.
+func (a *API) ReconnectConnection(c echo.Context) error {
+	userID, connectionID, err := getConnectionParams(c)
+	if err != nil {
+		return err
+	}
+
+	url, err := a.s.ReconnectURL(c.Request().Context(), userID, connectionID)
+	if err != nil {
+		return handleServiceError(err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"url": url})
+}
+
+func (a *API) DeleteConnection(c echo.Context) error {
+	userID, connectionID, err := getConnectionParams(c)
+	if err != nil {
+		return err
+	}
+
+	if err := a.s.DeleteConnection(c.Request().Context(), userID, connectionID); err != nil {
+		return handleServiceError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *API) ListConnectionEvents(c echo.Context) error {
+	userID, connectionID, err := getConnectionParams(c)
+	if err != nil {
+		return err
+	}
+
+	events, err := a.s.ListConnectionEvents(c.Request().Context(), userID, connectionID)
+	if err != nil {
+		return handleServiceError(err)
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+func getConnectionParams(c echo.Context) (uuid.UUID, uuid.UUID, error) {
+	userID, err := getUserID(c)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	connectionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.NewBadRequestError("Invalid connection ID")
+	}
+	return userID, connectionID, nil
+}
+
+func getUserID(c echo.Context) (uuid.UUID, error) {
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return uuid.Nil, errors.NewUnauthorizedError("")
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil, errors.NewUnauthorizedError("")
+	}
+	return id, nil
+}
+
+func optionalDate(c echo.Context, key string) (*time.Time, error) {
+	val := c.QueryParam(key)
+	if val == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.DateOnly, val)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func optionalFloat(c echo.Context, key string) (*float64, error) {
+	val := c.QueryParam(key)
+	if val == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func handleServiceError(err error) error {
+	switch err {
+	case ErrNoBankLink:
+		return errors.NewNotFoundError("No bank connection found")
+	case ErrConnectionNotFound:
+		return errors.NewNotFoundError("Bank connection not found")
+	case ErrInvalidFilter:
+		return errors.NewBadRequestError("Invalid filter, ranges must not be reversed")
+	default:
+		return errors.NewInternalServerError("Unexpected bank service error")
+	}
+}
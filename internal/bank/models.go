@@ -0,0 +1,102 @@
+package bank
+
+import (
+	"figenn/internal/aggregator"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Connection is a link to one bank of a user.
+type Connection struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	PowensID     int64      `json:"-"`
+	ConnectorID  int64      `json:"connector_id"`
+	State        *string    `json:"state"`
+	ErrorMessage *string    `json:"error_message,omitempty"`
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+	// Status tells the user what to do about the state, if anything.
+	Status         aggregator.ConnectionStatus `json:"status"`
+	StateChangedAt *time.Time                  `json:"state_changed_at"`
+	// SyncCursor is the last update time seen on the transactions of the
+	// connection. Later syncs only fetch what changed since.
+	SyncCursor *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ConnectionEvent records a change of state of a connection.
+type ConnectionEvent struct {
+	ID             uuid.UUID                   `json:"id"`
+	ConnectionID   uuid.UUID                   `json:"connection_id"`
+	PreviousState  *string                     `json:"previous_state"`
+	State          *string                     `json:"state"`
+	PreviousStatus aggregator.ConnectionStatus `json:"previous_status"`
+	Status         aggregator.ConnectionStatus `json:"status"`
+	ErrorMessage   *string                     `json:"error_message,omitempty"`
+	CreatedAt      time.Time                   `json:"created_at"`
+}
+
+type Account struct {
+	ID           uuid.UUID `json:"id"`
+	UserID       uuid.UUID `json:"user_id"`
+	ConnectionID uuid.UUID `json:"connection_id"`
+	PowensID     int64     `json:"-"`
+	Name         string    `json:"name"`
+	Number       *string   `json:"number,omitempty"`
+	IBAN         *string   `json:"iban,omitempty"`
+	Type         string    `json:"type"`
+	Balance      float64   `json:"balance"`
+	Currency     string    `json:"currency"`
+	Disabled     bool      `json:"disabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Transaction is a bank transaction. Debits have a negative value, expressed
+// in the currency of their account.
+type Transaction struct {
+	ID              uuid.UUID `json:"id"`
+	UserID          uuid.UUID `json:"user_id"`
+	AccountID       uuid.UUID `json:"account_id"`
+	PowensID        int64     `json:"-"`
+	Date            time.Time `json:"date"`
+	Value           float64   `json:"value"`
+	Currency        string    `json:"currency"`
+	Wording         string    `json:"wording"`
+	OriginalWording string    `json:"original_wording"`
+	Type            string    `json:"type"`
+	Coming          bool      `json:"coming"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// TransactionFilter narrows the transactions listed. Zero values don't
+// filter.
+type TransactionFilter struct {
+	AccountID *uuid.UUID
+	From      *time.Time
+	To        *time.Time
+	Search    string
+	MinAmount *float64
+	MaxAmount *float64
+	Type      string
+	Limit     int
+	Offset    int
+}
+
+type TransactionPage struct {
+	Transactions []*Transaction `json:"transactions"`
+	Total        int            `json:"total"`
+	Limit        int            `json:"limit"`
+	Offset       int            `json:"offset"`
+}
+
+// SyncResult counts what a sync changed.
+type SyncResult struct {
+	Connections         int `json:"connections"`
+	Accounts            int `json:"accounts"`
+	Transactions        int `json:"transactions"`
+	DeletedTransactions int `json:"deleted_transactions"`
+}
@@ -0,0 +1,357 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"figenn/internal/aggregator"
+	"figenn/internal/database"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type Repository struct {
+	db database.DbService
+}
+
+func NewRepository(db database.DbService) *Repository {
+	return &Repository{db: db}
+}
+
+const connectionColumns = `id, user_id, powens_id, connector_id, state, error_message, last_synced_at, sync_cursor,
+	state_changed_at, created_at, updated_at`
+
+func scanConnection(row pgx.Row) (*Connection, error) {
+	c := new(Connection)
+	err := row.Scan(&c.ID, &c.UserID, &c.PowensID, &c.ConnectorID, &c.State, &c.ErrorMessage, &c.LastSyncedAt,
+		&c.SyncCursor, &c.StateChangedAt, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Status = aggregator.StatusOf(c.State)
+	return c, nil
+}
+
+// UpsertConnection stores a connection by its Powens id and returns it with
+// its local id and sync cursor. A change of state of a known connection is
+// recorded as a ConnectionEvent.
+func (r *Repository) UpsertConnection(ctx context.Context, conn *Connection) (*Connection, error) {
+	tx, err := r.db.Pool().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var previous *string
+	err = tx.QueryRow(ctx, `SELECT state FROM bank_connections WHERE powens_id = $1 FOR UPDATE`, conn.PowensID).Scan(&previous)
+	existed := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	changed := existed && !sameState(previous, conn.State)
+
+	now := time.Now()
+	var stateChangedAt *time.Time
+	if changed {
+		stateChangedAt = &now
+	}
+	query := `
+		INSERT INTO bank_connections (user_id, powens_id, connector_id, state, error_message, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (powens_id)
+		DO UPDATE SET
+			connector_id = $3, state = $4, error_message = $5, updated_at = $6,
+			state_changed_at = COALESCE($7, bank_connections.state_changed_at)
+		RETURNING ` + connectionColumns
+	stored, err := scanConnection(tx.QueryRow(ctx, query, conn.UserID, conn.PowensID, conn.ConnectorID, conn.State, conn.ErrorMessage, now, stateChangedAt))
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO bank_connection_events (connection_id, user_id, previous_state, state, error_message, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, stored.ID, stored.UserID, previous, stored.State, stored.ErrorMessage, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stored, tx.Commit(ctx)
+}
+
+func sameState(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (r *Repository) ListConnections(ctx context.Context, userID uuid.UUID) ([]*Connection, error) {
+	query := `SELECT ` + connectionColumns + ` FROM bank_connections WHERE user_id = $1 ORDER BY created_at ASC`
+	rows, err := r.db.Pool().Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	connections := []*Connection{}
+	for rows.Next() {
+		c, err := scanConnection(rows)
+		if err != nil {
+			return nil, err
+		}
+		connections = append(connections, c)
+	}
+	return connections, rows.Err()
+}
+
+func (r *Repository) GetConnection(ctx context.Context, userID, connectionID uuid.UUID) (*Connection, error) {
+	query := `SELECT ` + connectionColumns + ` FROM bank_connections WHERE id = $1 AND user_id = $2`
+	c, err := scanConnection(r.db.Pool().QueryRow(ctx, query, connectionID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrConnectionNotFound
+	}
+	return c, err
+}
+
+// DeleteConnection removes a connection with its accounts, transactions and
+// events.
+func (r *Repository) DeleteConnection(ctx context.Context, userID, connectionID uuid.UUID) error {
+	tag, err := r.db.Pool().Exec(ctx, `DELETE FROM bank_connections WHERE id = $1 AND user_id = $2`, connectionID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConnectionNotFound
+	}
+	return nil
+}
+
+// ListConnectionEvents returns the latest state changes of a connection,
+// most recent first.
+func (r *Repository) ListConnectionEvents(ctx context.Context, userID, connectionID uuid.UUID, limit int) ([]*ConnectionEvent, error) {
+	query := `
+		SELECT id, connection_id, previous_state, state, error_message, created_at
+		FROM bank_connection_events
+		WHERE connection_id = $1 AND user_id = $2
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+	rows, err := r.db.Pool().Query(ctx, query, connectionID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*ConnectionEvent{}
+	for rows.Next() {
+		e := new(ConnectionEvent)
+		if err := rows.Scan(&e.ID, &e.ConnectionID, &e.PreviousState, &e.State, &e.ErrorMessage, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.PreviousStatus = aggregator.StatusOf(e.PreviousState)
+		e.Status = aggregator.StatusOf(e.State)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// SetSyncCursor records a completed sync of a connection.
+func (r *Repository) SetSyncCursor(ctx context.Context, connectionID uuid.UUID, cursor *time.Time, syncedAt time.Time) error {
+	query := `
+		UPDATE bank_connections
+		SET sync_cursor = COALESCE($2, sync_cursor), last_synced_at = $3, updated_at = $3
+		WHERE id = $1
+	`
+	_, err := r.db.Pool().Exec(ctx, query, connectionID, cursor, syncedAt)
+	return err
+}
+
+// UpsertAccount stores an account by its Powens id and returns its local id.
+func (r *Repository) UpsertAccount(ctx context.Context, account *Account) (uuid.UUID, error) {
+	query := `
+		INSERT INTO bank_accounts (user_id, connection_id, powens_id, name, number, iban, type, balance, currency, disabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+		ON CONFLICT (powens_id)
+		DO UPDATE SET
+			connection_id = $2, name = $4, number = $5, iban = $6, type = $7, balance = $8,
+			currency = $9, disabled = $10, updated_at = $11
+		RETURNING id
+	`
+	var id uuid.UUID
+	err := r.db.Pool().QueryRow(ctx, query,
+		account.UserID, account.ConnectionID, account.PowensID, account.Name, account.Number, account.IBAN,
+		account.Type, account.Balance, account.Currency, account.Disabled, time.Now(),
+	).Scan(&id)
+	return id, err
+}
+
+// DeleteAccount removes an account deleted at the bank, with its
+// transactions.
+func (r *Repository) DeleteAccount(ctx context.Context, userID uuid.UUID, powensID int64) error {
+	_, err := r.db.Pool().Exec(ctx, `DELETE FROM bank_accounts WHERE user_id = $1 AND powens_id = $2`, userID, powensID)
+	return err
+}
+
+// UpsertTransactions stores transactions by their Powens id in a single
+// transaction.
+func (r *Repository) UpsertTransactions(ctx context.Context, transactions []*Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO bank_transactions (
+			user_id, account_id, powens_id, date, value, currency, wording, original_wording, type, coming, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+		ON CONFLICT (powens_id)
+		DO UPDATE SET
+			account_id = $2, date = $4, value = $5, currency = $6, wording = $7, original_wording = $8,
+			type = $9, coming = $10, updated_at = $11
+	`
+
+	batch := &pgx.Batch{}
+	now := time.Now()
+	for _, t := range transactions {
+		batch.Queue(query, t.UserID, t.AccountID, t.PowensID, t.Date, t.Value, t.Currency, t.Wording, t.OriginalWording, t.Type, t.Coming, now)
+	}
+
+	tx, err := r.db.Pool().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *Repository) DeleteTransactions(ctx context.Context, userID uuid.UUID, powensIDs []int64) error {
+	if len(powensIDs) == 0 {
+		return nil
+	}
+	_, err := r.db.Pool().Exec(ctx, `DELETE FROM bank_transactions WHERE user_id = $1 AND powens_id = ANY($2)`, userID, powensIDs)
+	return err
+}
+
+func (r *Repository) ListAccounts(ctx context.Context, userID uuid.UUID) ([]*Account, error) {
+	query, args, err := squirrel.Select(
+		"id", "user_id", "connection_id", "powens_id", "name", "number", "iban", "type", "balance", "currency",
+		"disabled", "created_at", "updated_at",
+	).
+		From("bank_accounts").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("name ASC").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, errors.New("failed to build select query")
+	}
+
+	rows, err := r.db.Pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, errors.New("failed to execute query")
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		a := new(Account)
+		err := rows.Scan(&a.ID, &a.UserID, &a.ConnectionID, &a.PowensID, &a.Name, &a.Number, &a.IBAN, &a.Type,
+			&a.Balance, &a.Currency, &a.Disabled, &a.CreatedAt, &a.UpdatedAt)
+		if err != nil {
+			return nil, errors.New("failed to scan account row")
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// ListTransactions returns a page of the transactions of a user matching
+// filter, most recent first, along with the total number of matches.
+func (r *Repository) ListTransactions(ctx context.Context, userID uuid.UUID, filter TransactionFilter) ([]*Transaction, int, error) {
+	where := squirrel.And{squirrel.Eq{"user_id": userID}}
+	if filter.AccountID != nil {
+		where = append(where, squirrel.Eq{"account_id": *filter.AccountID})
+	}
+	if filter.From != nil {
+		where = append(where, squirrel.GtOrEq{"date": *filter.From})
+	}
+	if filter.To != nil {
+		where = append(where, squirrel.LtOrEq{"date": *filter.To})
+	}
+	if filter.Search != "" {
+		where = append(where, squirrel.ILike{"wording": "%" + escapeLike(filter.Search) + "%"})
+	}
+	if filter.MinAmount != nil {
+		where = append(where, squirrel.GtOrEq{"value": *filter.MinAmount})
+	}
+	if filter.MaxAmount != nil {
+		where = append(where, squirrel.LtOrEq{"value": *filter.MaxAmount})
+	}
+	if filter.Type != "" {
+		where = append(where, squirrel.Eq{"type": filter.Type})
+	}
+
+	countQuery, countArgs, err := squirrel.Select("COUNT(*)").
+		From("bank_transactions").
+		Where(where).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, 0, errors.New("failed to build count query")
+	}
+	var total int
+	if err := r.db.Pool().QueryRow(ctx, countQuery, countArgs...).Scan(&total); err != nil {
+		return nil, 0, errors.New("failed to count transactions")
+	}
+
+	query, args, err := squirrel.Select(
+		"id", "user_id", "account_id", "powens_id", "date", "value", "currency", "wording", "original_wording",
+		"type", "coming", "created_at", "updated_at",
+	).
+		From("bank_transactions").
+		Where(where).
+		OrderBy("date DESC", "powens_id DESC").
+		Limit(uint64(filter.Limit)).
+		Offset(uint64(filter.Offset)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, 0, errors.New("failed to build select query")
+	}
+
+	rows, err := r.db.Pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, errors.New("failed to execute query")
+	}
+	defer rows.Close()
+
+	transactions := []*Transaction{}
+	for rows.Next() {
+		t := new(Transaction)
+		err := rows.Scan(&t.ID, &t.UserID, &t.AccountID, &t.PowensID, &t.Date, &t.Value, &t.Currency, &t.Wording,
+			&t.OriginalWording, &t.Type, &t.Coming, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return nil, 0, errors.New("failed to scan transaction row")
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, total, rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	var b []rune
+	for _, r := range s {
+		if r == '%' || r == '_' || r == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, r)
+	}
+	return string(b)
+}
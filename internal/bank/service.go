@@ -0,0 +1,292 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"figenn/internal/aggregator"
+	"figenn/internal/powens"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// initialSyncDays is how far back the first sync of a connection goes.
+const initialSyncDays = 400
+
+// maxConnectionEvents bounds the state changes returned for a connection.
+const maxConnectionEvents = 50
+
+type BankRepository interface {
+	UpsertConnection(ctx context.Context, conn *Connection) (*Connection, error)
+	ListConnections(ctx context.Context, userID uuid.UUID) ([]*Connection, error)
+	GetConnection(ctx context.Context, userID, connectionID uuid.UUID) (*Connection, error)
+	DeleteConnection(ctx context.Context, userID, connectionID uuid.UUID) error
+	ListConnectionEvents(ctx context.Context, userID, connectionID uuid.UUID, limit int) ([]*ConnectionEvent, error)
+	SetSyncCursor(ctx context.Context, connectionID uuid.UUID, cursor *time.Time, syncedAt time.Time) error
+	UpsertAccount(ctx context.Context, account *Account) (uuid.UUID, error)
+	DeleteAccount(ctx context.Context, userID uuid.UUID, powensID int64) error
+	UpsertTransactions(ctx context.Context, transactions []*Transaction) error
+	DeleteTransactions(ctx context.Context, userID uuid.UUID, powensIDs []int64) error
+	ListAccounts(ctx context.Context, userID uuid.UUID) ([]*Account, error)
+	ListTransactions(ctx context.Context, userID uuid.UUID, filter TransactionFilter) ([]*Transaction, int, error)
+}
+
+// PowensAccountRepository finds the aggregator access token of a user.
+type PowensAccountRepository interface {
+	GetPowensAccount(ctx context.Context, userID uuid.UUID) (*powens.PowensAccount, error)
+}
+
+type Service struct {
+	repo       BankRepository
+	powensRepo PowensAccountRepository
+	client     aggregator.BankAggregator
+	// callbackURI is where the reconnection webview sends the user back.
+	callbackURI string
+	now         func() time.Time
+}
+
+func NewService(repo BankRepository, powensRepo PowensAccountRepository, client aggregator.BankAggregator, callbackURI string) *Service {
+	return &Service{repo: repo, powensRepo: powensRepo, client: client, callbackURI: callbackURI, now: time.Now}
+}
+
+// Sync pulls the connections, accounts and transactions of the user from
+// the bank aggregator. Records are upserted by aggregator id. Each
+// connection keeps a cursor so that, after the first sync, only the
+// transactions changed since the previous one are fetched.
+func (s *Service) Sync(ctx context.Context, userID uuid.UUID) (*SyncResult, error) {
+	accessToken, err := s.accessToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	connections, err := s.client.ListConnections(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{}
+	for _, pc := range connections {
+		if err := s.syncConnection(ctx, userID, accessToken, pc, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *Service) ListConnections(ctx context.Context, userID uuid.UUID) ([]*Connection, error) {
+	return s.repo.ListConnections(ctx, userID)
+}
+
+// SyncConnection has the bank refresh a connection, then stores its new
+// state and data. The connection may come back needing a new
+// authentication, see ReconnectURL.
+func (s *Service) SyncConnection(ctx context.Context, userID, connectionID uuid.UUID) (*Connection, error) {
+	conn, accessToken, err := s.connection(ctx, userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := s.client.SyncConnection(ctx, accessToken, conn.PowensID)
+	if errors.Is(err, aggregator.ErrConnectionNotFound) {
+		return nil, ErrConnectionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.syncConnection(ctx, userID, accessToken, pc, &SyncResult{}); err != nil {
+		return nil, err
+	}
+	return s.repo.GetConnection(ctx, userID, connectionID)
+}
+
+// ReconnectURL returns the URL of the webview where the user repairs a
+// broken connection.
+func (s *Service) ReconnectURL(ctx context.Context, userID, connectionID uuid.UUID) (string, error) {
+	conn, accessToken, err := s.connection(ctx, userID, connectionID)
+	if err != nil {
+		return "", err
+	}
+
+	temporaryToken, err := s.client.CreateTemporaryToken(ctx, accessToken)
+	if err != nil {
+		return "", err
+	}
+	return s.client.ReconnectURL(temporaryToken, s.callbackURI, conn.PowensID)
+}
+
+// DeleteConnection removes a connection at the bank aggregator, then its
+// accounts and transactions. A connection already gone at the aggregator is
+// still removed locally.
+func (s *Service) DeleteConnection(ctx context.Context, userID, connectionID uuid.UUID) error {
+	conn, accessToken, err := s.connection(ctx, userID, connectionID)
+	if err != nil {
+		return err
+	}
+
+	err = s.client.DeleteConnection(ctx, accessToken, conn.PowensID)
+	if err != nil && !errors.Is(err, aggregator.ErrConnectionNotFound) {
+		return err
+	}
+	return s.repo.DeleteConnection(ctx, userID, connectionID)
+}
+
+func (s *Service) ListConnectionEvents(ctx context.Context, userID, connectionID uuid.UUID) ([]*ConnectionEvent, error) {
+	if _, err := s.repo.GetConnection(ctx, userID, connectionID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListConnectionEvents(ctx, userID, connectionID, maxConnectionEvents)
+}
+
+// accessToken returns the aggregator access token of the user.
+func (s *Service) accessToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	link, err := s.powensRepo.GetPowensAccount(ctx, userID)
+	if err == powens.ErrNoPowensAccount {
+		return "", ErrNoBankLink
+	}
+	if err != nil {
+		return "", err
+	}
+	return link.AccessToken, nil
+}
+
+func (s *Service) connection(ctx context.Context, userID, connectionID uuid.UUID) (*Connection, string, error) {
+	conn, err := s.repo.GetConnection(ctx, userID, connectionID)
+	if err != nil {
+		return nil, "", err
+	}
+	accessToken, err := s.accessToken(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	return conn, accessToken, nil
+}
+
+func (s *Service) syncConnection(ctx context.Context, userID uuid.UUID, accessToken string, pc *aggregator.Connection, result *SyncResult) error {
+	syncedAt := s.now()
+	conn, err := s.repo.UpsertConnection(ctx, &Connection{
+		UserID:       userID,
+		PowensID:     pc.ID,
+		ConnectorID:  pc.ConnectorID,
+		State:        pc.State,
+		ErrorMessage: pc.ErrorMessage,
+	})
+	if err != nil {
+		return err
+	}
+	result.Connections++
+
+	accounts, err := s.client.ListAccounts(ctx, accessToken, pc.ID)
+	if err != nil {
+		return err
+	}
+
+	query := aggregator.TransactionsQuery{LastUpdate: conn.SyncCursor}
+	if conn.SyncCursor == nil {
+		minDate := syncedAt.AddDate(0, 0, -initialSyncDays)
+		query.MinDate = &minDate
+	}
+
+	var cursor *time.Time
+	for _, pa := range accounts {
+		if pa.Deleted {
+			if err := s.repo.DeleteAccount(ctx, userID, pa.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		account := toAccount(userID, conn.ID, pa)
+		accountID, err := s.repo.UpsertAccount(ctx, account)
+		if err != nil {
+			return err
+		}
+		result.Accounts++
+		if account.Disabled {
+			continue
+		}
+
+		ptxs, err := s.client.ListTransactions(ctx, accessToken, pa.ID, query)
+		if err != nil {
+			return err
+		}
+		upserts, deletions, latest := splitTransactions(userID, accountID, account.Currency, ptxs)
+		if err := s.repo.UpsertTransactions(ctx, upserts); err != nil {
+			return err
+		}
+		if err := s.repo.DeleteTransactions(ctx, userID, deletions); err != nil {
+			return err
+		}
+		result.Transactions += len(upserts)
+		result.DeletedTransactions += len(deletions)
+		if latest != nil && (cursor == nil || latest.After(*cursor)) {
+			cursor = latest
+		}
+	}
+
+	return s.repo.SetSyncCursor(ctx, conn.ID, cursor, syncedAt)
+}
+
+func (s *Service) ListAccounts(ctx context.Context, userID uuid.UUID) ([]*Account, error) {
+	return s.repo.ListAccounts(ctx, userID)
+}
+
+func (s *Service) ListTransactions(ctx context.Context, userID uuid.UUID, filter TransactionFilter) (*TransactionPage, error) {
+	if filter.From != nil && filter.To != nil && filter.To.Before(*filter.From) {
+		return nil, ErrInvalidFilter
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MaxAmount < *filter.MinAmount {
+		return nil, ErrInvalidFilter
+	}
+
+	transactions, total, err := s.repo.ListTransactions(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionPage{Transactions: transactions, Total: total, Limit: filter.Limit, Offset: filter.Offset}, nil
+}
+
+func toAccount(userID, connectionID uuid.UUID, pa *aggregator.Account) *Account {
+	return &Account{
+		UserID:       userID,
+		ConnectionID: connectionID,
+		PowensID:     pa.ID,
+		Name:         pa.Name,
+		Number:       pa.Number,
+		IBAN:         pa.IBAN,
+		Type:         pa.Type,
+		Balance:      pa.Balance,
+		Currency:     pa.Currency,
+		Disabled:     pa.Disabled,
+	}
+}
+
+// splitTransactions converts aggregator transactions into the ones to upsert
+// and the aggregator ids of the ones deleted at the bank. It also returns the
+// latest update time seen, the next sync cursor.
+func splitTransactions(userID, accountID uuid.UUID, currency string, ptxs []*aggregator.Transaction) ([]*Transaction, []int64, *time.Time) {
+	var upserts []*Transaction
+	var deletions []int64
+	var latest *time.Time
+	for _, pt := range ptxs {
+		if pt.LastUpdate != nil && (latest == nil || pt.LastUpdate.After(*latest)) {
+			latest = pt.LastUpdate
+		}
+		if pt.Deleted {
+			deletions = append(deletions, pt.ID)
+			continue
+		}
+		upserts = append(upserts, &Transaction{
+			UserID:          userID,
+			AccountID:       accountID,
+			PowensID:        pt.ID,
+			Date:            pt.Date,
+			Value:           pt.Value,
+			Currency:        currency,
+			Wording:         pt.Wording,
+			OriginalWording: pt.OriginalWording,
+			Type:            pt.Type,
+			Coming:          pt.Coming,
+		})
+	}
+	return upserts, deletions, latest
+}
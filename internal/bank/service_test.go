@@ -0,0 +1,176 @@
+package bank
+
+import (
+	"context"
+	"figenn/internal/aggregator"
+	"figenn/internal/powens"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func at(value string) *time.Time {
+	t, _ := time.Parse(time.DateTime, value)
+	return &t
+}
+
+func TestSplitTransactions(t *testing.T) {
+	userID, accountID := uuid.New(), uuid.New()
+	ptxs := []*aggregator.Transaction{
+		{ID: 1, Date: time.Date(2025, time.May, 2, 0, 0, 0, 0, time.UTC), Value: -13.49, Wording: "NETFLIX", OriginalWording: "CB NETFLIX.COM", LastUpdate: at("2025-05-03 08:00:00")},
+		{ID: 2, Date: time.Date(2025, time.May, 1, 0, 0, 0, 0, time.UTC), Value: 2500, Wording: "SALAIRE", LastUpdate: at("2025-05-04 09:30:00")},
+		{ID: 3, Date: time.Date(2025, time.April, 30, 0, 0, 0, 0, time.UTC), Value: -5, LastUpdate: at("2025-05-01 00:00:00"), Deleted: true},
+	}
+
+	upserts, deletions, latest := splitTransactions(userID, accountID, "EUR", ptxs)
+
+	assert.Len(t, upserts, 2)
+	assert.Equal(t, "NETFLIX", upserts[0].Wording)
+	assert.Equal(t, "CB NETFLIX.COM", upserts[0].OriginalWording)
+	assert.Equal(t, time.Date(2025, time.May, 2, 0, 0, 0, 0, time.UTC), upserts[0].Date)
+	assert.Equal(t, accountID, upserts[1].AccountID)
+	assert.Equal(t, "EUR", upserts[1].Currency)
+	assert.Equal(t, []int64{3}, deletions)
+	assert.Equal(t, time.Date(2025, time.May, 4, 9, 30, 0, 0, time.UTC), *latest)
+}
+
+func TestToAccount(t *testing.T) {
+	pa := &aggregator.Account{ID: 7, Name: "Compte courant", Type: "checking", Balance: 120.5, Currency: "EUR", Disabled: true}
+
+	account := toAccount(uuid.New(), uuid.New(), pa)
+	assert.Equal(t, int64(7), account.PowensID)
+	assert.Equal(t, "EUR", account.Currency)
+	assert.True(t, account.Disabled)
+}
+
+// memoryRepository keeps what a sync stores, by aggregator id. The methods
+// a sync doesn't use are left to the nil BankRepository.
+type memoryRepository struct {
+	BankRepository
+	connections  map[int64]*Connection
+	accounts     map[int64]*Account
+	transactions map[int64]*Transaction
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		connections:  make(map[int64]*Connection),
+		accounts:     make(map[int64]*Account),
+		transactions: make(map[int64]*Transaction),
+	}
+}
+
+func (r *memoryRepository) UpsertConnection(ctx context.Context, conn *Connection) (*Connection, error) {
+	stored, ok := r.connections[conn.PowensID]
+	if !ok {
+		stored = &Connection{ID: uuid.New(), UserID: conn.UserID, PowensID: conn.PowensID}
+		r.connections[conn.PowensID] = stored
+	}
+	stored.ConnectorID, stored.State, stored.ErrorMessage = conn.ConnectorID, conn.State, conn.ErrorMessage
+	copied := *stored
+	return &copied, nil
+}
+
+func (r *memoryRepository) SetSyncCursor(ctx context.Context, connectionID uuid.UUID, cursor *time.Time, syncedAt time.Time) error {
+	for _, conn := range r.connections {
+		if conn.ID == connectionID {
+			if cursor != nil {
+				conn.SyncCursor = cursor
+			}
+			conn.LastSyncedAt = &syncedAt
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) UpsertAccount(ctx context.Context, account *Account) (uuid.UUID, error) {
+	if stored, ok := r.accounts[account.PowensID]; ok {
+		account.ID = stored.ID
+	} else {
+		account.ID = uuid.New()
+	}
+	r.accounts[account.PowensID] = account
+	return account.ID, nil
+}
+
+func (r *memoryRepository) DeleteAccount(ctx context.Context, userID uuid.UUID, powensID int64) error {
+	delete(r.accounts, powensID)
+	return nil
+}
+
+func (r *memoryRepository) UpsertTransactions(ctx context.Context, transactions []*Transaction) error {
+	for _, tx := range transactions {
+		r.transactions[tx.PowensID] = tx
+	}
+	return nil
+}
+
+func (r *memoryRepository) DeleteTransactions(ctx context.Context, userID uuid.UUID, powensIDs []int64) error {
+	for _, id := range powensIDs {
+		delete(r.transactions, id)
+	}
+	return nil
+}
+
+type powensAccounts map[uuid.UUID]*powens.PowensAccount
+
+func (a powensAccounts) GetPowensAccount(ctx context.Context, userID uuid.UUID) (*powens.PowensAccount, error) {
+	account, ok := a[userID]
+	if !ok {
+		return nil, powens.ErrNoPowensAccount
+	}
+	return account, nil
+}
+
+func TestConnectAndSync(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, time.May, 14, 10, 0, 0, 0, time.UTC)
+	fake := aggregator.NewFake(func() time.Time { return now })
+
+	// The fake webview connects the bank and redirects straight back.
+	user, err := fake.InitUser(ctx)
+	assert.NoError(t, err)
+	temporaryToken, err := fake.CreateTemporaryToken(ctx, user.AccessToken)
+	assert.NoError(t, err)
+	redirect, err := fake.ConnectURL(temporaryToken, "https://app.figenn.com/bank/callback")
+	assert.NoError(t, err)
+	callback, err := url.Parse(redirect)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, callback.Query().Get("connection_id"))
+
+	userID := uuid.New()
+	repo := newMemoryRepository()
+	links := powensAccounts{userID: {UserID: userID, AccessToken: user.AccessToken}}
+	service := NewService(repo, links, fake, "")
+	service.now = func() time.Time { return now }
+
+	result, err := service.Sync(ctx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Connections)
+	assert.Equal(t, 2, result.Accounts)
+	assert.NotZero(t, result.Transactions)
+	assert.Len(t, repo.transactions, result.Transactions)
+	assert.Len(t, repo.connections, 1)
+	for _, conn := range repo.connections {
+		// The last charge is NETFLIX.COM on the 12th.
+		assert.Equal(t, time.Date(2025, time.May, 12, 8, 0, 0, 0, time.UTC), *conn.SyncCursor)
+	}
+
+	result, err = service.Sync(ctx, userID)
+	assert.NoError(t, err)
+	assert.Zero(t, result.Transactions, "nothing changed since the cursor")
+
+	// Saturday brings the weekly groceries.
+	now = time.Date(2025, time.May, 17, 10, 0, 0, 0, time.UTC)
+	total := len(repo.transactions)
+	result, err = service.Sync(ctx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Transactions)
+	assert.Len(t, repo.transactions, total+1)
+
+	_, err = service.Sync(ctx, uuid.New())
+	assert.ErrorIs(t, err, ErrNoBankLink)
+}
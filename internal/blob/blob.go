@@ -0,0 +1,69 @@
+// Package blob stores uploaded files, either on the local filesystem or in an
+// S3-compatible bucket.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store keeps blobs under slash separated keys such as "avatars/<id>/<hash>.jpg".
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns ErrNotFound when no blob is stored under key.
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete doesn't fail when the blob is already gone.
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is a stored blob. The caller closes Body.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+}
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(/[A-Za-z0-9][A-Za-z0-9._-]*)*$`)
+
+// ValidKey rejects keys that could escape the store root or need escaping.
+func ValidKey(key string) bool {
+	return len(key) <= 512 && keyPattern.MatchString(key) && path.Clean(key) == key
+}
+
+// StoreFromEnv returns the store selected by BLOB_STORE: "s3" uses the bucket
+// configured by the S3_* variables, anything else keeps the files under
+// BLOB_DIR (./uploads by default).
+func StoreFromEnv() (Store, error) {
+	if os.Getenv("BLOB_STORE") != "s3" {
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewFileStore(dir)
+	}
+
+	pathStyle, _ := strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+	config := S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Region:          os.Getenv("S3_REGION"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		PathStyle:       pathStyle,
+	}
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+	}
+	return NewS3Store(config, nil)
+}
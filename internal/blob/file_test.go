@@ -0,0 +1,42 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidKey(t *testing.T) {
+	assert.True(t, ValidKey("avatars/42/0123abcd.jpg"))
+	assert.False(t, ValidKey(""))
+	assert.False(t, ValidKey("/etc/passwd"))
+	assert.False(t, ValidKey("avatars/../../etc/passwd"))
+	assert.False(t, ValidKey("avatars//a.jpg"))
+	assert.False(t, ValidKey("avatars/.hidden"))
+	assert.False(t, ValidKey("avatars/a b.jpg"))
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Put(ctx, "logos/1/abc.png", []byte("png"), "image/png"))
+
+	obj, err := store.Get(ctx, "logos/1/abc.png")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	assert.Equal(t, "png", string(data))
+	assert.Equal(t, "image/png", obj.ContentType)
+	assert.EqualValues(t, 3, obj.Size)
+
+	assert.NoError(t, store.Delete(ctx, "logos/1/abc.png"))
+	assert.NoError(t, store.Delete(ctx, "logos/1/abc.png"))
+	_, err = store.Get(ctx, "logos/1/abc.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, store.Put(ctx, "../escape", nil, "text/plain"), ErrInvalidKey)
+}
@@ -0,0 +1,95 @@
+package encryption_test
+
+import (
+	"figenn/internal/auth"
+	"figenn/internal/encryption"
+	"figenn/internal/powens"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	createTablePattern = regexp.MustCompile(`(?i)^CREATE TABLE (?:IF NOT EXISTS )?(\w+)`)
+	columnPattern      = regexp.MustCompile(`(?i)^(\w+) (\w+(?:\(\d+\))?)`)
+	alterTablePattern  = regexp.MustCompile(`(?i)^ALTER TABLE (\w+)`)
+	alterColumnPattern = regexp.MustCompile(`(?i)(?:ALTER COLUMN (\w+) TYPE|ADD COLUMN (\w+)) (\w+(?:\(\d+\))?)`)
+	varcharPattern     = regexp.MustCompile(`(?i)^VARCHAR\((\d+)\)$`)
+)
+
+// columnTypes replays the Up sections of the migrations and returns the
+// type of every table.column they declare.
+func columnTypes(t *testing.T) map[string]string {
+	files, err := filepath.Glob("../../migrations/*.sql")
+	assert.NoError(t, err)
+	sort.Strings(files)
+
+	types := make(map[string]string)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		up, _, _ := strings.Cut(string(content), "-- +goose Down")
+
+		var table string
+		for _, line := range strings.Split(up, "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case createTablePattern.MatchString(line):
+				table = createTablePattern.FindStringSubmatch(line)[1]
+			case strings.HasPrefix(line, ")"):
+				table = ""
+			case alterTablePattern.MatchString(line):
+				table = alterTablePattern.FindStringSubmatch(line)[1]
+				if m := alterColumnPattern.FindStringSubmatch(line); m != nil {
+					types[table+"."+m[1]+m[2]] = strings.ToUpper(m[3])
+				}
+			case table != "":
+				if m := alterColumnPattern.FindStringSubmatch(line); m != nil {
+					types[table+"."+m[1]+m[2]] = strings.ToUpper(m[3])
+				} else if m := columnPattern.FindStringSubmatch(line); m != nil {
+					types[table+"."+m[1]] = strings.ToUpper(m[2])
+				}
+			}
+		}
+	}
+	return types
+}
+
+func TestEncryptedColumnsFitTheEnvelope(t *testing.T) {
+	// The longest plaintexts stored: a TOTP secret, and a Powens token as
+	// long as the column allowed before encryption.
+	plaintextLengths := map[string]int{
+		"users.two_fa_secret":          32,
+		"powens_accounts.access_token": 512,
+	}
+	keyring, err := encryption.NewKeyring(encryption.Key{ID: "2025-06-primary", Secret: make([]byte, 32)})
+	assert.NoError(t, err)
+
+	types := columnTypes(t)
+	columns := append(append([]encryption.Column{}, auth.EncryptedColumns...), powens.EncryptedColumns...)
+	for _, col := range columns {
+		length, ok := plaintextLengths[col.Field()]
+		if !assert.True(t, ok, "no plaintext length for %s", col.Field()) {
+			continue
+		}
+		value, err := keyring.Encrypt(col.Field(), strings.Repeat("x", length))
+		assert.NoError(t, err)
+
+		columnType, ok := types[col.Field()]
+		if !assert.True(t, ok, "no migration declares %s", col.Field()) {
+			continue
+		}
+		if m := varcharPattern.FindStringSubmatch(columnType); m != nil {
+			size, _ := strconv.Atoi(m[1])
+			assert.LessOrEqual(t, len(value), size, "%s is %s, too short for a %d characters envelope", col.Field(), columnType, len(value))
+		} else {
+			assert.Equal(t, "TEXT", columnType, col.Field())
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package encryption encrypts database fields holding secrets, such as
+// third-party tokens, so that a database dump alone doesn't disclose them.
+//
+// Values use envelope encryption: each value is sealed with AES-256-GCM under
+// its own random data key, which is in turn sealed under a key encryption key
+// identified by a key id. The key id is stored with the value, so keys can be
+// rotated while older values stay readable.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// prefix marks an encrypted value, followed by the key id, the sealed
+	// data key and the ciphertext, separated by colons.
+	prefix  = "enc:v1:"
+	keySize = 32
+)
+
+var (
+	ErrNoKeys        = errors.New("no encryption key configured")
+	ErrInvalidKey    = errors.New("encryption keys must be 32 bytes, base64 encoded")
+	ErrInvalidKeyID  = errors.New("encryption key ids must be non-empty and contain no colon or comma")
+	ErrUnknownKey    = errors.New("value encrypted with an unknown key")
+	ErrMalformed     = errors.New("malformed encrypted value")
+	ErrDecryptFailed = errors.New("unable to decrypt value")
+)
+
+// Key is a key encryption key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring holds the key encryption keys. The primary key encrypts new
+// values; every key decrypts.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring whose primary key is the first one.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	k := &Keyring{primary: keys[0].ID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for _, key := range keys {
+		if key.ID == "" || strings.ContainsAny(key.ID, ":,") {
+			return nil, ErrInvalidKeyID
+		}
+		aead, err := newAEAD(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.ID] = aead
+	}
+	return k, nil
+}
+
+// KeyringFromEnv reads the keys from ENCRYPTION_KEYS, a comma separated
+// list of id:base64-key pairs, the first one being the primary key. Rotating
+// means prepending a new key and running the rotate-keys command; a key can
+// be dropped once no value uses it anymore.
+func KeyringFromEnv() (*Keyring, error) {
+	return ParseKeys(os.Getenv("ENCRYPTION_KEYS"))
+}
+
+// ParseKeys reads keys in the ENCRYPTION_KEYS format.
+func ParseKeys(value string) (*Keyring, error) {
+	var keys []Key
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, ErrInvalidKeyID
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return NewKeyring(keys...)
+}
+
+// PrimaryKeyID returns the id of the key encrypting new values.
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+// Encrypt seals plaintext for the given field, such as "users.refresh_token".
+// The field is authenticated, so a value copied to another field won't
+// decrypt.
+func (k *Keyring) Encrypt(field, plaintext string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealedKey, err := seal(k.keys[k.primary], dataKey, field)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), field)
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.primary + ":" + encode(sealedKey) + ":" + encode(ciphertext), nil
+}
+
+// EncryptPtr is Encrypt for nullable fields.
+func (k *Keyring) EncryptPtr(field string, plaintext *string) (*string, error) {
+	if plaintext == nil {
+		return nil, nil
+	}
+	value, err := k.Encrypt(field, *plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// Decrypt opens a value sealed by Encrypt for the same field. Values that
+// aren't encrypted, written before encryption was introduced, are returned
+// as is until the rotate-keys command encrypts them.
+func (k *Keyring) Decrypt(field, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	sealedKey, err := decode(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	ciphertext, err := decode(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(kek, sealedKey, field)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", ErrDecryptFailed
+	}
+	plaintext, err := open(dataAEAD, ciphertext, field)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// DecryptPtr is Decrypt for nullable fields.
+func (k *Keyring) DecryptPtr(field string, value *string) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	plaintext, err := k.Decrypt(field, *value)
+	if err != nil {
+		return nil, err
+	}
+	return &plaintext, nil
+}
+
+// NeedsRotation reports whether value isn't encrypted with the primary key.
+func (k *Keyring) NeedsRotation(value string) bool {
+	return !strings.HasPrefix(value, prefix+k.primary+":")
+}
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data with a random nonce, returned in front of the
+// ciphertext.
+func seal(aead cipher.AEAD, data []byte, field string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, []byte(field)), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, field string) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return data, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// GenerateKey returns a new random key in the ENCRYPTION_KEYS format.
+func GenerateKey(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, ":,") {
+		return "", ErrInvalidKeyID
+	}
+	secret := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s", id, base64.StdEncoding.EncodeToString(secret)), nil
+}
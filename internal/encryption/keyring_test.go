@@ -0,0 +1,70 @@
+package encryption
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(id string, b byte) Key {
+	return Key{ID: id, Secret: []byte(strings.Repeat(string(b), keySize))}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring, err := NewKeyring(testKey("k1", 'a'))
+	assert.NoError(t, err)
+
+	value, err := keyring.Encrypt("users.refresh_token", "secret-token")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(value, "enc:v1:k1:"))
+	assert.NotContains(t, value, "secret-token")
+
+	other, _ := keyring.Encrypt("users.refresh_token", "secret-token")
+	assert.NotEqual(t, value, other)
+
+	plaintext, err := keyring.Decrypt("users.refresh_token", value)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-token", plaintext)
+
+	_, err = keyring.Decrypt("users.two_fa_secret", value)
+	assert.ErrorIs(t, err, ErrDecryptFailed)
+
+	legacy, err := keyring.Decrypt("users.refresh_token", "plain-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain-token", legacy)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, _ := NewKeyring(testKey("k1", 'a'))
+	value, _ := old.Encrypt("powens_accounts.access_token", "token")
+
+	rotated, err := NewKeyring(testKey("k2", 'b'), testKey("k1", 'a'))
+	assert.NoError(t, err)
+	assert.True(t, rotated.NeedsRotation(value))
+	assert.True(t, rotated.NeedsRotation("token"))
+
+	plaintext, err := rotated.Decrypt("powens_accounts.access_token", value)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", plaintext)
+
+	value, _ = rotated.Encrypt("powens_accounts.access_token", plaintext)
+	assert.False(t, rotated.NeedsRotation(value))
+
+	_, err = old.Decrypt("powens_accounts.access_token", value)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestParseKeys(t *testing.T) {
+	key, err := GenerateKey("2025-05")
+	assert.NoError(t, err)
+
+	keyring, err := ParseKeys(key + ", " + strings.Replace(key, "2025-05", "2025-01", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-05", keyring.PrimaryKeyID())
+
+	_, err = ParseKeys("")
+	assert.ErrorIs(t, err, ErrNoKeys)
+	_, err = ParseKeys("k1:c2hvcnQ=")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
@@ -0,0 +1,89 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// rotateBatchSize is the number of rows re-encrypted per query.
+const rotateBatchSize = 500
+
+// Column is an encrypted column, in a table whose primary key is id.
+type Column struct {
+	Table  string
+	Column string
+}
+
+// Field returns the name the values of the column are encrypted for.
+func (c Column) Field() string {
+	return c.Table + "." + c.Column
+}
+
+// RotateColumn re-encrypts with the primary key every value of col that is
+// in plaintext or encrypted with another key. A row changed concurrently is
+// left to the application, which writes it with the primary key anyway. It
+// returns the number of rows updated.
+func (k *Keyring) RotateColumn(ctx context.Context, pool *pgxpool.Pool, col Column) (int, error) {
+	selectQuery := fmt.Sprintf(
+		`SELECT id, %[2]s FROM %[1]s WHERE %[2]s IS NOT NULL AND left(%[2]s, $3) <> $1 ORDER BY id LIMIT $2`,
+		pgx.Identifier{col.Table}.Sanitize(), pgx.Identifier{col.Column}.Sanitize(),
+	)
+	updateQuery := fmt.Sprintf(
+		`UPDATE %[1]s SET %[2]s = $1 WHERE id = $2 AND %[2]s = $3`,
+		pgx.Identifier{col.Table}.Sanitize(), pgx.Identifier{col.Column}.Sanitize(),
+	)
+	// Compared as a plain prefix: key ids may contain LIKE wildcards.
+	current := prefix + k.primary + ":"
+
+	var rotated int
+	for {
+		rows, err := pool.Query(ctx, selectQuery, current, rotateBatchSize, utf8.RuneCountInString(current))
+		if err != nil {
+			return rotated, err
+		}
+		type row struct {
+			id    any
+			value string
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.value); err != nil {
+				rows.Close()
+				return rotated, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return rotated, err
+		}
+
+		updated := 0
+		for _, r := range batch {
+			plaintext, err := k.Decrypt(col.Field(), r.value)
+			if err != nil {
+				return rotated, fmt.Errorf("%s row %v: %w", col.Field(), r.id, err)
+			}
+			value, err := k.Encrypt(col.Field(), plaintext)
+			if err != nil {
+				return rotated, err
+			}
+			tag, err := pool.Exec(ctx, updateQuery, value, r.id, r.value)
+			if err != nil {
+				return rotated, err
+			}
+			updated += int(tag.RowsAffected())
+		}
+		rotated += updated
+		// Rows changed concurrently now use the primary key, so a batch
+		// without any update means the rest is being rotated elsewhere.
+		if len(batch) < rotateBatchSize || updated == 0 {
+			return rotated, nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package exchange
+
+import (
+	"context"
+	"strings"
+)
+
+// BaseCurrency is the currency every stored rate is expressed against.
+const BaseCurrency = "EUR"
+
+// RateProvider returns exchange rates between two ISO 4217 currencies.
+type RateProvider interface {
+	// Rate returns how many units of to are worth one unit of from.
+	Rate(ctx context.Context, from, to string) (float64, error)
+}
+
+// DefaultRates are reference rates (units per 1 EUR) used when no fresher
+// source is available, e.g. in local development or when offline.
+var DefaultRates = map[string]float64{
+	"EUR": 1,
+	"USD": 1.08,
+	"GBP": 0.85,
+	"CHF": 0.95,
+}
+
+// StaticProvider serves rates from an in-memory table expressed against
+// BaseCurrency.
+type StaticProvider struct {
+	rates map[string]float64
+}
+
+func NewStaticProvider(rates map[string]float64) *StaticProvider {
+	normalized := make(map[string]float64, len(rates))
+	for currency, rate := range rates {
+		normalized[strings.ToUpper(currency)] = rate
+	}
+	normalized[BaseCurrency] = 1
+	return &StaticProvider{rates: normalized}
+}
+
+func (p *StaticProvider) Rate(_ context.Context, from, to string) (float64, error) {
+	return crossRate(strings.ToUpper(from), strings.ToUpper(to), func(currency string) (float64, error) {
+		rate, ok := p.rates[currency]
+		if !ok {
+			return 0, ErrRateNotFound
+		}
+		return rate, nil
+	})
+}
+
+// ChainProvider asks each provider in turn and returns the first rate found.
+type ChainProvider struct {
+	providers []RateProvider
+}
+
+func NewChainProvider(providers ...RateProvider) *ChainProvider {
+	return &ChainProvider{providers: providers}
+}
+
+func (p *ChainProvider) Rate(ctx context.Context, from, to string) (float64, error) {
+	err := ErrRateNotFound
+	for _, provider := range p.providers {
+		var rate float64
+		rate, err = provider.Rate(ctx, from, to)
+		if err == nil {
+			return rate, nil
+		}
+	}
+	return 0, err
+}
+
+// Convert converts amount from one currency to another using provider.
+func Convert(ctx context.Context, provider RateProvider, amount float64, from, to string) (float64, error) {
+	rate, err := provider.Rate(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * rate, nil
+}
+
+func crossRate(from, to string, rateOf func(currency string) (float64, error)) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	fromRate, err := rateOf(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := rateOf(to)
+	if err != nil {
+		return 0, err
+	}
+	if fromRate <= 0 {
+		return 0, ErrRateNotFound
+	}
+	return toRate / fromRate, nil
+}
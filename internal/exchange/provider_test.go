@@ -0,0 +1,32 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticProviderCrossRate(t *testing.T) {
+	p := NewStaticProvider(map[string]float64{"USD": 1.25, "GBP": 0.8})
+
+	rate, err := p.Rate(context.Background(), "usd", "GBP")
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.64, rate, 1e-9)
+
+	rate, err = p.Rate(context.Background(), "EUR", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, rate)
+
+	_, err = p.Rate(context.Background(), "EUR", "JPY")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
+
+func TestChainProviderFallsBack(t *testing.T) {
+	empty := NewStaticProvider(nil)
+	fallback := NewStaticProvider(map[string]float64{"CHF": 0.9})
+
+	amount, err := Convert(context.Background(), NewChainProvider(empty, fallback), 90, "CHF", "EUR")
+	assert.NoError(t, err)
+	assert.InDelta(t, 100, amount, 1e-9)
+}
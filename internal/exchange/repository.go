@@ -0,0 +1,51 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"figenn/internal/database"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+// Repository is a RateProvider backed by the exchange_rates table, so rates
+// can be refreshed without a redeploy.
+type Repository struct {
+	db database.DbService
+}
+
+func NewRepository(db database.DbService) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Rate(ctx context.Context, from, to string) (float64, error) {
+	return crossRate(strings.ToUpper(from), strings.ToUpper(to), func(currency string) (float64, error) {
+		if currency == BaseCurrency {
+			return 1, nil
+		}
+		return r.rateToBase(ctx, currency)
+	})
+}
+
+func (r *Repository) rateToBase(ctx context.Context, currency string) (float64, error) {
+	query, args, err := squirrel.Select("rate").
+		From("exchange_rates").
+		Where(squirrel.Eq{"currency": currency}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, errors.New("failed to build select query")
+	}
+
+	var rate float64
+	err = r.db.Pool().QueryRow(ctx, query, args...).Scan(&rate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrRateNotFound
+	}
+	if err != nil {
+		return 0, errors.New("failed to execute query")
+	}
+	return rate, nil
+}
@@ -0,0 +1,339 @@
+// Package jwtkeys signs and verifies the JWTs issued by Figenn.
+//
+// Tokens are signed with RS256 or EdDSA by the primary key of the key set and
+// carry its id in the kid header. Every key of the set verifies tokens, so a
+// new key can be prepended while tokens signed by the previous one are still
+// valid. The public keys are published as a JWKS so other services can
+// verify tokens without sharing any secret.
+//
+// Every token names the single use it was issued for in its aud claim: only
+// tokens for AudienceAccess authenticate requests. Services verifying tokens
+// against the JWKS must require that audience, or they would also accept the
+// refresh, MFA challenge and email link tokens signed by the same keys.
+//
+// Deployments still configured with a single HMAC JWT_SECRET keep working:
+// the secret signs tokens when no asymmetric key is configured, and verifies
+// the tokens it issued, which have no kid, until they expire.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithm is a JWS signing algorithm.
+type Algorithm string
+
+const (
+	RS256 Algorithm = "RS256"
+	EdDSA Algorithm = "EdDSA"
+	HS256 Algorithm = "HS256"
+)
+
+// rsaKeyBits is the size of the RSA keys generated by GenerateKey.
+const rsaKeyBits = 2048
+
+var (
+	ErrNoKeys        = errors.New("no JWT signing key configured")
+	ErrInvalidKey    = errors.New("JWT keys must be PKCS#8 private or PKIX public RSA or Ed25519 keys, base64 encoded")
+	ErrInvalidKeyID  = errors.New("JWT key ids must be non-empty, unique and contain no colon or comma")
+	ErrPrimaryPublic = errors.New("the primary JWT key must be a private key")
+	ErrInvalidToken  = errors.New("invalid or expired token")
+)
+
+// Key is a signing key. Keys holding only a public key verify tokens but
+// can't sign them, which is enough for keys being retired.
+type Key struct {
+	ID        string
+	Algorithm Algorithm
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+// ParseKey reads a DER encoded PKCS#8 private key or PKIX public key. The
+// algorithm follows the key type: RS256 for RSA, EdDSA for Ed25519.
+func ParseKey(id string, der []byte) (Key, error) {
+	if id == "" || strings.ContainsAny(id, ":,") {
+		return Key{}, ErrInvalidKeyID
+	}
+	if private, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return Key{}, ErrInvalidKey
+		}
+		key, err := newKey(id, signer.Public())
+		key.private = signer
+		return key, err
+	}
+	public, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return Key{}, ErrInvalidKey
+	}
+	return newKey(id, public)
+}
+
+func newKey(id string, public crypto.PublicKey) (Key, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return Key{ID: id, Algorithm: RS256, public: public}, nil
+	case ed25519.PublicKey:
+		return Key{ID: id, Algorithm: EdDSA, public: public}, nil
+	default:
+		return Key{}, ErrInvalidKey
+	}
+}
+
+func (k Key) method() jwt.SigningMethod {
+	if k.Algorithm == RS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// KeySet signs tokens with its primary key and verifies them with any of
+// its keys.
+type KeySet struct {
+	primary *Key
+	keys    map[string]*Key
+	// secret is the legacy HMAC secret, see the package documentation.
+	secret []byte
+}
+
+// NewKeySet returns a key set whose primary key is the first one. The HMAC
+// secret is optional when keys are given.
+func NewKeySet(secret string, keys ...Key) (*KeySet, error) {
+	if len(keys) == 0 && secret == "" {
+		return nil, ErrNoKeys
+	}
+
+	s := &KeySet{keys: make(map[string]*Key, len(keys)), secret: []byte(secret)}
+	for i := range keys {
+		key := keys[i]
+		if _, exists := s.keys[key.ID]; exists || key.ID == "" {
+			return nil, ErrInvalidKeyID
+		}
+		s.keys[key.ID] = &key
+	}
+	if len(keys) > 0 {
+		s.primary = s.keys[keys[0].ID]
+		if s.primary.private == nil {
+			return nil, ErrPrimaryPublic
+		}
+	}
+	return s, nil
+}
+
+// NewHMACKeySet returns a key set signing tokens with an HMAC secret only.
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{keys: map[string]*Key{}, secret: []byte(secret)}
+}
+
+// KeySetFromEnv reads the keys from JWT_SIGNING_KEYS, a comma separated list
+// of id:base64-DER pairs, the first one being the primary key, and the legacy
+// secret from JWT_SECRET. Rotating means prepending a new key, then removing
+// the old one once the tokens it signed expired.
+func KeySetFromEnv() (*KeySet, error) {
+	return ParseKeys(os.Getenv("JWT_SIGNING_KEYS"), os.Getenv("JWT_SECRET"))
+}
+
+// ParseKeys reads keys in the JWT_SIGNING_KEYS format.
+func ParseKeys(value, secret string) (*KeySet, error) {
+	var keys []Key
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, ErrInvalidKeyID
+		}
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		key, err := ParseKey(id, der)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(secret, keys...)
+}
+
+// Audiences of the tokens issued by Figenn.
+const (
+	AudienceAccess      = "figenn:access"
+	AudienceRefresh     = "figenn:refresh"
+	AudienceMFAPending  = "figenn:mfa_pending"
+	AudienceVerifyEmail = "figenn:verify_email"
+	AudienceUnlock      = "figenn:unlock"
+	AudienceChangeEmail = "figenn:change_email"
+)
+
+// Sign returns a token for audience holding claims, signed by the primary
+// key.
+func (s *KeySet) Sign(audience string, claims jwt.MapClaims) (string, error) {
+	claims["aud"] = audience
+	if s.primary == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	token := jwt.NewWithClaims(s.primary.method(), claims)
+	token.Header["kid"] = s.primary.ID
+	return token.SignedString(s.primary.private)
+}
+
+// Parse verifies a token issued for audience and returns its claims. Tokens
+// must expire.
+func (s *KeySet) Parse(tokenString, audience string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, s.keyFunc, jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{string(RS256), string(EdDSA), string(HS256)}))
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if !hasAudience(claims, audience) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// hasAudience checks the aud claim. Tokens issued before audiences were set
+// have none: access tokens were untyped, the others had a type claim naming
+// their use. They are accepted until they expire.
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	if _, ok := claims["aud"]; !ok {
+		tokenType, typed := claims["type"]
+		if audience == AudienceAccess {
+			return !typed
+		}
+		return typed && "figenn:"+fmt.Sprint(tokenType) == audience
+	}
+	auds, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+	for _, aud := range auds {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFunc picks the key named by the kid header, checking the token uses
+// its algorithm. Tokens without kid were signed with the legacy secret.
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, hasKid := token.Header["kid"].(string)
+	if !hasKid {
+		if len(s.secret) == 0 || token.Method.Alg() != string(HS256) {
+			return nil, ErrInvalidToken
+		}
+		return s.secret, nil
+	}
+	key, ok := s.keys[kid]
+	if !ok || token.Method.Alg() != string(key.Algorithm) {
+		return nil, ErrInvalidToken
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are the modulus and exponent of RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X describe Ed25519 keys (RFC 8037).
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, the primary key first. The legacy
+// HMAC secret is never published.
+func (s *KeySet) JWKS() JWKS {
+	var rotated []JWK
+	for _, key := range s.keys {
+		if key != s.primary {
+			rotated = append(rotated, key.jwk())
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		return rotated[i].KeyID < rotated[j].KeyID
+	})
+
+	jwks := JWKS{Keys: []JWK{}}
+	if s.primary != nil {
+		jwks.Keys = append(jwks.Keys, s.primary.jwk())
+	}
+	jwks.Keys = append(jwks.Keys, rotated...)
+	return jwks
+}
+
+func (k *Key) jwk() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: string(k.Algorithm)}
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encode(public.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = encode(public)
+	}
+	return jwk
+}
+
+// GenerateKey returns a new private key in the JWT_SIGNING_KEYS format.
+func GenerateKey(id string, alg Algorithm) (string, error) {
+	if id == "" || strings.ContainsAny(id, ":,") {
+		return "", ErrInvalidKeyID
+	}
+
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(der), nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,139 @@
+package jwtkeys
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeys(t *testing.T, specs ...string) []Key {
+	var keys []Key
+	for _, spec := range specs {
+		id, alg, _ := strings.Cut(spec, "/")
+		encoded, err := GenerateKey(id, Algorithm(alg))
+		assert.NoError(t, err)
+		_, b64, _ := strings.Cut(encoded, ":")
+		der, _ := base64.StdEncoding.DecodeString(b64)
+		key, err := ParseKey(id, der)
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"user_id": "42", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestSignAndParse(t *testing.T) {
+	for _, alg := range []string{"EdDSA", "RS256"} {
+		keys, err := NewKeySet("", testKeys(t, "k1/"+alg)...)
+		assert.NoError(t, err)
+
+		token, err := keys.Sign(AudienceAccess, claims())
+		assert.NoError(t, err)
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		assert.NoError(t, err)
+		assert.Equal(t, "k1", parsed.Header["kid"])
+		assert.Equal(t, alg, parsed.Header["alg"])
+
+		got, err := keys.Parse(token, AudienceAccess)
+		assert.NoError(t, err)
+		assert.Equal(t, "42", got["user_id"])
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	keys, _ := NewKeySet("", testKeys(t, "k1/EdDSA")...)
+	other, _ := NewKeySet("", testKeys(t, "k1/EdDSA")...)
+
+	forged, _ := other.Sign(AudienceAccess, claims())
+	_, err := keys.Parse(forged, AudienceAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	expired, _ := keys.Sign(AudienceAccess, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})
+	_, err = keys.Parse(expired, AudienceAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	noExp, _ := keys.Sign(AudienceAccess, jwt.MapClaims{"user_id": "42"})
+	_, err = keys.Parse(noExp, AudienceAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Without a legacy secret, tokens without kid are refused.
+	hmac, _ := NewHMACKeySet("secret").Sign(AudienceAccess, claims())
+	_, err = keys.Parse(hmac, AudienceAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestParseChecksAudience(t *testing.T) {
+	keys, _ := NewKeySet("", testKeys(t, "k1/EdDSA")...)
+
+	refresh, _ := keys.Sign(AudienceRefresh, claims())
+	_, err := keys.Parse(refresh, AudienceAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = keys.Parse(refresh, AudienceRefresh)
+	assert.NoError(t, err)
+
+	// Tokens issued before audiences were set.
+	legacy := NewHMACKeySet("secret")
+	sign := func(claims jwt.MapClaims) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		return token
+	}
+	access := sign(claims())
+	_, err = legacy.Parse(access, AudienceAccess)
+	assert.NoError(t, err)
+	_, err = legacy.Parse(access, AudienceRefresh)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	typed := claims()
+	typed["type"] = "refresh"
+	_, err = legacy.Parse(sign(typed), AudienceAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = legacy.Parse(sign(typed), AudienceRefresh)
+	assert.NoError(t, err)
+}
+
+func TestRotation(t *testing.T) {
+	generated := testKeys(t, "new/EdDSA", "old/RS256")
+	oldKeys, _ := NewKeySet("", generated[1])
+	legacy := NewHMACKeySet("secret")
+	keys, err := NewKeySet("secret", generated...)
+	assert.NoError(t, err)
+
+	for _, signer := range []*KeySet{keys, oldKeys, legacy} {
+		token, err := signer.Sign(AudienceAccess, claims())
+		assert.NoError(t, err)
+		_, err = keys.Parse(token, AudienceAccess)
+		assert.NoError(t, err)
+	}
+
+	jwks := keys.JWKS()
+	assert.Len(t, jwks.Keys, 2)
+	assert.Equal(t, JWK{KeyType: "OKP", KeyID: "new", Use: "sig", Algorithm: "EdDSA", Curve: "Ed25519", X: jwks.Keys[0].X}, jwks.Keys[0])
+	assert.Equal(t, "RSA", jwks.Keys[1].KeyType)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+	assert.Empty(t, legacy.JWKS().Keys)
+}
+
+func TestParseKeys(t *testing.T) {
+	encoded, _ := GenerateKey("k1", EdDSA)
+
+	keys, err := ParseKeys(" "+encoded+" ,", "")
+	assert.NoError(t, err)
+	assert.Len(t, keys.JWKS().Keys, 1)
+
+	_, err = ParseKeys("", "")
+	assert.ErrorIs(t, err, ErrNoKeys)
+	_, err = ParseKeys(encoded+","+encoded, "")
+	assert.ErrorIs(t, err, ErrInvalidKeyID)
+	_, err = ParseKeys("k1:bm90IGEga2V5", "")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = GenerateKey("k2", HS256)
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrFileTooLarge    = errors.New("file is too large")
+	ErrUnsupportedType = errors.New("only JPEG, PNG, GIF and WebP images are accepted")
+	ErrInvalidImage    = errors.New("image could not be decoded")
+)
+
+const (
+	// MaxUploadSize bounds an uploaded image, before resizing.
+	MaxUploadSize = 5 << 20
+	// maxPixels rejects images that would take too much memory once decoded:
+	// 4096x4096 is 64 MB as RGBA.
+	maxPixels = 4096 * 4096
+)
+
+// Fit is how an image is brought to the standard dimensions.
+type Fit int
+
+const (
+	// Cover crops the image to fill the whole square, for avatars.
+	Cover Fit = iota
+	// Contain fits the whole image in the square on a transparent
+	// background, for logos.
+	Contain
+)
+
+// Kind describes an uploadable image.
+type Kind struct {
+	// Prefix starts the keys of the stored images.
+	Prefix string
+	Size   int
+	Fit    Fit
+}
+
+var (
+	Avatar = Kind{Prefix: "avatars", Size: 256, Fit: Cover}
+	Logo   = Kind{Prefix: "logos", Size: 128, Fit: Contain}
+)
+
+var acceptedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Process validates an uploaded image and resizes it to the standard
+// dimensions of kind. Covered images are encoded as JPEG, contained ones as
+// PNG to keep their transparency. It returns the encoded image along with its
+// extension.
+func Process(data []byte, kind Kind) ([]byte, string, error) {
+	if len(data) > MaxUploadSize {
+		return nil, "", ErrFileTooLarge
+	}
+	if !acceptedTypes[http.DetectContentType(data)] {
+		return nil, "", ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return nil, "", ErrInvalidImage
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, "", ErrFileTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrInvalidImage
+	}
+
+	var buf bytes.Buffer
+	switch kind.Fit {
+	case Cover:
+		dst := image.NewRGBA(image.Rect(0, 0, kind.Size, kind.Size))
+		// JPEG has no alpha channel, transparent pixels turn white.
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, coverCrop(src.Bounds()), draw.Over, nil)
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), ".jpg", nil
+	default:
+		dst := image.NewNRGBA(image.Rect(0, 0, kind.Size, kind.Size))
+		draw.CatmullRom.Scale(dst, containRect(src.Bounds(), kind.Size), src, src.Bounds(), draw.Src, nil)
+		if err := png.Encode(&buf, dst); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), ".png", nil
+	}
+}
+
+// coverCrop returns the centered square of b.
+func coverCrop(b image.Rectangle) image.Rectangle {
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// containRect returns where b lands, centered, once scaled to fit a square of
+// the given size.
+func containRect(b image.Rectangle, size int) image.Rectangle {
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*size/b.Dx())
+	} else {
+		w = max(1, b.Dx()*size/b.Dy())
+	}
+	x := (size - w) / 2
+	y := (size - h) / 2
+	return image.Rect(x, y, x+w, y+h)
+}
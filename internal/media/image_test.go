@@ -0,0 +1,54 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	data, ext, err := Process(encodePNG(t, 400, 200), Avatar)
+	assert.NoError(t, err)
+	assert.Equal(t, ".jpg", ext)
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 256, config.Width)
+	assert.Equal(t, 256, config.Height)
+
+	data, ext, err = Process(encodePNG(t, 300, 100), Logo)
+	assert.NoError(t, err)
+	assert.Equal(t, ".png", ext)
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 128, 128), img.Bounds())
+	_, _, _, alpha := img.At(64, 2).RGBA()
+	assert.Zero(t, alpha, "logos are padded with transparency")
+
+	_, _, err = Process([]byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), Avatar)
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+
+	_, _, err = Process(encodePNG(t, 10, 10)[:60], Avatar)
+	assert.ErrorIs(t, err, ErrInvalidImage)
+}
+
+func TestContainRect(t *testing.T) {
+	assert.Equal(t, image.Rect(0, 43, 128, 85), containRect(image.Rect(0, 0, 300, 100), 128))
+	assert.Equal(t, image.Rect(32, 0, 96, 128), containRect(image.Rect(0, 0, 50, 100), 128))
+}
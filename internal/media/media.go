@@ -0,0 +1,105 @@
+// Package media handles the images uploaded by users: avatars and
+// subscription logos. They are resized, stored in a blob store under a key
+// derived from their content, and served from stable URLs.
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"figenn/internal/blob"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// BodyLimit guards the upload routes, leaving room for the multipart framing.
+var BodyLimit = middleware.BodyLimit("6M")
+
+type Uploader struct {
+	store blob.Store
+	// baseURL prefixes the keys to build the public URLs, e.g.
+	// https://api.figenn.com/media.
+	baseURL string
+}
+
+func NewUploader(store blob.Store, baseURL string) *Uploader {
+	return &Uploader{store: store, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Upload processes the uploaded file and stores it for owner, returning its
+// URL. Keys embed a hash of the processed image: a URL always serves the same
+// bytes, and uploading the same image twice gives the same URL.
+func (u *Uploader) Upload(ctx context.Context, kind Kind, owner string, file *multipart.FileHeader) (string, error) {
+	if file.Size > MaxUploadSize {
+		return "", ErrFileTooLarge
+	}
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, MaxUploadSize+1))
+	if err != nil {
+		return "", err
+	}
+	processed, ext, err := Process(data, kind)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(processed)
+	key := kind.Prefix + "/" + owner + "/" + hex.EncodeToString(sum[:12]) + ext
+	contentType := "image/png"
+	if ext == ".jpg" {
+		contentType = "image/jpeg"
+	}
+	if err := u.store.Put(ctx, key, processed, contentType); err != nil {
+		return "", err
+	}
+	return u.baseURL + "/" + key, nil
+}
+
+// Remove deletes the image of owner behind url, once it was replaced. URLs
+// are user input: anything but an image of this kind and owner, like another
+// user's image or the generated default avatars, is ignored.
+func (u *Uploader) Remove(ctx context.Context, kind Kind, owner, url string) error {
+	key, ok := strings.CutPrefix(url, u.baseURL+"/")
+	if !ok || !blob.ValidKey(key) || !strings.HasPrefix(key, kind.Prefix+"/"+owner+"/") {
+		return nil
+	}
+	return u.store.Delete(ctx, key)
+}
+
+// Handler serves the stored images under the route wildcard. They never
+// change behind a URL, so they are cached for a year.
+func (u *Uploader) Handler(c echo.Context) error {
+	key := c.Param("*")
+	if !blob.ValidKey(key) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	obj, err := u.store.Get(c.Request().Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer obj.Body.Close()
+
+	h := c.Response().Header()
+	h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if obj.Size >= 0 {
+		h.Set(echo.HeaderContentLength, strconv.FormatInt(obj.Size, 10))
+	}
+	return c.Stream(http.StatusOK, obj.ContentType, obj.Body)
+}
@@ -0,0 +1,64 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"figenn/internal/blob"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func formFile(t *testing.T, data []byte) *multipart.FileHeader {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "avatar.png")
+	assert.NoError(t, err)
+	part.Write(data)
+	assert.NoError(t, w.Close())
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	assert.NoError(t, err)
+	return form.File["file"][0]
+}
+
+func TestUploader(t *testing.T) {
+	ctx := context.Background()
+	store, err := blob.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	u := NewUploader(store, "https://api.example.com/media/")
+
+	url, err := u.Upload(ctx, Avatar, "user-1", formFile(t, encodePNG(t, 64, 64)))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(url, "https://api.example.com/media/avatars/user-1/"))
+	assert.True(t, strings.HasSuffix(url, ".jpg"))
+
+	again, err := u.Upload(ctx, Avatar, "user-1", formFile(t, encodePNG(t, 64, 64)))
+	assert.NoError(t, err)
+	assert.Equal(t, url, again, "the same image keeps the same URL")
+
+	e := echo.New()
+	e.GET("/media/*", u.Handler)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(url, "https://api.example.com"), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "immutable")
+
+	assert.NoError(t, u.Remove(ctx, Avatar, "user-1", "https://api.dicebear.com/7.x/initials/svg?seed=AB"))
+	assert.NoError(t, u.Remove(ctx, Avatar, "user-2", url))
+	assert.NoError(t, u.Remove(ctx, Logo, "user-1", url))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(url, "https://api.example.com"), nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "images of other owners or kinds are kept")
+
+	assert.NoError(t, u.Remove(ctx, Avatar, "user-1", url))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(url, "https://api.example.com"), nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
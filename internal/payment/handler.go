@@ -1,6 +1,7 @@
 package payment
 
 import (
+	"figenn/internal/jwtkeys"
 	"figenn/internal/users"
 	"net/http"
 
@@ -8,20 +9,23 @@ import (
 )
 
 type API struct {
-	JWTSecret string
-	s         *Service
+	JWTKeys *jwtkeys.KeySet
+	s       *Service
+	users   *users.Service
 }
 
-func NewAPI(secret string, service *Service) *API {
+func NewAPI(keys *jwtkeys.KeySet, service *Service, userService *users.Service) *API {
 	return &API{
-		JWTSecret: secret,
-		s:         service,
+		JWTKeys: keys,
+		s:       service,
+		users:   userService,
 	}
 }
 
 func (a *API) Bind(rg *echo.Group) {
 	stripeGroup := rg.Group("/payment")
-	stripeGroup.POST("/create-checkout-session", a.HandleCreateCheckoutSession, users.CookieAuthMiddleware(a.JWTSecret))
+	stripeGroup.POST("/create-checkout-session", a.HandleCreateCheckoutSession,
+		users.CookieAuthMiddleware(a.JWTKeys), users.VerifiedEmailMiddleware(a.users))
 	stripeGroup.GET("/subscriptions/:id", a.HandleGetSubscription)
 	stripeGroup.DELETE("/subscriptions/:id", a.HandleCancelSubscription)
 	stripeGroup.POST("/webhook", a.HandleWebhook)
@@ -56,6 +56,15 @@ func (s *Service) CreateCustomer(email, firstName, lastName string) (*string, er
 	return &result.ID, nil
 }
 
+// UpdateCustomerEmail changes the email Stripe bills the customer at.
+func (s *Service) UpdateCustomerEmail(customerID, email string) error {
+	stripe.Key = s.client.AppsSecrets.Key
+	_, err := customer.Update(customerID, &stripe.CustomerParams{
+		Email: stripe.String(email),
+	})
+	return err
+}
+
 func (s *Service) CreateCheckoutSession(req *CheckoutSessionParams) (*stripe.CheckoutSession, error) {
 	priceID, ok := s.planMap[req.Plan]
 	if !ok {
@@ -2,28 +2,48 @@ package powens
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"figenn/internal/aggregator"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 )
 
 const (
-	PowensAPIBaseURL  = "https://figenn-sandbox.biapi.pro/2.0"
-	EndpointAuthInit  = "/auth/init"
-	EndpointAuthToken = "/auth/token"
+	// DefaultDomain is the Powens sandbox used when no domain is configured.
+	DefaultDomain       = "figenn-sandbox.biapi.pro"
+	WebviewURL          = "https://webview.powens.com/connect"
+	ReconnectWebviewURL = "https://webview.powens.com/reconnect"
+	EndpointAuthInit    = "/auth/init"
+	EndpointAuthToken   = "/auth/token"
+	EndpointAccounts    = "/users/me/accounts"
+	EndpointConnections = "/users/me/connections"
+
+	// transactionsPageSize is the largest page Powens serves.
+	transactionsPageSize = 1000
 )
 
+// Client talks to the Powens API. It implements aggregator.BankAggregator.
 type Client struct {
 	hc           *http.Client
+	baseURL      string
+	domain       string
 	clientID     string
 	clientSecret string
 }
 
-func NewClient(clientID, clientSecret string) *Client {
+var _ aggregator.BankAggregator = (*Client)(nil)
+
+func NewClient(domain, clientID, clientSecret string) *Client {
+	if domain == "" {
+		domain = DefaultDomain
+	}
 	return &Client{
 		hc: &http.Client{
 			Timeout: 30 * time.Second,
@@ -33,31 +53,33 @@ func NewClient(clientID, clientSecret string) *Client {
 				IdleConnTimeout:     90 * time.Second,
 			},
 		},
+		baseURL:      "https://" + domain + "/2.0",
+		domain:       domain,
 		clientID:     clientID,
 		clientSecret: clientSecret,
 	}
 }
 
-func (c *Client) CreatePowensAccount(ctx echo.Context, userID uuid.UUID) (string, int, error) {
+func (c *Client) InitUser(ctx context.Context) (*aggregator.User, error) {
 	reqBody := PowensInitBody{
 		ClientID:     c.clientID,
 		ClientSecret: c.clientSecret,
 	}
 
 	var respData PowensInitResponse
-	err := c.doRequest(ctx, http.MethodPost, PowensAPIBaseURL+EndpointAuthInit, reqBody, "", &respData)
+	err := c.doRequest(ctx, http.MethodPost, c.baseURL+EndpointAuthInit, reqBody, "", &respData)
 	if err != nil {
-		return "", 0, errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	return respData.AuthToken, respData.IdUser, nil
+	return &aggregator.User{ID: int64(respData.IdUser), AccessToken: respData.AuthToken}, nil
 }
 
-func (c *Client) CreateTemporaryToken(ctx echo.Context, authToken string) (string, error) {
+func (c *Client) CreateTemporaryToken(ctx context.Context, accessToken string) (string, error) {
 	reqBody := map[string]interface{}{"duration": 3600}
 
 	var respData TokenResponse
-	err := c.doRequest(ctx, http.MethodPost, PowensAPIBaseURL+EndpointAuthToken, reqBody, authToken, &respData)
+	err := c.doRequest(ctx, http.MethodPost, c.baseURL+EndpointAuthToken, reqBody, accessToken, &respData)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -65,13 +87,143 @@ func (c *Client) CreateTemporaryToken(ctx echo.Context, authToken string) (strin
 	return respData.Token, nil
 }
 
-func (c *Client) doRequest(ctx echo.Context, method, url string, requestBody interface{}, authToken string, responseData interface{}) error {
-	jsonData, err := json.Marshal(requestBody)
+func (c *Client) ConnectURL(temporaryToken, redirectURI string) (string, error) {
+	if c.clientID == "" || redirectURI == "" {
+		return "", errors.New("missing required config values")
+	}
+
+	urlValues := url.Values{}
+	urlValues.Set("domain", c.domain)
+	urlValues.Set("client_id", c.clientID)
+	urlValues.Set("redirect_uri", redirectURI)
+	urlValues.Set("code", temporaryToken)
+	return WebviewURL + "?" + urlValues.Encode(), nil
+}
+
+func (c *Client) ReconnectURL(temporaryToken, redirectURI string, connectionID int64) (string, error) {
+	if c.clientID == "" || redirectURI == "" {
+		return "", errors.New("missing required config values")
+	}
+
+	urlValues := url.Values{}
+	urlValues.Set("domain", c.domain)
+	urlValues.Set("client_id", c.clientID)
+	urlValues.Set("redirect_uri", redirectURI)
+	urlValues.Set("code", temporaryToken)
+	urlValues.Set("connection_id", strconv.FormatInt(connectionID, 10))
+	return ReconnectWebviewURL + "?" + urlValues.Encode(), nil
+}
+
+func (c *Client) ListConnections(ctx context.Context, accessToken string) ([]*aggregator.Connection, error) {
+	var respData ConnectionsResponse
+	err := c.doRequest(ctx, http.MethodGet, c.baseURL+EndpointConnections, nil, accessToken, &respData)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
+	}
+
+	connections := make([]*aggregator.Connection, len(respData.Connections))
+	for i, pc := range respData.Connections {
+		connections[i] = toConnection(pc)
+	}
+	return connections, nil
+}
+
+func (c *Client) SyncConnection(ctx context.Context, accessToken string, connectionID int64) (*aggregator.Connection, error) {
+	var respData Connection
+	err := c.doRequest(ctx, http.MethodPut, c.connectionURL(connectionID), nil, accessToken, &respData)
+	if err != nil {
+		return nil, connectionError(err)
+	}
+	return toConnection(&respData), nil
+}
+
+func (c *Client) DeleteConnection(ctx context.Context, accessToken string, connectionID int64) error {
+	err := c.doRequest(ctx, http.MethodDelete, c.connectionURL(connectionID), nil, accessToken, nil)
+	return connectionError(err)
+}
+
+func (c *Client) connectionURL(connectionID int64) string {
+	return c.baseURL + EndpointConnections + "/" + strconv.FormatInt(connectionID, 10)
+}
+
+// connectionError reports a missing connection as
+// aggregator.ErrConnectionNotFound.
+func connectionError(err error) error {
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusNotFound {
+		return aggregator.ErrConnectionNotFound
+	}
+	return errors.WithStack(err)
+}
+
+func (c *Client) ListAccounts(ctx context.Context, accessToken string, connectionID int64) ([]*aggregator.Account, error) {
+	endpoint := c.connectionURL(connectionID) + "/accounts?all"
+	var respData AccountsResponse
+	if err := c.doRequest(ctx, http.MethodGet, endpoint, nil, accessToken, &respData); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	accounts := make([]*aggregator.Account, len(respData.Accounts))
+	for i, pa := range respData.Accounts {
+		accounts[i] = toAccount(pa)
+	}
+	return accounts, nil
+}
+
+// ListTransactions fetches as many pages as needed.
+func (c *Client) ListTransactions(ctx context.Context, accessToken string, accountID int64, query aggregator.TransactionsQuery) ([]*aggregator.Transaction, error) {
+	endpoint := c.baseURL + EndpointAccounts + "/" + strconv.FormatInt(accountID, 10) + "/transactions"
+
+	var transactions []*aggregator.Transaction
+	for offset := 0; ; offset += transactionsPageSize {
+		params := url.Values{}
+		if query.MinDate != nil {
+			params.Set("min_date", query.MinDate.Format(time.DateOnly))
+		}
+		if query.LastUpdate != nil {
+			params.Set("last_update", query.LastUpdate.UTC().Format(powensTimeLayout))
+			params.Set("all", "")
+		}
+		params.Set("limit", strconv.Itoa(transactionsPageSize))
+		params.Set("offset", strconv.Itoa(offset))
+
+		var respData TransactionsResponse
+		err := c.doRequest(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil, accessToken, &respData)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, pt := range respData.Transactions {
+			if tx, ok := toTransaction(pt); ok {
+				transactions = append(transactions, tx)
+			}
+		}
+		if len(respData.Transactions) < transactionsPageSize {
+			return transactions, nil
+		}
+	}
+}
+
+// statusError is returned when Powens answers with an error status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.code, e.status)
+}
+
+func (c *Client) doRequest(ctx context.Context, method, url string, requestBody interface{}, authToken string, responseData interface{}) error {
+	var body io.Reader
+	if requestBody != nil {
+		jsonData, err := json.Marshal(requestBody)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		body = bytes.NewBuffer(jsonData)
 	}
 
-	req, err := http.NewRequestWithContext(ctx.Request().Context(), method, url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -87,10 +239,13 @@ func (c *Client) doRequest(ctx echo.Context, method, url string, requestBody int
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("API returned status %d: %s", resp.StatusCode, resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{code: resp.StatusCode, status: resp.Status}
 	}
 
+	if responseData == nil {
+		return nil
+	}
 	if err := json.NewDecoder(resp.Body).Decode(responseData); err != nil {
 		return errors.WithStack(err)
 	}
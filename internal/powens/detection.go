@@ -0,0 +1,229 @@
+package powens
+
+import (
+	"figenn/internal/aggregator"
+	"figenn/internal/subscriptions"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DetectionWindowDays is how far back transactions are analysed, enough to
+// see an annual subscription renew once.
+const DetectionWindowDays = 400
+
+// cyclePattern describes the spacing in days between the charges of a
+// billing cycle and how much a single interval may deviate from it.
+type cyclePattern struct {
+	cycle          subscriptions.BillingCycleType
+	days           float64
+	tolerance      float64
+	minOccurrences int
+}
+
+var cyclePatterns = []cyclePattern{
+	{subscriptions.Weekly, 7, 1, 4},
+	{subscriptions.Biweekly, 14, 2, 3},
+	{subscriptions.Monthly, 30.44, 4, 3},
+	{subscriptions.Quarterly, 91.31, 8, 3},
+	{subscriptions.SemiAnnual, 182.62, 10, 2},
+	{subscriptions.Annual, 365.25, 12, 2},
+}
+
+// noiseWords are banking terms that prefix transaction labels without
+// identifying the merchant.
+var noiseWords = map[string]bool{
+	"PRLV": true, "SEPA": true, "CB": true, "CARTE": true, "PAIEMENT": true, "PAR": true, "VIR": true,
+	"VIREMENT": true, "PRELEVEMENT": true, "ECH": true, "FACTURE": true, "FAC": true, "DU": true,
+	"LE": true, "EUR": true, "COM": true, "WWW": true, "HTTP": true, "HTTPS": true,
+}
+
+const (
+	// amountTolerance is the relative deviation from the median amount a
+	// charge may have, which lets price increases through.
+	amountTolerance = 0.2
+	// minRegularity is the share of intervals that must match the cycle.
+	minRegularity = 0.75
+)
+
+type debit struct {
+	date   time.Time
+	amount float64
+	label  string
+}
+
+// DetectRecurring finds the series of debits in transactions that look like
+// a subscription: same merchant, similar amount and a regular interval
+// matching a billing cycle. currencies maps account ids to their currency,
+// EUR being assumed for unknown accounts. Series whose last charge is more
+// than a cycle and a half old are considered cancelled and left out.
+func DetectRecurring(transactions []*aggregator.Transaction, currencies map[int64]string, now time.Time) []*SubscriptionCandidate {
+	groups := make(map[string][]*debit)
+	var keys []string
+	for _, tx := range transactions {
+		if tx.Coming || tx.Deleted || tx.Value >= 0 {
+			continue
+		}
+		label := tx.Wording
+		merchant := merchantKey(label)
+		if merchant == "" {
+			continue
+		}
+
+		amount, currency := -tx.Value, currencies[tx.AccountID]
+		if tx.OriginalValue != nil && tx.OriginalCurrency != "" {
+			amount, currency = math.Abs(*tx.OriginalValue), tx.OriginalCurrency
+		}
+		if currency == "" {
+			currency = "EUR"
+		}
+
+		key := merchant + "|" + currency
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], &debit{date: tx.Date, amount: amount, label: label})
+	}
+
+	candidates := []*SubscriptionCandidate{}
+	sort.Strings(keys)
+	for _, key := range keys {
+		merchant, currency, _ := strings.Cut(key, "|")
+		if candidate := detectSeries(groups[key], now); candidate != nil {
+			candidate.MerchantKey = merchant
+			candidate.Name = merchantName(merchant)
+			candidate.Currency = currency
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+func detectSeries(debits []*debit, now time.Time) *SubscriptionCandidate {
+	sort.SliceStable(debits, func(i, j int) bool {
+		return debits[i].date.Before(debits[j].date)
+	})
+
+	amounts := make([]float64, len(debits))
+	for i, d := range debits {
+		amounts[i] = d.amount
+	}
+	typical := median(amounts)
+
+	var series []*debit
+	for _, d := range debits {
+		if math.Abs(d.amount-typical) > typical*amountTolerance {
+			continue
+		}
+		// A same day charge of the same amount is a duplicate, not a cycle.
+		if n := len(series); n > 0 && series[n-1].date.Equal(d.date) {
+			continue
+		}
+		series = append(series, d)
+	}
+	if len(series) < 2 {
+		return nil
+	}
+
+	intervals := make([]float64, len(series)-1)
+	for i := 1; i < len(series); i++ {
+		intervals[i-1] = series[i].date.Sub(series[i-1].date).Hours() / 24
+	}
+	pattern, ok := matchCycle(median(intervals))
+	if !ok || len(series) < pattern.minOccurrences {
+		return nil
+	}
+
+	regular := 0
+	for _, interval := range intervals {
+		if math.Abs(interval-pattern.days) <= pattern.tolerance {
+			regular++
+		}
+	}
+	regularity := float64(regular) / float64(len(intervals))
+	if regularity < minRegularity {
+		return nil
+	}
+
+	first, last := series[0], series[len(series)-1]
+	if now.Sub(last.date).Hours()/24 > pattern.days*1.5+pattern.tolerance {
+		return nil
+	}
+
+	sub := &subscriptions.Subscription{StartDate: first.date, BillingCycle: pattern.cycle}
+	next, _ := subscriptions.NextChargeDate(sub, last.date.AddDate(0, 0, 1))
+
+	return &SubscriptionCandidate{
+		Amount:          math.Round(last.amount*100) / 100,
+		BillingCycle:    pattern.cycle,
+		FirstChargeDate: first.date,
+		LastChargeDate:  last.date,
+		NextChargeDate:  next,
+		Occurrences:     len(series),
+		Confidence:      math.Round(regularity*float64(len(series))/float64(len(debits))*100) / 100,
+		Label:           truncate(last.label, 255),
+	}
+}
+
+func matchCycle(interval float64) (cyclePattern, bool) {
+	for _, pattern := range cyclePatterns {
+		if math.Abs(interval-pattern.days) <= pattern.tolerance {
+			return pattern, true
+		}
+	}
+	return cyclePattern{}, false
+}
+
+// merchantKey reduces a transaction label to the words identifying the
+// merchant: banking terms and words carrying digits, such as references and
+// dates, are dropped and the first two remaining words kept.
+func merchantKey(label string) string {
+	fields := strings.FieldsFunc(strings.ToUpper(label), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var words []string
+	for _, field := range fields {
+		if len([]rune(field)) < 2 || noiseWords[field] || strings.IndexFunc(field, unicode.IsDigit) >= 0 {
+			continue
+		}
+		words = append(words, field)
+		if len(words) == 2 {
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// merchantName turns a merchant key into a subscription name.
+func merchantName(key string) string {
+	words := strings.Fields(strings.ToLower(key))
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return truncate(strings.Join(words, " "), 30)
+}
+
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
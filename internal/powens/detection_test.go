@@ -0,0 +1,81 @@
+package powens
+
+import (
+	"encoding/json"
+	"figenn/internal/aggregator"
+	"figenn/internal/subscriptions"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTransactions(t *testing.T, name string) []*aggregator.Transaction {
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp TransactionsResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	var transactions []*aggregator.Transaction
+	for _, pt := range resp.Transactions {
+		if tx, ok := toTransaction(pt); ok {
+			transactions = append(transactions, tx)
+		}
+	}
+	return transactions
+}
+
+func TestDetectRecurring(t *testing.T) {
+	transactions := loadTransactions(t, "transactions.json")
+	now := time.Date(2025, time.April, 30, 0, 0, 0, 0, time.UTC)
+
+	candidates := DetectRecurring(transactions, map[int64]string{1: "EUR"}, now)
+
+	byName := make(map[string]*SubscriptionCandidate)
+	for _, c := range candidates {
+		byName[c.Name] = c
+	}
+	assert.Len(t, candidates, 4)
+
+	netflix := byName["Netflix"]
+	if assert.NotNil(t, netflix) {
+		assert.Equal(t, subscriptions.Monthly, netflix.BillingCycle)
+		assert.Equal(t, 15.49, netflix.Amount)
+		assert.Equal(t, "EUR", netflix.Currency)
+		assert.Equal(t, 7, netflix.Occurrences)
+		assert.Equal(t, time.Date(2025, time.May, 12, 0, 0, 0, 0, time.UTC), netflix.NextChargeDate)
+	}
+
+	spotify := byName["Spotify Ab"]
+	if assert.NotNil(t, spotify) {
+		assert.Equal(t, subscriptions.Monthly, spotify.BillingCycle)
+		assert.Equal(t, 10.99, spotify.Amount)
+	}
+
+	prime := byName["Amazon Prime"]
+	if assert.NotNil(t, prime) {
+		assert.Equal(t, subscriptions.Annual, prime.BillingCycle)
+		assert.Equal(t, 2, prime.Occurrences)
+	}
+
+	github := byName["Github Inc"]
+	if assert.NotNil(t, github) {
+		assert.Equal(t, "USD", github.Currency)
+		assert.Equal(t, 4.0, github.Amount)
+	}
+
+	assert.Nil(t, byName["Basic Fit"], "cancelled subscriptions are not proposed")
+	assert.Nil(t, byName["Carrefour Market"], "irregular spending is not a subscription")
+	assert.Nil(t, byName["Salaire Acme"], "credits are ignored")
+}
+
+func TestMerchantKey(t *testing.T) {
+	assert.Equal(t, "NETFLIX", merchantKey("NETFLIX.COM"))
+	assert.Equal(t, "SPOTIFY AB", merchantKey("PRLV SEPA SPOTIFY AB REF1234"))
+	assert.Equal(t, "FREE MOBILE", merchantKey("CB FREE MOBILE 12/03 P1234ABC"))
+	assert.Equal(t, "", merchantKey("CB 12/03"))
+}
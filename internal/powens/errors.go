@@ -0,0 +1,11 @@
+package powens
+
+import "errors"
+
+var (
+	ErrNoPowensAccount         = errors.New("user has no Powens account")
+	ErrCandidateNotFound       = errors.New("subscription candidate not found")
+	ErrCandidateAlreadyHandled = errors.New("subscription candidate was already accepted or dismissed")
+	ErrUnknownWebhookEvent     = errors.New("unknown webhook event type")
+	ErrInvalidWebhookPayload   = errors.New("invalid webhook payload")
+)
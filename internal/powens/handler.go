@@ -1,25 +1,46 @@
 package powens
 
 import (
+	"errors"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/subscriptions"
+	"figenn/internal/users"
+	"io"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
 type API struct {
+	JWTKeys *jwtkeys.KeySet
 	service *Service
+	users   *users.Service
 }
 
-func NewAPI(service *Service) *API {
-	return &API{service: service}
+func NewAPI(keys *jwtkeys.KeySet, service *Service, userService *users.Service) *API {
+	return &API{JWTKeys: keys, service: service, users: userService}
 }
 
 func (h *API) Bind(rg *echo.Group) {
 	powensGroup := rg.Group("/powens")
-	powensGroup.POST("/create", h.createPowensAccount)
+	powensGroup.POST("/webhook", h.receiveWebhook)
+
+	authGroup := powensGroup.Group("", users.CookieAuthMiddleware(h.JWTKeys))
+	authGroup.POST("/create", h.createPowensAccount, users.VerifiedEmailMiddleware(h.users))
+	authGroup.POST("/detect", h.detectSubscriptions)
+	authGroup.GET("/candidates", h.listCandidates)
+	authGroup.POST("/candidates/:id/accept", h.acceptCandidate)
+	authGroup.POST("/candidates/:id/dismiss", h.dismissCandidate)
 }
 
+// createPowensAccount links the bank accounts of the signed-in user. The
+// user_id of the payload, kept for older clients, must be theirs.
 func (h *API) createPowensAccount(ctx echo.Context) error {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return err
+	}
 	var req CreatePowensAccountRequest
 	if err := ctx.Bind(&req); err != nil {
 		return ctx.JSON(http.StatusBadRequest, echo.Map{
@@ -27,8 +48,13 @@ func (h *API) createPowensAccount(ctx echo.Context) error {
 			"details": err.Error(),
 		})
 	}
+	if req.UserID != uuid.Nil && req.UserID != userID {
+		return ctx.JSON(http.StatusForbidden, echo.Map{
+			"message": "Cannot link bank accounts for another user",
+		})
+	}
 
-	connectURL, err := h.service.CreateAccount(ctx, req.UserID)
+	connectURL, err := h.service.CreateAccount(ctx.Request().Context(), userID)
 	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, echo.Map{
 			"message": "Failed to create Powens account",
@@ -41,39 +67,129 @@ func (h *API) createPowensAccount(ctx echo.Context) error {
 	})
 }
 
-// // PowensWebhook gère la réception des webhooks de Powens
-// func (a *API) PowensWebhook(c echo.Context) error {
-// 	var webhookData WebhookPayload
-
-// 	// Décoder le JSON reçu
-// 	if err := json.NewDecoder(c.Request().Body).Decode(&webhookData); err != nil {
-// 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid webhook payload"})
-// 	}
-
-// 	fmt.Println("Webhook received:", webhookData)
-
-// 	// Vérifier que l'ID utilisateur est bien présent
-// 	idUser := webhookData.WebhookReceived.ID
-// 	if idUser == 0 {
-// 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Missing id_user in webhook"})
-// 	}
-
-// 	// Récupération du token d'accès
-// 	accessToken, err := getAccessToken(idUser)
-// 	if err != nil {
-// 		fmt.Println("Error getting access token:", err)
-// 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to get access token"})
-// 	}
-
-// 	// Récupération des transactions
-// 	transactions, err := getTransactions(idUser, accessToken)
-// 	if err != nil {
-// 		fmt.Println("Error getting transactions:", err)
-// 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to get transactions"})
-// 	}
-
-// 	return c.JSON(http.StatusOK, echo.Map{
-// 		"status":       "success",
-// 		"transactions": transactions,
-// 	})
-// }
+// maxWebhookBodySize bounds the size of a webhook payload. ACCOUNT_SYNCED
+// events carry the new transactions and can be large.
+const maxWebhookBodySize = 10 << 20
+
+// receiveWebhook stores a Powens event in the inbox and acknowledges it right
+// away. CONNECTION_SYNCED, ACCOUNT_SYNCED and USER_DELETED all point to this
+// URL in the Powens console; the scheduled powens-webhooks job processes them.
+func (h *API) receiveWebhook(ctx echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxWebhookBodySize))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Unable to read payload"})
+	}
+
+	req := ctx.Request()
+	if !h.service.VerifyWebhook(req.Method, req.URL.Path, req.Header.Get("BI-Signature-Date"), body, req.Header.Get("BI-Signature")) {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"message": "Invalid signature"})
+	}
+
+	_, err = h.service.ReceiveWebhook(req.Context(), body)
+	switch {
+	case errors.Is(err, ErrUnknownWebhookEvent), errors.Is(err, ErrInvalidWebhookPayload):
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	case err != nil:
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": "Failed to store webhook event"})
+	}
+
+	return ctx.NoContent(http.StatusOK)
+}
+
+func (h *API) detectSubscriptions(ctx echo.Context) error {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := h.service.DetectSubscriptions(ctx.Request().Context(), userID)
+	if err != nil {
+		return handleServiceError(ctx, err, "Failed to detect subscriptions")
+	}
+
+	return ctx.JSON(http.StatusOK, candidates)
+}
+
+func (h *API) listCandidates(ctx echo.Context) error {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := h.service.ListCandidates(ctx.Request().Context(), userID)
+	if err != nil {
+		return handleServiceError(ctx, err, "Failed to list subscription candidates")
+	}
+
+	return ctx.JSON(http.StatusOK, candidates)
+}
+
+func (h *API) acceptCandidate(ctx echo.Context) error {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return err
+	}
+	candidateID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Invalid candidate ID"})
+	}
+
+	var req AcceptCandidateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Invalid request payload",
+			"details": err.Error(),
+		})
+	}
+
+	sub, err := h.service.AcceptCandidate(ctx.Request().Context(), userID, candidateID, req)
+	if err != nil {
+		return handleServiceError(ctx, err, "Failed to accept subscription candidate")
+	}
+
+	return ctx.JSON(http.StatusCreated, sub)
+}
+
+func (h *API) dismissCandidate(ctx echo.Context) error {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return err
+	}
+	candidateID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "Invalid candidate ID"})
+	}
+
+	if err := h.service.DismissCandidate(ctx.Request().Context(), userID, candidateID); err != nil {
+		return handleServiceError(ctx, err, "Failed to dismiss subscription candidate")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func getUserID(ctx echo.Context) (uuid.UUID, error) {
+	userID, ok := ctx.Get("user_id").(string)
+	if !ok {
+		return uuid.Nil, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	return id, nil
+}
+
+func handleServiceError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, ErrNoPowensAccount):
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": "No bank connection found"})
+	case errors.Is(err, ErrCandidateNotFound):
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": "Subscription candidate not found"})
+	case errors.Is(err, ErrCandidateAlreadyHandled):
+		return ctx.JSON(http.StatusConflict, echo.Map{"message": "Subscription candidate was already accepted or dismissed"})
+	case subscriptions.IsValidationError(err):
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": message})
+	}
+}
@@ -1,41 +1,66 @@
 package powens
 
 import (
+	"figenn/internal/aggregator"
+	"figenn/internal/subscriptions"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-type WebhookPayload struct {
-	WebhookReceived WebhookData `json:"webhook_received"`
+// WebhookEventType is the kind of event Powens notifies.
+type WebhookEventType string
+
+const (
+	EventConnectionSynced WebhookEventType = "CONNECTION_SYNCED"
+	EventAccountSynced    WebhookEventType = "ACCOUNT_SYNCED"
+	EventUserDeleted      WebhookEventType = "USER_DELETED"
+)
+
+type WebhookEventStatus string
+
+const (
+	WebhookPending    WebhookEventStatus = "pending"
+	WebhookProcessing WebhookEventStatus = "processing"
+	WebhookProcessed  WebhookEventStatus = "processed"
+	WebhookFailed     WebhookEventStatus = "failed"
+)
+
+// WebhookEvent is a webhook call stored in the inbox before being processed.
+type WebhookEvent struct {
+	ID         string
+	Type       WebhookEventType
+	Payload    []byte
+	Status     WebhookEventStatus
+	Attempts   int
+	LastError  *string
+	ReceivedAt time.Time
 }
 
-type WebhookData struct {
-	ID            int            `json:"id"`
-	IDWebhookData int            `json:"id_webhook_data"`
-	Platform      string         `json:"platform"`
-	Signin        time.Time      `json:"signin"`
-	Request       RequestDetails `json:"request_details"`
+type WebhookUser struct {
+	ID int `json:"id"`
 }
 
-type RequestDetails struct {
-	Time      time.Time `json:"time"`
-	ID        string    `json:"id"`
-	RemoteIP  string    `json:"remote_ip"`
-	Host      string    `json:"host"`
-	Method    string    `json:"method"`
-	URI       string    `json:"uri"`
-	UserAgent string    `json:"user_agent"`
-	Status    int       `json:"status"`
-	Error     string    `json:"error"`
-	Latency   Latency   `json:"latency"`
-	BytesIn   int       `json:"bytes_in"`
-	BytesOut  int       `json:"bytes_out"`
+type WebhookConnection struct {
+	ID     int64   `json:"id"`
+	IDUser int     `json:"id_user"`
+	State  *string `json:"state"`
 }
 
-type Latency struct {
-	Microseconds  int    `json:"microseconds"`
-	HumanReadable string `json:"human_readable"`
+type ConnectionSyncedPayload struct {
+	User       WebhookUser       `json:"user"`
+	Connection WebhookConnection `json:"connection"`
+}
+
+type AccountSyncedPayload struct {
+	ID           int64          `json:"id"`
+	IDUser       int            `json:"id_user"`
+	IDConnection int64          `json:"id_connection"`
+	Transactions []*Transaction `json:"transactions"`
+}
+
+type UserDeletedPayload struct {
+	ID int `json:"id"`
 }
 
 type PowensAccount struct {
@@ -68,3 +93,191 @@ type TokenResponse struct {
 	ExpiresIn int    `json:"expires_in"`
 	ExpireIn  int    `json:"expire_in"`
 }
+
+type Currency struct {
+	ID string `json:"id"`
+}
+
+// Connection is the link between a Powens user and one of their banks.
+type Connection struct {
+	ID           int64   `json:"id"`
+	IDUser       int     `json:"id_user"`
+	IDConnector  int64   `json:"id_connector"`
+	State        *string `json:"state"`
+	ErrorMessage *string `json:"error_message"`
+	LastUpdate   *string `json:"last_update"`
+	Active       bool    `json:"active"`
+}
+
+type ConnectionsResponse struct {
+	Connections []*Connection `json:"connections"`
+}
+
+type Account struct {
+	ID           int64    `json:"id"`
+	IDConnection int64    `json:"id_connection"`
+	Name         string   `json:"name"`
+	Number       *string  `json:"number"`
+	IBAN         *string  `json:"iban"`
+	Type         string   `json:"type"`
+	Balance      float64  `json:"balance"`
+	Currency     Currency `json:"currency"`
+	Disabled     *string  `json:"disabled"`
+	Deleted      *string  `json:"deleted"`
+	LastUpdate   *string  `json:"last_update"`
+}
+
+type AccountsResponse struct {
+	Accounts []*Account `json:"accounts"`
+}
+
+// Transaction is a bank transaction as returned by Powens. Debits have a
+// negative value.
+type Transaction struct {
+	ID                int64     `json:"id"`
+	AccountID         int64     `json:"id_account"`
+	Date              string    `json:"date"`
+	Value             float64   `json:"value"`
+	OriginalValue     *float64  `json:"original_value"`
+	OriginalCurrency  *Currency `json:"original_currency"`
+	Wording           string    `json:"wording"`
+	SimplifiedWording string    `json:"simplified_wording"`
+	OriginalWording   string    `json:"original_wording"`
+	Type              string    `json:"type"`
+	Coming            bool      `json:"coming"`
+	LastUpdate        *string   `json:"last_update"`
+	Deleted           *string   `json:"deleted"`
+}
+
+// Label returns the most readable wording available.
+func (t *Transaction) Label() string {
+	for _, wording := range []string{t.SimplifiedWording, t.Wording, t.OriginalWording} {
+		if wording != "" {
+			return wording
+		}
+	}
+	return ""
+}
+
+type TransactionsResponse struct {
+	Transactions []*Transaction `json:"transactions"`
+}
+
+type CandidateStatus string
+
+const (
+	CandidatePending   CandidateStatus = "pending"
+	CandidateAccepted  CandidateStatus = "accepted"
+	CandidateDismissed CandidateStatus = "dismissed"
+)
+
+// SubscriptionCandidate is a recurring debit found in the transactions of a
+// user, proposed to them as a subscription.
+type SubscriptionCandidate struct {
+	ID              uuid.UUID                      `json:"id"`
+	UserID          uuid.UUID                      `json:"user_id"`
+	MerchantKey     string                         `json:"merchant_key"`
+	Name            string                         `json:"name"`
+	Label           string                         `json:"label"`
+	Amount          float64                        `json:"amount"`
+	Currency        string                         `json:"currency"`
+	BillingCycle    subscriptions.BillingCycleType `json:"billing_cycle"`
+	FirstChargeDate time.Time                      `json:"first_charge_date"`
+	LastChargeDate  time.Time                      `json:"last_charge_date"`
+	NextChargeDate  time.Time                      `json:"next_charge_date"`
+	Occurrences     int                            `json:"occurrences"`
+	// Confidence goes from 0 to 1 and reflects how regular the series is.
+	Confidence     float64         `json:"confidence"`
+	Status         CandidateStatus `json:"status"`
+	SubscriptionID *string         `json:"subscription_id,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// AcceptCandidateRequest lets the user adjust a candidate before it becomes
+// a subscription.
+type AcceptCandidateRequest struct {
+	Name     *string  `json:"name" form:"name"`
+	Category string   `json:"category" form:"category"`
+	Color    string   `json:"color" form:"color"`
+	Price    *float64 `json:"price" form:"price"`
+}
+
+// powensTimeLayout is the layout of the timestamps returned by Powens.
+const powensTimeLayout = "2006-01-02 15:04:05"
+
+// parseTime parses a Powens timestamp, which is either a date, a date and
+// time or an RFC 3339 time. It returns false for empty or malformed values.
+func parseTime(value *string) (time.Time, bool) {
+	if value == nil || *value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{powensTimeLayout, time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, *value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseTimePtr(value *string) *time.Time {
+	if t, ok := parseTime(value); ok {
+		return &t
+	}
+	return nil
+}
+
+func toConnection(pc *Connection) *aggregator.Connection {
+	return &aggregator.Connection{
+		ID:           pc.ID,
+		ConnectorID:  pc.IDConnector,
+		State:        pc.State,
+		ErrorMessage: pc.ErrorMessage,
+		LastUpdate:   parseTimePtr(pc.LastUpdate),
+		Active:       pc.Active,
+	}
+}
+
+func toAccount(pa *Account) *aggregator.Account {
+	_, disabled := parseTime(pa.Disabled)
+	_, deleted := parseTime(pa.Deleted)
+	return &aggregator.Account{
+		ID:           pa.ID,
+		ConnectionID: pa.IDConnection,
+		Name:         pa.Name,
+		Number:       pa.Number,
+		IBAN:         pa.IBAN,
+		Type:         pa.Type,
+		Balance:      pa.Balance,
+		Currency:     pa.Currency.ID,
+		Disabled:     disabled,
+		Deleted:      deleted,
+	}
+}
+
+// toTransaction converts a Powens transaction, reporting false when its date
+// can't be parsed.
+func toTransaction(pt *Transaction) (*aggregator.Transaction, bool) {
+	date, err := time.Parse(time.DateOnly, pt.Date)
+	if err != nil {
+		return nil, false
+	}
+	_, deleted := parseTime(pt.Deleted)
+	tx := &aggregator.Transaction{
+		ID:              pt.ID,
+		AccountID:       pt.AccountID,
+		Date:            date,
+		Value:           pt.Value,
+		OriginalValue:   pt.OriginalValue,
+		Wording:         pt.Label(),
+		OriginalWording: pt.OriginalWording,
+		Type:            pt.Type,
+		Coming:          pt.Coming,
+		LastUpdate:      parseTimePtr(pt.LastUpdate),
+		Deleted:         deleted,
+	}
+	if pt.OriginalCurrency != nil {
+		tx.OriginalCurrency = pt.OriginalCurrency.ID
+	}
+	return tx, true
+}
@@ -2,23 +2,38 @@ package powens
 
 import (
 	"context"
+	"errors"
 	"figenn/internal/database"
+	"figenn/internal/encryption"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
+// EncryptedColumns lists the columns the repository encrypts.
+var EncryptedColumns = []encryption.Column{accessTokenColumn}
+
+var accessTokenColumn = encryption.Column{Table: "powens_accounts", Column: "access_token"}
+
 type Repository struct {
-	s database.DbService
+	s       database.DbService
+	keyring *encryption.Keyring
 }
 
-func NewRepository(db database.DbService) *Repository {
+func NewRepository(db database.DbService, keyring *encryption.Keyring) *Repository {
 	return &Repository{
-		s: db,
+		s:       db,
+		keyring: keyring,
 	}
 }
 
 func (r *Repository) SetPowensAccount(ctx context.Context, userID uuid.UUID, powensID int, accessToken string) error {
+	encrypted, err := r.keyring.Encrypt(accessTokenColumn.Field(), accessToken)
+	if err != nil {
+		return err
+	}
+
 	query := `
         INSERT INTO powens_accounts (user_id, powens_id, access_token, created_at, updated_at)
         VALUES ($1, $2, $3, $4, $4)
@@ -27,6 +42,251 @@ func (r *Repository) SetPowensAccount(ctx context.Context, userID uuid.UUID, pow
             access_token = $3,
             updated_at = $4
     `
-	_, err := r.s.Pool().Exec(ctx, query, userID.String(), powensID, accessToken, time.Now())
+	_, err = r.s.Pool().Exec(ctx, query, userID.String(), powensID, encrypted, time.Now())
+	return err
+}
+
+func (r *Repository) GetPowensAccount(ctx context.Context, userID uuid.UUID) (*PowensAccount, error) {
+	query := `
+        SELECT id, user_id, powens_id, access_token, created_at, updated_at
+        FROM powens_accounts
+        WHERE user_id = $1
+    `
+	account := new(PowensAccount)
+	err := r.s.Pool().QueryRow(ctx, query, userID.String()).Scan(
+		&account.ID, &account.UserID, &account.PowensID, &account.AccessToken, &account.CreatedAt, &account.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoPowensAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+	if account.AccessToken, err = r.keyring.Decrypt(accessTokenColumn.Field(), account.AccessToken); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+const candidateColumns = `
+        id, user_id, merchant_key, name, label, amount, currency, billing_cycle, first_charge_date,
+        last_charge_date, next_charge_date, occurrences, confidence, status, subscription_id, created_at, updated_at
+`
+
+func scanCandidate(row pgx.Row) (*SubscriptionCandidate, error) {
+	c := new(SubscriptionCandidate)
+	err := row.Scan(
+		&c.ID, &c.UserID, &c.MerchantKey, &c.Name, &c.Label, &c.Amount, &c.Currency, &c.BillingCycle, &c.FirstChargeDate,
+		&c.LastChargeDate, &c.NextChargeDate, &c.Occurrences, &c.Confidence, &c.Status, &c.SubscriptionID, &c.CreatedAt, &c.UpdatedAt,
+	)
+	return c, err
+}
+
+// UpsertCandidates stores freshly detected candidates. A merchant already
+// proposed keeps its status, so accepted and dismissed candidates are not
+// proposed again; only the detected figures are refreshed.
+func (r *Repository) UpsertCandidates(ctx context.Context, userID uuid.UUID, candidates []*SubscriptionCandidate) error {
+	query := `
+        INSERT INTO subscription_candidates (
+            user_id, merchant_key, name, label, amount, currency, billing_cycle, first_charge_date,
+            last_charge_date, next_charge_date, occurrences, confidence, status, created_at, updated_at
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14)
+        ON CONFLICT (user_id, merchant_key, currency)
+        DO UPDATE SET
+            label = EXCLUDED.label,
+            amount = EXCLUDED.amount,
+            billing_cycle = EXCLUDED.billing_cycle,
+            first_charge_date = EXCLUDED.first_charge_date,
+            last_charge_date = EXCLUDED.last_charge_date,
+            next_charge_date = EXCLUDED.next_charge_date,
+            occurrences = EXCLUDED.occurrences,
+            confidence = EXCLUDED.confidence,
+            updated_at = EXCLUDED.updated_at
+    `
+	tx, err := r.s.Pool().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	for _, c := range candidates {
+		_, err := tx.Exec(ctx, query,
+			userID.String(), c.MerchantKey, c.Name, c.Label, c.Amount, c.Currency, c.BillingCycle, c.FirstChargeDate,
+			c.LastChargeDate, c.NextChargeDate, c.Occurrences, c.Confidence, CandidatePending, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *Repository) ListCandidates(ctx context.Context, userID uuid.UUID, status CandidateStatus) ([]*SubscriptionCandidate, error) {
+	query := `SELECT ` + candidateColumns + `
+        FROM subscription_candidates
+        WHERE user_id = $1 AND status = $2
+        ORDER BY confidence DESC, name ASC
+    `
+	rows, err := r.s.Pool().Query(ctx, query, userID.String(), status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []*SubscriptionCandidate{}
+	for rows.Next() {
+		c, err := scanCandidate(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+func (r *Repository) GetCandidate(ctx context.Context, userID, candidateID uuid.UUID) (*SubscriptionCandidate, error) {
+	query := `SELECT ` + candidateColumns + `
+        FROM subscription_candidates
+        WHERE user_id = $1 AND id = $2
+    `
+	c, err := scanCandidate(r.s.Pool().QueryRow(ctx, query, userID.String(), candidateID.String()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCandidateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ResolveCandidate moves a pending candidate to status. It fails with
+// ErrCandidateAlreadyHandled when the candidate is no longer pending.
+func (r *Repository) ResolveCandidate(ctx context.Context, userID, candidateID uuid.UUID, status CandidateStatus, subscriptionID *string) error {
+	query := `
+        UPDATE subscription_candidates
+        SET status = $3, subscription_id = $4, updated_at = $5
+        WHERE user_id = $1 AND id = $2 AND status = 'pending'
+    `
+	tag, err := r.s.Pool().Exec(ctx, query, userID.String(), candidateID.String(), status, subscriptionID, time.Now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrCandidateAlreadyHandled
+	}
+	return nil
+}
+
+// AttachCandidateSubscription records the subscription created from an
+// accepted candidate.
+func (r *Repository) AttachCandidateSubscription(ctx context.Context, userID, candidateID uuid.UUID, subscriptionID string) error {
+	query := `
+        UPDATE subscription_candidates
+        SET subscription_id = $3, updated_at = $4
+        WHERE user_id = $1 AND id = $2 AND status = 'accepted'
+    `
+	_, err := r.s.Pool().Exec(ctx, query, userID.String(), candidateID.String(), subscriptionID, time.Now())
+	return err
+}
+
+// ReleaseCandidate puts back to pending a candidate claimed for acceptance
+// whose subscription could not be created.
+func (r *Repository) ReleaseCandidate(ctx context.Context, userID, candidateID uuid.UUID) error {
+	query := `
+        UPDATE subscription_candidates
+        SET status = 'pending', updated_at = $3
+        WHERE user_id = $1 AND id = $2 AND status = 'accepted' AND subscription_id IS NULL
+    `
+	_, err := r.s.Pool().Exec(ctx, query, userID.String(), candidateID.String(), time.Now())
+	return err
+}
+
+func (r *Repository) GetUserIDByPowensID(ctx context.Context, powensID int) (uuid.UUID, error) {
+	query := `SELECT user_id FROM powens_accounts WHERE powens_id = $1`
+	var userID uuid.UUID
+	err := r.s.Pool().QueryRow(ctx, query, powensID).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrNoPowensAccount
+	}
+	return userID, err
+}
+
+func (r *Repository) DeletePowensAccountByPowensID(ctx context.Context, powensID int) error {
+	_, err := r.s.Pool().Exec(ctx, `DELETE FROM powens_accounts WHERE powens_id = $1`, powensID)
+	return err
+}
+
+// InsertWebhookEvent adds an event to the inbox. It returns false when an
+// event with the same id is already there.
+func (r *Repository) InsertWebhookEvent(ctx context.Context, event *WebhookEvent) (bool, error) {
+	query := `
+        INSERT INTO powens_webhook_events (id, event_type, payload, status, received_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $5)
+        ON CONFLICT (id) DO NOTHING
+    `
+	tag, err := r.s.Pool().Exec(ctx, query, event.ID, event.Type, event.Payload, WebhookPending, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// webhookRetryDelay is how long a failed event, or one left processing by a
+// crashed run, waits before being picked up again.
+const webhookRetryDelay = 5 * time.Minute
+
+// ClaimWebhookEvents marks up to limit events as processing and returns them,
+// oldest first. Rows locked by a concurrent claim are skipped.
+func (r *Repository) ClaimWebhookEvents(ctx context.Context, limit, maxAttempts int) ([]*WebhookEvent, error) {
+	query := `
+        UPDATE powens_webhook_events
+        SET status = 'processing', attempts = attempts + 1, updated_at = $3
+        WHERE id IN (
+            SELECT id FROM powens_webhook_events
+            WHERE attempts < $2
+              AND (status = 'pending' OR (status IN ('processing', 'failed') AND updated_at < $4))
+            ORDER BY received_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, event_type, payload, status, attempts, last_error, received_at
+    `
+	now := time.Now()
+	rows, err := r.s.Pool().Query(ctx, query, limit, maxAttempts, now, now.Add(-webhookRetryDelay))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*WebhookEvent
+	for rows.Next() {
+		event := new(WebhookEvent)
+		if err := rows.Scan(&event.ID, &event.Type, &event.Payload, &event.Status, &event.Attempts, &event.LastError, &event.ReceivedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r *Repository) CompleteWebhookEvent(ctx context.Context, id string) error {
+	query := `
+        UPDATE powens_webhook_events
+        SET status = 'processed', last_error = NULL, processed_at = $2, updated_at = $2
+        WHERE id = $1
+    `
+	_, err := r.s.Pool().Exec(ctx, query, id, time.Now())
+	return err
+}
+
+func (r *Repository) FailWebhookEvent(ctx context.Context, id, reason string) error {
+	query := `
+        UPDATE powens_webhook_events
+        SET status = 'failed', last_error = $2, updated_at = $3
+        WHERE id = $1
+    `
+	_, err := r.s.Pool().Exec(ctx, query, id, reason, time.Now())
 	return err
 }
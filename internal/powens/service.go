@@ -2,56 +2,186 @@ package powens
 
 import (
 	"context"
-	"errors"
-	"net/url"
+	"figenn/internal/aggregator"
+	"figenn/internal/subscriptions"
+	"log"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/labstack/echo/v4"
 )
 
 type Config struct {
-	ClientID    string
-	Domain      string
 	CallbackURI string
+	// WebhookSecret signs the webhooks sent by Powens.
+	WebhookSecret string
 }
 
+// SyncHook runs when Powens reports new data for a user.
+type SyncHook func(ctx context.Context, userID uuid.UUID) error
+
 type Service struct {
-	repo   *Repository
-	client *Client
-	config *Config
+	repo          *Repository
+	client        aggregator.BankAggregator
+	config        *Config
+	subscriptions *subscriptions.Service
+	syncHooks     []SyncHook
+}
+
+func NewService(repo *Repository, client aggregator.BankAggregator, config *Config, subscriptionService *subscriptions.Service) *Service {
+	return &Service{repo: repo, client: client, config: config, subscriptions: subscriptionService}
 }
 
-func NewService(repo *Repository, client *Client, config *Config) *Service {
-	return &Service{repo: repo, client: client, config: config}
+// AddSyncHook registers a hook run on CONNECTION_SYNCED and ACCOUNT_SYNCED
+// events, before subscriptions are detected.
+func (s *Service) AddSyncHook(hook SyncHook) {
+	s.syncHooks = append(s.syncHooks, hook)
 }
 
-func (s *Service) CreateAccount(ctx echo.Context, userID uuid.UUID) (*string, error) {
-	authToken, powensID, err := s.client.CreatePowensAccount(ctx, userID)
+// CreateAccount creates the aggregator user of userID and returns the URL of
+// the webview where they link their bank.
+func (s *Service) CreateAccount(ctx context.Context, userID uuid.UUID) (*string, error) {
+	user, err := s.client.InitUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.SetPowensAccount(ctx, userID, int(user.ID), user.AccessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.repo.SetPowensAccount(context.Background(), userID, powensID, authToken)
+	temporaryToken, err := s.client.CreateTemporaryToken(ctx, user.AccessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	accessToken, err := s.client.CreateTemporaryToken(ctx, authToken)
+	connectURL, err := s.client.ConnectURL(temporaryToken, s.config.CallbackURI)
 	if err != nil {
 		return nil, err
 	}
+	return &connectURL, nil
+}
+
+// DetectSubscriptions analyses the bank transactions of the user and stores
+// the recurring debits found as pending candidates. Merchants already tracked
+// as a subscription with the same name are skipped.
+func (s *Service) DetectSubscriptions(ctx context.Context, userID uuid.UUID) ([]*SubscriptionCandidate, error) {
+	account, err := s.repo.GetPowensAccount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	transactions, currencies, err := s.listTransactions(ctx, account.AccessToken, now.AddDate(0, 0, -DetectionWindowDays))
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.subscriptions.GetUserSubscriptions(ctx, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool, len(existing))
+	for _, sub := range existing {
+		tracked[strings.ToLower(sub.Name)] = true
+	}
+
+	var candidates []*SubscriptionCandidate
+	for _, candidate := range DetectRecurring(transactions, currencies, now) {
+		if !tracked[strings.ToLower(candidate.Name)] {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if err := s.repo.UpsertCandidates(ctx, userID, candidates); err != nil {
+		return nil, err
+	}
+	return s.repo.ListCandidates(ctx, userID, CandidatePending)
+}
 
-	if s.config.ClientID == "" || s.config.Domain == "" || s.config.CallbackURI == "" {
-		return nil, errors.New("missing required config values")
+// listTransactions returns the transactions dated from minDate of every
+// active account, along with the currency of each account.
+func (s *Service) listTransactions(ctx context.Context, accessToken string, minDate time.Time) ([]*aggregator.Transaction, map[int64]string, error) {
+	connections, err := s.client.ListConnections(ctx, accessToken)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	urlValues := url.Values{}
-	urlValues.Set("domain", s.config.Domain)
-	urlValues.Set("client_id", s.config.ClientID)
-	urlValues.Set("redirect_uri", s.config.CallbackURI)
-	urlValues.Set("code", accessToken)
+	var transactions []*aggregator.Transaction
+	currencies := make(map[int64]string)
+	for _, conn := range connections {
+		accounts, err := s.client.ListAccounts(ctx, accessToken, conn.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, account := range accounts {
+			if account.Disabled || account.Deleted {
+				continue
+			}
+			currencies[account.ID] = account.Currency
+			txs, err := s.client.ListTransactions(ctx, accessToken, account.ID, aggregator.TransactionsQuery{MinDate: &minDate})
+			if err != nil {
+				return nil, nil, err
+			}
+			transactions = append(transactions, txs...)
+		}
+	}
+	return transactions, currencies, nil
+}
 
-	constructedURL := "https://webview.powens.com/connect?" + urlValues.Encode()
+func (s *Service) ListCandidates(ctx context.Context, userID uuid.UUID) ([]*SubscriptionCandidate, error) {
+	return s.repo.ListCandidates(ctx, userID, CandidatePending)
+}
+
+// AcceptCandidate creates a subscription from a pending candidate, with the
+// adjustments of req, and marks the candidate as accepted.
+func (s *Service) AcceptCandidate(ctx context.Context, userID, candidateID uuid.UUID, req AcceptCandidateRequest) (*subscriptions.Subscription, error) {
+	candidate, err := s.repo.GetCandidate(ctx, userID, candidateID)
+	if err != nil {
+		return nil, err
+	}
+	if candidate.Status != CandidatePending {
+		return nil, ErrCandidateAlreadyHandled
+	}
+
+	subReq := subscriptions.CreateSubscriptionRequest{
+		Name:         candidate.Name,
+		Category:     req.Category,
+		Color:        req.Color,
+		Description:  candidate.Label,
+		StartDate:    &candidate.FirstChargeDate,
+		Price:        candidate.Amount,
+		Currency:     candidate.Currency,
+		BillingCycle: candidate.BillingCycle,
+	}
+	if req.Name != nil {
+		subReq.Name = *req.Name
+	}
+	if req.Price != nil {
+		subReq.Price = *req.Price
+	}
+	if err := subReq.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Claim the candidate before creating the subscription: of two concurrent
+	// accepts, only one gets past this update.
+	if err := s.repo.ResolveCandidate(ctx, userID, candidateID, CandidateAccepted, nil); err != nil {
+		return nil, err
+	}
+	sub, err := s.subscriptions.CreateSubscription(ctx, userID.String(), subReq)
+	if err != nil {
+		if releaseErr := s.repo.ReleaseCandidate(ctx, userID, candidateID); releaseErr != nil {
+			log.Printf("powens: failed to release candidate %s: %v", candidateID, releaseErr)
+		}
+		return nil, err
+	}
+	if err := s.repo.AttachCandidateSubscription(ctx, userID, candidateID, sub.Id); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
 
-	return &constructedURL, nil
+func (s *Service) DismissCandidate(ctx context.Context, userID, candidateID uuid.UUID) error {
+	return s.repo.ResolveCandidate(ctx, userID, candidateID, CandidateDismissed, nil)
 }
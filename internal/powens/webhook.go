@@ -0,0 +1,176 @@
+package powens
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxWebhookAttempts is how many times an event is processed before it
+	// is left failed for inspection.
+	maxWebhookAttempts = 5
+	webhookBatchSize   = 20
+	// webhookMaxSkew bounds the age of BI-Signature-Date, so that a captured
+	// delivery can't be replayed later.
+	webhookMaxSkew = 5 * time.Minute
+)
+
+// VerifyWebhookSignature checks the BI-Signature header of a webhook call.
+// Powens signs "METHOD.PATH.DATE.BODY" with HMAC-SHA256 using the webhook
+// secret, DATE being the BI-Signature-Date header, and encodes it in base64.
+// Dates further than webhookMaxSkew from now are rejected.
+func VerifyWebhookSignature(secret, method, path, date string, body []byte, signature string, now time.Time) bool {
+	if secret == "" || signature == "" || date == "" {
+		return false
+	}
+	signedAt, err := http.ParseTime(date)
+	if err != nil || now.Sub(signedAt).Abs() > webhookMaxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "." + path + "." + date + "."))
+	mac.Write(body)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (s *Service) VerifyWebhook(method, path, date string, body []byte, signature string) bool {
+	return VerifyWebhookSignature(s.config.WebhookSecret, method, path, date, body, signature, time.Now())
+}
+
+// webhookEventID identifies an event by its type and content, so Powens
+// retrying a delivery doesn't create a second event.
+func webhookEventID(eventType WebhookEventType, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(eventType + "."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// webhookEventType tells the kind of event from its payload, all events
+// sharing the same URL. The type is taken from the signed body, so a
+// delivery can't be replayed as another event.
+func webhookEventType(body []byte) (WebhookEventType, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return "", ErrInvalidWebhookPayload
+	}
+	if _, ok := fields["connection"]; ok {
+		return EventConnectionSynced, nil
+	}
+	if _, ok := fields["id_user"]; ok {
+		return EventAccountSynced, nil
+	}
+	if _, ok := fields["id"]; ok {
+		return EventUserDeleted, nil
+	}
+	return "", ErrUnknownWebhookEvent
+}
+
+// ReceiveWebhook stores an event in the inbox. It returns false when the
+// event was already received. The scheduled powens-webhooks job processes
+// it.
+func (s *Service) ReceiveWebhook(ctx context.Context, body []byte) (bool, error) {
+	eventType, err := webhookEventType(body)
+	if err != nil {
+		return false, err
+	}
+	return s.repo.InsertWebhookEvent(ctx, &WebhookEvent{
+		ID:      webhookEventID(eventType, body),
+		Type:    eventType,
+		Payload: body,
+	})
+}
+
+// ProcessWebhooks handles the events waiting in the inbox. Events are
+// claimed so that concurrent runs, on this replica or another, never process
+// the same event. Failed events are retried on the next run.
+func (s *Service) ProcessWebhooks(ctx context.Context) error {
+	for {
+		events, err := s.repo.ClaimWebhookEvents(ctx, webhookBatchSize, maxWebhookAttempts)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, event := range events {
+			if err := s.processWebhook(ctx, event); err != nil {
+				log.Printf("powens: failed to process %s event %s: %v", event.Type, event.ID, err)
+				if err := s.repo.FailWebhookEvent(ctx, event.ID, err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.repo.CompleteWebhookEvent(ctx, event.ID); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Service) processWebhook(ctx context.Context, event *WebhookEvent) error {
+	switch event.Type {
+	case EventConnectionSynced:
+		var payload ConnectionSyncedPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		powensID := payload.User.ID
+		if powensID == 0 {
+			powensID = payload.Connection.IDUser
+		}
+		return s.syncUser(ctx, powensID)
+	case EventAccountSynced:
+		var payload AccountSyncedPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		return s.syncUser(ctx, payload.IDUser)
+	case EventUserDeleted:
+		var payload UserDeletedPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		if payload.ID == 0 {
+			return ErrInvalidWebhookPayload
+		}
+		return s.repo.DeletePowensAccountByPowensID(ctx, payload.ID)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownWebhookEvent, event.Type)
+	}
+}
+
+// syncUser refreshes the subscription candidates of the user behind a Powens
+// user id. Users unknown to Figenn are ignored.
+func (s *Service) syncUser(ctx context.Context, powensID int) error {
+	if powensID == 0 {
+		return ErrInvalidWebhookPayload
+	}
+	userID, err := s.repo.GetUserIDByPowensID(ctx, powensID)
+	if err == ErrNoPowensAccount {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	for _, hook := range s.syncHooks {
+		if err := hook(ctx, userID); err != nil {
+			return err
+		}
+	}
+	_, err = s.DetectSubscriptions(ctx, userID)
+	return err
+}
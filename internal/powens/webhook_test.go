@@ -0,0 +1,77 @@
+package powens
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"figenn/internal/jwtkeys"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":42}`)
+	date := "Mon, 05 May 2025 10:00:00 GMT"
+	now := time.Date(2025, time.May, 5, 10, 2, 0, 0, time.UTC)
+	path := "/api/powens/webhook"
+	signature := sign("secret", "POST."+path+"."+date+"."+string(body))
+
+	assert.True(t, VerifyWebhookSignature("secret", "POST", path, date, body, signature, now))
+	assert.False(t, VerifyWebhookSignature("other", "POST", path, date, body, signature, now))
+	assert.False(t, VerifyWebhookSignature("secret", "POST", path, date, []byte(`{"id":43}`), signature, now))
+	assert.False(t, VerifyWebhookSignature("secret", "POST", "/api/powens/other", date, body, signature, now))
+	assert.False(t, VerifyWebhookSignature("secret", "POST", path, date, body, signature, now.Add(time.Hour)))
+	assert.False(t, VerifyWebhookSignature("", "POST", path, date, body, sign("", "POST."+path+"."+date+"."+string(body)), now))
+}
+
+func TestWebhookEventID(t *testing.T) {
+	body := []byte(`{"id":42}`)
+	assert.Equal(t, webhookEventID(EventUserDeleted, body), webhookEventID(EventUserDeleted, body))
+	assert.NotEqual(t, webhookEventID(EventUserDeleted, body), webhookEventID(EventConnectionSynced, body))
+}
+
+func TestWebhookEventType(t *testing.T) {
+	tests := map[string]WebhookEventType{
+		`{"user":{"id":7},"connection":{"id":3,"id_user":7}}`: EventConnectionSynced,
+		`{"id":12,"id_user":7,"id_connection":3}`:             EventAccountSynced,
+		`{"id":7}`: EventUserDeleted,
+	}
+	for body, want := range tests {
+		eventType, err := webhookEventType([]byte(body))
+		assert.NoError(t, err, body)
+		assert.Equal(t, want, eventType, body)
+	}
+
+	_, err := webhookEventType([]byte(`{"name":"x"}`))
+	assert.ErrorIs(t, err, ErrUnknownWebhookEvent)
+	_, err = webhookEventType([]byte(`null`))
+	assert.ErrorIs(t, err, ErrInvalidWebhookPayload)
+	_, err = webhookEventType([]byte(`[1]`))
+	assert.ErrorIs(t, err, ErrInvalidWebhookPayload)
+}
+
+func TestReceiveWebhookRejectsBadSignature(t *testing.T) {
+	e := echo.New()
+	NewAPI(jwtkeys.NewHMACKeySet("jwt-secret"), NewService(nil, nil, &Config{WebhookSecret: "secret"}, nil), nil).Bind(e.Group("/api"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/powens/webhook", strings.NewReader(`{"id":42}`))
+	req.Header.Set("BI-Signature-Date", "Mon, 05 May 2025 10:00:00 GMT")
+	req.Header.Set("BI-Signature", "invalid")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Invalid signature")
+}
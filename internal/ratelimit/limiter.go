@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxPeekedBody bounds the request body read to find the email address.
+const maxPeekedBody = 64 << 10
+
+// Limiter applies a Config to requests, through its middlewares.
+type Limiter struct {
+	store  Store
+	config Config
+	now    func() time.Time
+}
+
+func New(store Store, config Config) *Limiter {
+	return &Limiter{store: store, config: config, now: time.Now}
+}
+
+// Middleware throttles requests by client IP and, when the JSON or form body
+// has an email field, by email address. Buckets are separate for every scope.
+func (l *Limiter) Middleware(scope string) echo.MiddlewareFunc {
+	return l.middleware(scope, emailSubject, 0)
+}
+
+// LoginMiddleware is Middleware for login endpoints: it also counts the 401
+// responses as failed logins of the email, delaying and eventually locking
+// out the next attempts. A successful login clears the failures.
+func (l *Limiter) LoginMiddleware(scope string) echo.MiddlewareFunc {
+	return l.middleware(scope, emailSubject, http.StatusUnauthorized)
+}
+
+// UserMiddleware throttles the endpoints checking the password of the
+// signed-in user, by client IP and by user. The responses with failureStatus
+// count as failed attempts of the user, like LoginMiddleware does for emails.
+// It runs after the authentication middleware, which sets the user_id.
+func (l *Limiter) UserMiddleware(scope string, failureStatus int) echo.MiddlewareFunc {
+	return l.middleware(scope, userSubject, failureStatus)
+}
+
+// Unlock clears the failed logins of an email, lifting its lockout.
+func (l *Limiter) Unlock(ctx context.Context, email string) error {
+	return l.store.ResetFailures(ctx, failuresKey(normalizeEmail(email)))
+}
+
+// Prune forgets the state untouched for longer than the failure window.
+func (l *Limiter) Prune(ctx context.Context) error {
+	return l.store.Prune(ctx, l.now().Add(-l.config.FailureWindow))
+}
+
+// subject is who a request is throttled for besides its client IP: an email
+// address or a user.
+type subject struct {
+	kind string
+	id   string
+}
+
+// key names the bucket and failures of s.
+func (s subject) key() string {
+	return s.kind + ":" + s.id
+}
+
+func emailSubject(c echo.Context) subject {
+	return subject{kind: "email", id: peekEmail(c.Request())}
+}
+
+func userSubject(c echo.Context) subject {
+	userID, _ := c.Get("user_id").(string)
+	return subject{kind: "user", id: userID}
+}
+
+// middleware throttles by client IP, then by the subject of the request when
+// there is one. A non-zero failureStatus tracks the responses with that
+// status as failed attempts of the subject.
+func (l *Limiter) middleware(scope string, subjectOf func(echo.Context) subject, failureStatus int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			now := l.now()
+
+			wait, err := l.take(ctx, scope+":ip:"+c.RealIP(), l.config.IP, now)
+			if err != nil {
+				return err
+			}
+			if wait > 0 {
+				return tooManyRequests(c, wait, "Too many requests, try again later")
+			}
+
+			sub := subjectOf(c)
+			if sub.id == "" {
+				return next(c)
+			}
+
+			if failureStatus != 0 {
+				failures, err := l.store.GetFailures(ctx, sub.key())
+				if err != nil {
+					return err
+				}
+				if failures.LockedUntil != nil && now.Before(*failures.LockedUntil) {
+					message := "Too many failed attempts, try again later"
+					if sub.kind == "email" {
+						message = "Account temporarily locked after too many failed attempts, check your emails to unlock it"
+					}
+					return tooManyRequests(c, failures.LockedUntil.Sub(now), message)
+				}
+				if retry := failures.LastFailureAt.Add(l.config.delay(failures)).Sub(now); retry > 0 && failures.LockedUntil == nil {
+					return tooManyRequests(c, retry, "Too many failed attempts, try again later")
+				}
+			}
+
+			wait, err = l.take(ctx, scope+":"+sub.key(), l.config.Email, now)
+			if err != nil {
+				return err
+			}
+			if wait > 0 {
+				return tooManyRequests(c, wait, "Too many requests, try again later")
+			}
+
+			err = next(c)
+			if failureStatus != 0 {
+				l.recordOutcome(ctx, sub, responseStatus(c, err), failureStatus)
+			}
+			return err
+		}
+	}
+}
+
+func (l *Limiter) take(ctx context.Context, key string, rule Rule, now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	err := l.store.UpdateBucket(ctx, key, func(b *Bucket) {
+		_, wait = rule.take(b, now)
+	})
+	return wait, err
+}
+
+// recordOutcome counts a failed attempt or clears the failures of a
+// successful one. Errors are logged: the response is already written.
+func (l *Limiter) recordOutcome(ctx context.Context, sub subject, status, failureStatus int) {
+	switch {
+	case status == failureStatus:
+		now := l.now()
+		var locked bool
+		var until time.Time
+		err := l.store.UpdateFailures(ctx, sub.key(), func(f *Failures) {
+			if locked = l.config.fail(f, now); locked {
+				until = *f.LockedUntil
+			}
+		})
+		if err != nil {
+			log.Printf("ratelimit: recording failure: %v", err)
+			return
+		}
+		if locked && sub.kind == "email" && l.config.OnLockout != nil {
+			l.config.OnLockout(ctx, sub.id, until)
+		}
+	case status >= 200 && status < 300:
+		if err := l.store.ResetFailures(ctx, sub.key()); err != nil {
+			log.Printf("ratelimit: resetting failures: %v", err)
+		}
+	}
+}
+
+func failuresKey(email string) string {
+	return "email:" + email
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// peekEmail returns the email field of a JSON or form body, leaving the body
+// readable by the handler. Forms are parsed in place, echo reuses the parsed
+// values when binding.
+func peekEmail(req *http.Request) string {
+	if req.Body == nil {
+		return ""
+	}
+	contentType := req.Header.Get(echo.HeaderContentType)
+	switch {
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxPeekedBody))
+		if err != nil {
+			return ""
+		}
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+
+		var payload struct {
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return ""
+		}
+		return normalizeEmail(payload.Email)
+	case strings.HasPrefix(contentType, echo.MIMEApplicationForm), strings.HasPrefix(contentType, echo.MIMEMultipartForm):
+		req.Body = http.MaxBytesReader(nil, req.Body, maxPeekedBody)
+		return normalizeEmail(req.PostFormValue("email"))
+	default:
+		return ""
+	}
+}
+
+func responseStatus(c echo.Context, err error) int {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code
+	}
+	return c.Response().Status
+}
+
+func tooManyRequests(c echo.Context, wait time.Duration, message string) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	return c.JSON(http.StatusTooManyRequests, echo.Map{"error": message, "retry_after": seconds})
+}
@@ -0,0 +1,221 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleTake(t *testing.T) {
+	rule := Rule{Burst: 2, Every: 10 * time.Second}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	var b Bucket
+
+	ok, _ := rule.take(&b, now)
+	assert.True(t, ok)
+	ok, _ = rule.take(&b, now)
+	assert.True(t, ok)
+	ok, wait := rule.take(&b, now)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	ok, wait = rule.take(&b, now.Add(4*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 6*time.Second, wait)
+	ok, _ = rule.take(&b, now.Add(10*time.Second))
+	assert.True(t, ok)
+
+	// The bucket never holds more than its burst.
+	ok, _ = rule.take(&b, now.Add(time.Hour))
+	assert.True(t, ok)
+	ok, _ = rule.take(&b, now.Add(time.Hour))
+	assert.True(t, ok)
+	ok, _ = rule.take(&b, now.Add(time.Hour))
+	assert.False(t, ok)
+}
+
+func TestConfigDelay(t *testing.T) {
+	config := DefaultConfig()
+	delays := map[int]time.Duration{0: 0, 3: 0, 4: 2 * time.Second, 5: 4 * time.Second, 8: 32 * time.Second, 9: time.Minute, 40: time.Minute}
+	for count, want := range delays {
+		assert.Equal(t, want, config.delay(Failures{Count: count}), count)
+	}
+}
+
+func TestConfigFail(t *testing.T) {
+	config := Config{MaxFailures: 3, FailureWindow: time.Hour, Lockout: 30 * time.Minute}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	var f Failures
+
+	assert.False(t, config.fail(&f, now))
+	assert.False(t, config.fail(&f, now.Add(2*time.Hour)))
+	assert.Equal(t, 1, f.Count, "failures outside the window are forgotten")
+
+	now = now.Add(2 * time.Hour)
+	assert.False(t, config.fail(&f, now))
+	assert.True(t, config.fail(&f, now))
+	assert.Equal(t, now.Add(30*time.Minute), *f.LockedUntil)
+	assert.False(t, config.fail(&f, now), "an email is only locked once")
+
+	assert.False(t, config.fail(&f, now.Add(31*time.Minute)))
+	assert.Nil(t, f.LockedUntil)
+	assert.Equal(t, 1, f.Count)
+}
+
+func TestLoginMiddleware(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	var lockedOut []string
+	config := Config{
+		IP:            Rule{Burst: 100, Every: time.Second},
+		Email:         Rule{Burst: 100, Every: time.Second},
+		FreeFailures:  1,
+		BaseDelay:     time.Second,
+		MaxDelay:      time.Minute,
+		MaxFailures:   3,
+		FailureWindow: time.Hour,
+		Lockout:       time.Hour,
+		OnLockout: func(ctx context.Context, email string, until time.Time) {
+			lockedOut = append(lockedOut, email)
+		},
+	}
+	limiter := New(NewMemoryStore(time.Hour), config)
+	limiter.now = func() time.Time { return now }
+
+	e := echo.New()
+	e.POST("/login", func(c echo.Context) error {
+		var req struct {
+			Email    string `json:"email" form:"email"`
+			Password string `json:"password" form:"password"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		if req.Password != "right" {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		return c.NoContent(http.StatusOK)
+	}, limiter.LoginMiddleware("login"))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login",
+			strings.NewReader(`{"email":" Jane@Example.com","password":"`+password+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+
+	rec := login("right")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "the second failure delays the next attempt")
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+	assert.Equal(t, []string{"jane@example.com"}, lockedOut)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusTooManyRequests, login("right").Code, "locked out")
+
+	assert.NoError(t, limiter.Unlock(context.Background(), "jane@example.com"))
+	assert.Equal(t, http.StatusOK, login("right").Code)
+
+	loginForm := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login",
+			strings.NewReader(url.Values{"email": {"jane@example.com"}, "password": {password}}.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, loginForm("wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, loginForm("wrong").Code)
+	assert.Equal(t, http.StatusTooManyRequests, login("right").Code, "form logins count as failures too")
+}
+
+func TestMiddlewareThrottlesByIP(t *testing.T) {
+	config := DefaultConfig()
+	config.IP = Rule{Burst: 2, Every: time.Minute}
+	limiter := New(NewMemoryStore(time.Hour), config)
+
+	e := echo.New()
+	e.POST("/forgot-password", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, limiter.Middleware("forgot-password"))
+
+	codes := make([]int, 3)
+	for i := range codes {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/forgot-password", nil))
+		codes[i] = rec.Code
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestUserMiddleware(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	var lockedOut []string
+	config := Config{
+		IP:            Rule{Burst: 100, Every: time.Second},
+		Email:         Rule{Burst: 100, Every: time.Second},
+		FreeFailures:  1,
+		BaseDelay:     time.Second,
+		MaxDelay:      time.Minute,
+		MaxFailures:   3,
+		FailureWindow: time.Hour,
+		Lockout:       time.Hour,
+		OnLockout: func(ctx context.Context, email string, until time.Time) {
+			lockedOut = append(lockedOut, email)
+		},
+	}
+	limiter := New(NewMemoryStore(time.Hour), config)
+	limiter.now = func() time.Time { return now }
+
+	e := echo.New()
+	authenticate := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user_id", c.Request().Header.Get("X-User"))
+			return next(c)
+		}
+	}
+	e.POST("/user/password", func(c echo.Context) error {
+		if c.FormValue("current_password") != "right" {
+			return c.NoContent(http.StatusForbidden)
+		}
+		return c.NoContent(http.StatusOK)
+	}, authenticate, limiter.UserMiddleware("change-password", http.StatusForbidden))
+
+	change := func(user, password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/user/password",
+			strings.NewReader(url.Values{"current_password": {password}}.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, change("jane", "wrong"))
+	assert.Equal(t, http.StatusForbidden, change("jane", "wrong"))
+	assert.Equal(t, http.StatusTooManyRequests, change("jane", "right"), "the second failure delays the next attempt")
+	assert.Equal(t, http.StatusOK, change("john", "right"), "other users are not affected")
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusForbidden, change("jane", "wrong"))
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusTooManyRequests, change("jane", "right"), "locked out")
+	assert.Empty(t, lockedOut, "only emails get an unlock link")
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusOK, change("jane", "right"))
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory store forgets idle keys.
+const sweepInterval = 10 * time.Minute
+
+// MemoryStore keeps the limiter state in memory. It's only suited to a
+// single replica.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	failures  map[string]*Failures
+	lastSweep time.Time
+	idle      time.Duration
+}
+
+// NewMemoryStore returns a store forgetting the keys idle for longer than
+// idle, which must exceed the failure window of the limiter.
+func NewMemoryStore(idle time.Duration) *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*Bucket),
+		failures:  make(map[string]*Failures),
+		lastSweep: time.Now(),
+		idle:      idle,
+	}
+}
+
+func (s *MemoryStore) UpdateBucket(ctx context.Context, key string, fn func(b *Bucket)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &Bucket{}
+		s.buckets[key] = b
+	}
+	fn(b)
+	return nil
+}
+
+func (s *MemoryStore) UpdateFailures(ctx context.Context, key string, fn func(f *Failures)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	f, ok := s.failures[key]
+	if !ok {
+		f = &Failures{}
+		s.failures[key] = f
+	}
+	fn(f)
+	return nil
+}
+
+func (s *MemoryStore) GetFailures(ctx context.Context, key string) (Failures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.failures[key]; ok {
+		return *f, nil
+	}
+	return Failures{}, nil
+}
+
+func (s *MemoryStore) ResetFailures(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+	return nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(before)
+	return nil
+}
+
+// sweep prunes idle keys every sweepInterval. The lock must be held.
+func (s *MemoryStore) sweep() {
+	now := time.Now()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	s.prune(now.Add(-s.idle))
+}
+
+func (s *MemoryStore) prune(before time.Time) {
+	for key, b := range s.buckets {
+		if b.UpdatedAt.Before(before) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, f := range s.failures {
+		if f.LastFailureAt.Before(before) && (f.LockedUntil == nil || f.LockedUntil.Before(before)) {
+			delete(s.failures, key)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore shares the limiter state between replicas. Keys are locked
+// with SELECT ... FOR UPDATE while they are updated.
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) UpdateBucket(ctx context.Context, key string, fn func(b *Bucket)) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `INSERT INTO rate_limit_buckets (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`, key); err != nil {
+		return err
+	}
+	var b Bucket
+	var updatedAt *time.Time
+	err = tx.QueryRow(ctx, `SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE`, key).
+		Scan(&b.Tokens, &updatedAt)
+	if err != nil {
+		return err
+	}
+	if updatedAt != nil {
+		b.UpdatedAt = updatedAt.UTC()
+	}
+
+	fn(&b)
+	_, err = tx.Exec(ctx, `UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1`,
+		key, b.Tokens, b.UpdatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) UpdateFailures(ctx context.Context, key string, fn func(f *Failures)) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `INSERT INTO rate_limit_failures (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`, key); err != nil {
+		return err
+	}
+	f, err := scanFailures(tx.QueryRow(ctx,
+		`SELECT count, last_failure_at, locked_until FROM rate_limit_failures WHERE key = $1 FOR UPDATE`, key))
+	if err != nil {
+		return err
+	}
+
+	fn(&f)
+	var lockedUntil *time.Time
+	if f.LockedUntil != nil {
+		until := f.LockedUntil.UTC()
+		lockedUntil = &until
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE rate_limit_failures SET count = $2, last_failure_at = $3, locked_until = $4 WHERE key = $1
+	`, key, f.Count, f.LastFailureAt.UTC(), lockedUntil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) GetFailures(ctx context.Context, key string) (Failures, error) {
+	f, err := scanFailures(s.pool.QueryRow(ctx,
+		`SELECT count, last_failure_at, locked_until FROM rate_limit_failures WHERE key = $1`, key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Failures{}, nil
+	}
+	return f, err
+}
+
+func (s *PostgresStore) ResetFailures(ctx context.Context, key string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM rate_limit_failures WHERE key = $1`, key)
+	return err
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	before = before.UTC()
+	if _, err := s.pool.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at IS NULL OR updated_at < $1`, before); err != nil {
+		return err
+	}
+	_, err := s.pool.Exec(ctx, `
+		DELETE FROM rate_limit_failures
+		WHERE (last_failure_at IS NULL OR last_failure_at < $1) AND (locked_until IS NULL OR locked_until < $1)
+	`, before)
+	return err
+}
+
+// scanFailures reads timestamps as UTC, the zone they are written in.
+func scanFailures(row pgx.Row) (Failures, error) {
+	var f Failures
+	var lastFailureAt *time.Time
+	if err := row.Scan(&f.Count, &lastFailureAt, &f.LockedUntil); err != nil {
+		return Failures{}, err
+	}
+	if lastFailureAt != nil {
+		f.LastFailureAt = lastFailureAt.UTC()
+	}
+	if f.LockedUntil != nil {
+		until := f.LockedUntil.UTC()
+		f.LockedUntil = &until
+	}
+	return f, nil
+}
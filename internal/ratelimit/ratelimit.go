@@ -0,0 +1,144 @@
+// Package ratelimit throttles sensitive endpoints, such as the login, by
+// client IP and by email address or signed-in user.
+//
+// Every key has a token bucket refilled at a steady rate. On top of that,
+// failed logins on an email address delay the next attempt, increasingly,
+// and too many of them lock the address out for a while. Wrong passwords of
+// a signed-in user are handled the same way.
+//
+// State lives in a Store: in memory for a single replica, or in Postgres
+// when the API runs on several.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Rule is a token bucket: Burst requests at once, then one every Every.
+type Rule struct {
+	Burst int
+	Every time.Duration
+}
+
+// Bucket is the state of a token bucket. A zero bucket is full.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// take refills b up to now and takes a token from it. When the bucket is
+// empty, it returns how long until a token is available.
+func (r Rule) take(b *Bucket, now time.Time) (bool, time.Duration) {
+	if b.UpdatedAt.IsZero() {
+		b.Tokens = float64(r.Burst)
+	} else if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 && r.Every > 0 {
+		b.Tokens += float64(elapsed) / float64(r.Every)
+	}
+	if b.Tokens > float64(r.Burst) {
+		b.Tokens = float64(r.Burst)
+	}
+	b.UpdatedAt = now
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.Tokens) * float64(r.Every))
+}
+
+// Failures tracks the failed logins of an email address.
+type Failures struct {
+	Count         int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Store keeps the buckets and failures. Updates of a key must be atomic,
+// across replicas for shared stores.
+type Store interface {
+	// UpdateBucket applies fn to the bucket of key.
+	UpdateBucket(ctx context.Context, key string, fn func(b *Bucket)) error
+	// UpdateFailures applies fn to the failures of key.
+	UpdateFailures(ctx context.Context, key string, fn func(f *Failures)) error
+	GetFailures(ctx context.Context, key string) (Failures, error)
+	ResetFailures(ctx context.Context, key string) error
+	// Prune forgets the buckets and failures untouched since before.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// Config sets the limits of a Limiter.
+type Config struct {
+	// IP and Email limit the requests per client IP and per email address,
+	// or per user for UserMiddleware.
+	IP    Rule
+	Email Rule
+
+	// Failed logins beyond FreeFailures delay the next attempt on the email
+	// by BaseDelay, doubled on each further failure up to MaxDelay.
+	FreeFailures int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+
+	// MaxFailures failed logins within FailureWindow lock the email out
+	// for Lockout.
+	MaxFailures   int
+	FailureWindow time.Duration
+	Lockout       time.Duration
+
+	// OnLockout is called when an email gets locked out, to let its owner
+	// know and unlock it.
+	OnLockout func(ctx context.Context, email string, until time.Time)
+}
+
+// DefaultConfig returns the limits applied to the authentication endpoints.
+func DefaultConfig() Config {
+	return Config{
+		IP:            Rule{Burst: 20, Every: 10 * time.Second},
+		Email:         Rule{Burst: 5, Every: time.Minute},
+		FreeFailures:  3,
+		BaseDelay:     2 * time.Second,
+		MaxDelay:      time.Minute,
+		MaxFailures:   10,
+		FailureWindow: time.Hour,
+		Lockout:       30 * time.Minute,
+	}
+}
+
+// delay returns how long after its last failure an email must wait before
+// its next login attempt.
+func (c Config) delay(f Failures) time.Duration {
+	extra := f.Count - c.FreeFailures
+	if extra <= 0 {
+		return 0
+	}
+	delay := c.BaseDelay
+	for i := 1; i < extra && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.MaxDelay {
+		return c.MaxDelay
+	}
+	return delay
+}
+
+// fail records a failed login on f at now and returns whether it locked the
+// email out. An expired lockout or window starts the count over.
+func (c Config) fail(f *Failures, now time.Time) bool {
+	if f.LockedUntil != nil && !now.Before(*f.LockedUntil) {
+		f.Count = 0
+		f.LockedUntil = nil
+	}
+	if now.Sub(f.LastFailureAt) > c.FailureWindow {
+		f.Count = 0
+	}
+	f.Count++
+	f.LastFailureAt = now
+
+	if f.Count >= c.MaxFailures && f.LockedUntil == nil {
+		until := now.Add(c.Lockout)
+		f.LockedUntil = &until
+		return true
+	}
+	return false
+}
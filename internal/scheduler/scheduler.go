@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Job is a task run periodically by the scheduler.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs in the background of the API process. Every run takes
+// a Postgres advisory lock derived from the job name, so when several
+// replicas are deployed only one of them executes a given job at a time.
+type Scheduler struct {
+	pool *pgxpool.Pool
+	jobs []Job
+	wg   sync.WaitGroup
+}
+
+func New(pool *pgxpool.Pool) *Scheduler {
+	return &Scheduler{pool: pool}
+}
+
+// Add registers a job. It must be called before Start.
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Start runs every job once right away, then on each interval, until ctx is
+// cancelled. It doesn't block.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go func(job Job) {
+			defer s.wg.Done()
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+
+			for {
+				s.runLocked(ctx, job)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
+}
+
+// Wait blocks until every job has stopped after ctx cancellation.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// runLocked runs job if no other replica holds its lock. The lock is a
+// session lock, so it is held on a dedicated connection for the whole run and
+// released by Postgres if the process dies.
+func (s *Scheduler) runLocked(ctx context.Context, job Job) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		log.Printf("scheduler: %s: failed to acquire connection: %v", job.Name, err)
+		return
+	}
+	defer conn.Release()
+
+	key := lockKey(job.Name)
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		log.Printf("scheduler: %s: failed to take lock: %v", job.Name, err)
+		return
+	}
+	if !locked {
+		return
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			log.Printf("scheduler: %s: failed to release lock: %v", job.Name, err)
+		}
+	}()
+
+	if err := job.Run(ctx); err != nil {
+		log.Printf("scheduler: %s: %v", job.Name, err)
+	}
+}
+
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
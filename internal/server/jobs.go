@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"figenn/internal/mailer"
+	"figenn/internal/ratelimit"
+	"figenn/internal/scheduler"
+	"figenn/internal/subscriptions"
+	"os"
+	"time"
+)
+
+// StartScheduler starts the background jobs of the API. Jobs are coordinated
+// through Postgres so they can be started on every replica. Setting
+// SCHEDULER_DISABLED=true keeps a replica out of the rotation, the returned
+// scheduler is then nil. Once ctx is cancelled, Wait lets the running jobs
+// finish.
+func (s *Server) StartScheduler(ctx context.Context) *scheduler.Scheduler {
+	if os.Getenv("SCHEDULER_DISABLED") == "true" {
+		return nil
+	}
+
+	sched := scheduler.New(s.db.Pool())
+	reminders := subscriptions.NewReminderService(subscriptions.NewRepository(s.db), mailer.NewMailer())
+	sched.Add(scheduler.Job{
+		Name:     "renewal-reminders",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			return reminders.SendRenewalReminders(ctx, time.Now())
+		},
+	})
+
+	// Webhooks are processed as they arrive; this catches up on retries and
+	// on events received while processing was down.
+	powensService := s.newPowensService()
+	sched.Add(scheduler.Job{
+		Name:     "powens-webhooks",
+		Interval: time.Minute,
+		Run:      powensService.ProcessWebhooks,
+	})
+
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		limiter := ratelimit.New(ratelimit.NewPostgresStore(s.db.Pool()), ratelimit.DefaultConfig())
+		sched.Add(scheduler.Job{
+			Name:     "rate-limit-prune",
+			Interval: time.Hour,
+			Run:      limiter.Prune,
+		})
+	}
+
+	sched.Start(ctx)
+	return sched
+}
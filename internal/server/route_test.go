@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"figenn/internal/database/mocks"
+	"figenn/internal/jwtkeys"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -29,7 +30,7 @@ func TestHandler(t *testing.T) {
 		router: e,
 		db:     mockDB,
 		config: Config{
-			JWTSecret: "test-secret",
+			JWTKeys: jwtkeys.NewHMACKeySet("test-secret"),
 		},
 	}
 
@@ -53,3 +54,22 @@ func TestHandler(t *testing.T) {
 		return
 	}
 }
+
+func TestIPExtractorTrustsOnlyProxies(t *testing.T) {
+	extract := newIPExtractor("198.51.100.0/24")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.5")
+
+	req.RemoteAddr = "192.0.2.10:1234"
+	if ip := extract(req); ip != "192.0.2.10" {
+		t.Errorf("direct client: got %s, want 192.0.2.10", ip)
+	}
+
+	for _, proxy := range []string{"10.0.0.2:1234", "198.51.100.7:1234"} {
+		req.RemoteAddr = proxy
+		if ip := extract(req); ip != "203.0.113.5" {
+			t.Errorf("through %s: got %s, want 203.0.113.5", proxy, ip)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package server
 
 import (
+	"context"
+	"figenn/internal/aggregator"
 	"figenn/internal/auth"
+	"figenn/internal/bank"
+	"figenn/internal/exchange"
 	"figenn/internal/mailer"
 	"figenn/internal/payment"
 	stripe "figenn/internal/payment"
 	"figenn/internal/powens"
+	"figenn/internal/ratelimit"
 	"figenn/internal/subscriptions"
 	"figenn/internal/users"
 	"fmt"
@@ -13,10 +18,14 @@ import (
 	"os"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
 func (s *Server) SetupRoutes() {
+	s.router.GET("/.well-known/jwks.json", s.jwksHandler)
+	s.router.GET("/media/*", s.config.Media.Handler)
+
 	apiGroup := s.router.Group("/api")
 
 	apiGroup.GET("/health", s.healthHandler)
@@ -27,7 +36,17 @@ func (s *Server) SetupRoutes() {
 	s.setupStripeRoutes(apiGroup)
 	s.SetupPowensApi().Bind(apiGroup)
 	s.setupSubscriptionRoutes(apiGroup)
+	bank.NewAPI(s.config.JWTKeys, s.newBankService(), s.userService).Bind(apiGroup)
+
+}
 
+// jwksHandler publishes the public keys verifying the access tokens, for
+// other services to authenticate Figenn users. The same keys sign every token
+// Figenn issues: consumers must also require the aud claim to be
+// jwtkeys.AudienceAccess ("figenn:access").
+func (s *Server) jwksHandler(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, s.config.JWTKeys.JWKS())
 }
 
 func (s *Server) setupAuthRoutes(apiGroup *echo.Group) {
@@ -51,56 +70,92 @@ func (s *Server) setupSubscriptionRoutes(apiGroup *echo.Group) {
 }
 
 func (s *Server) newAuthAPI() *auth.API {
-	authRepo := auth.NewRepository(s.db.Pool())
+	authRepo := auth.NewRepository(s.db.Pool(), s.config.Keyring)
 	paymentService := payment.NewService(os.Getenv("STRIPE_SECRET_KEY"), stripe.NewRepository(s.db))
 	authService := auth.NewService(authRepo, &auth.Config{
-		JWTSecret:            s.config.JWTSecret,
+		JWTKeys:              s.config.JWTKeys,
 		TokenDuration:        time.Minute * 30,
 		RefreshTokenDuration: time.Hour * 24 * 7 * 4,
 		AppURL:               os.Getenv("APP_URL"),
 		Environment:          os.Getenv("APP_ENV"),
 	}, mailer.NewMailer(), paymentService)
 
-	return auth.NewAPI(authService, s.config.JWTSecret)
+	authService.AddUserHook(func(ctx context.Context, userID uuid.UUID) {
+		s.userService.Forget(userID.String())
+	})
+
+	limiterConfig := ratelimit.DefaultConfig()
+	limiterConfig.OnLockout = authService.SendUnlockEmail
+	limiter := ratelimit.New(s.newRateLimitStore(limiterConfig), limiterConfig)
+
+	return auth.NewAPI(authService, s.config.JWTKeys, limiter)
+}
+
+// newRateLimitStore returns the store selected by RATE_LIMIT_STORE:
+// "postgres" shares the limits between replicas, anything else keeps them in
+// memory.
+func (s *Server) newRateLimitStore(config ratelimit.Config) ratelimit.Store {
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		return ratelimit.NewPostgresStore(s.db.Pool())
+	}
+	return ratelimit.NewMemoryStore(config.FailureWindow + config.Lockout)
 }
 
 func (s *Server) newUserAPI() *users.API {
-	userRepo := users.NewRepository(s.db)
-	authService := users.NewService(userRepo)
-	return users.NewAPI(s.config.JWTSecret, authService)
+	return users.NewAPI(s.config.JWTKeys, s.userService, s.config.Media)
 }
 
 func (s *Server) newStripeAPI() *stripe.API {
 	stripeRepo := stripe.NewRepository(s.db)
 	stripeService := stripe.NewService(os.Getenv("STRIPE_SECRET_KEY"), stripeRepo)
-	return stripe.NewAPI(s.config.JWTSecret, stripeService)
+	return stripe.NewAPI(s.config.JWTKeys, stripeService, s.userService)
 }
 
 func (s *Server) SetupPowensApi() *powens.API {
-	clientID := os.Getenv("POWENS_CLIENT_ID")
-	clientSecret := os.Getenv("POWENS_CLIENT_SECRET")
-	domain := os.Getenv("POWENS_DOMAIN")
-	callbackURI := os.Getenv("POWENS_REDIRECT_URI")
+	return powens.NewAPI(s.config.JWTKeys, s.newPowensService(), s.userService)
+}
 
-	config := &powens.Config{
-		ClientID:    clientID,
-		Domain:      domain,
-		CallbackURI: callbackURI,
+// newAggregator returns the bank aggregator selected by BANK_AGGREGATOR:
+// "fake" serves generated bank data without any provider account, anything
+// else uses Powens.
+func newAggregator() aggregator.BankAggregator {
+	if os.Getenv("BANK_AGGREGATOR") == "fake" {
+		return aggregator.NewFake(nil)
 	}
+	return powens.NewClient(os.Getenv("POWENS_DOMAIN"), os.Getenv("POWENS_CLIENT_ID"), os.Getenv("POWENS_CLIENT_SECRET"))
+}
 
-	client := powens.NewClient(clientID, clientSecret)
+func (s *Server) newBankService() *bank.Service {
+	return bank.NewService(bank.NewRepository(s.db), powens.NewRepository(s.db, s.config.Keyring), s.aggregator, os.Getenv("POWENS_REDIRECT_URI"))
+}
 
-	repo := powens.NewRepository(s.db)
+// newPowensService builds the Powens service. Bank data is synced whenever
+// Powens notifies new data.
+func (s *Server) newPowensService() *powens.Service {
+	config := &powens.Config{
+		CallbackURI:   os.Getenv("POWENS_REDIRECT_URI"),
+		WebhookSecret: os.Getenv("POWENS_WEBHOOK_SECRET"),
+	}
 
-	service := powens.NewService(repo, client, config)
+	repo := powens.NewRepository(s.db, s.config.Keyring)
 
-	return powens.NewAPI(service)
+	service := powens.NewService(repo, s.aggregator, config, s.newSubscriptionService())
+	bankService := s.newBankService()
+	service.AddSyncHook(func(ctx context.Context, userID uuid.UUID) error {
+		_, err := bankService.Sync(ctx, userID)
+		return err
+	})
+	return service
 }
 
 func (s *Server) SetupSubscriptionAPI() *subscriptions.API {
+	return subscriptions.NewAPI(s.config.JWTKeys, s.newSubscriptionService(), s.config.Media)
+}
+
+func (s *Server) newSubscriptionService() *subscriptions.Service {
 	subscriptionsRepo := subscriptions.NewRepository(s.db)
-	subscriptionsService := subscriptions.NewService(subscriptionsRepo)
-	return subscriptions.NewAPI(s.config.JWTSecret, subscriptionsService)
+	rates := exchange.NewChainProvider(exchange.NewRepository(s.db), exchange.NewStaticProvider(exchange.DefaultRates))
+	return subscriptions.NewService(subscriptionsRepo, rates)
 }
 
 func (s *Server) healthHandler(c echo.Context) error {
@@ -1,8 +1,17 @@
 package server
 
 import (
+	"context"
+	"figenn/internal/aggregator"
 	"figenn/internal/database"
+	"figenn/internal/encryption"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/media"
+	"figenn/internal/users"
 	"log"
+	"net"
+	"os"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -14,18 +23,27 @@ type ServerStorer interface {
 }
 
 type Config struct {
-	JWTSecret string
+	// JWTKeys signs the access tokens and verifies them.
+	JWTKeys *jwtkeys.KeySet
+	// Keyring encrypts the secrets stored in the database.
+	Keyring *encryption.Keyring
+	// Media stores the uploaded avatars and logos.
+	Media *media.Uploader
 }
 
 type Server struct {
-	db        database.DbService
-	router    *echo.Echo
-	config    Config
-	JWTSecret string
+	db         database.DbService
+	router     *echo.Echo
+	config     Config
+	aggregator aggregator.BankAggregator
+	// userService is shared by the APIs so that its cache can be evicted
+	// when the auth service changes a user.
+	userService *users.Service
 }
 
 func NewServer(db database.DbService, config Config) *Server {
 	e := echo.New()
+	e.IPExtractor = newIPExtractor(os.Getenv("TRUSTED_PROXIES"))
 
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -38,14 +56,41 @@ func NewServer(db database.DbService, config Config) *Server {
 	}))
 
 	return &Server{
-		db:        db,
-		router:    e,
-		config:    config,
-		JWTSecret: config.JWTSecret,
+		db:          db,
+		router:      e,
+		config:      config,
+		aggregator:  newAggregator(),
+		userService: users.NewService(users.NewRepository(db)),
 	}
 }
 
+// newIPExtractor reads the client IP from X-Forwarded-For only when the request
+// comes through a trusted proxy: loopback and private addresses, plus the
+// comma separated CIDRs of trustedProxies. Anyone else could spoof the header
+// to dodge the rate limits.
+func newIPExtractor(trustedProxies string) echo.IPExtractor {
+	var options []echo.TrustOption
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("invalid TRUSTED_PROXIES entry %q: %v", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func (s *Server) Start(port string) error {
 	log.Printf("Server starting on port %s", port)
 	return s.router.Start(":" + port)
 }
+
+// Shutdown stops accepting requests and waits for the running ones, making
+// Start return http.ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.router.Shutdown(ctx)
+}
@@ -0,0 +1,128 @@
+package subscriptions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	icsDateFormat     = "20060102"
+	icsDateTimeFormat = "20060102T150405Z"
+	icsLineLimit      = 75
+)
+
+// BuildCalendar renders subs as an RFC 5545 calendar with one recurring
+// all-day event per subscription.
+func BuildCalendar(subs []*Subscription, now time.Time) string {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//Figenn//Subscriptions//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:Figenn subscriptions")
+
+	for _, sub := range subs {
+		start := billingStart(sub)
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, "UID:"+sub.Id+"@figenn")
+		writeICSLine(&b, "DTSTAMP:"+now.UTC().Format(icsDateTimeFormat))
+		writeICSLine(&b, "DTSTART;VALUE=DATE:"+start.Format(icsDateFormat))
+		writeICSLine(&b, "DTEND;VALUE=DATE:"+start.AddDate(0, 0, 1).Format(icsDateFormat))
+		if rrule := recurrenceRule(sub); rrule != "" {
+			writeICSLine(&b, "RRULE:"+rrule)
+		}
+		writeICSLine(&b, "SUMMARY:"+escapeICSText(fmt.Sprintf("%s (%s %s)", sub.Name, strconv.FormatFloat(sub.Price, 'f', 2, 64), sub.Currency)))
+		if sub.Description != "" {
+			writeICSLine(&b, "DESCRIPTION:"+escapeICSText(sub.Description))
+		}
+		if sub.Category != "" {
+			writeICSLine(&b, "CATEGORIES:"+escapeICSText(sub.Category))
+		}
+		writeICSLine(&b, "TRANSP:TRANSPARENT")
+		writeICSLine(&b, "END:VEVENT")
+	}
+
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// recurrenceRule returns the RRULE matching the billing cycle of sub. Month
+// based cycles anchored after the 28th select the last existing day among
+// 28..anchor, which reproduces the month-end clamping of ChargeDates.
+func recurrenceRule(sub *Subscription) string {
+	var parts []string
+	start := billingStart(sub)
+
+	if months := cycleMonths(sub.BillingCycle); months > 0 {
+		if months == 12 {
+			parts = append(parts, "FREQ=YEARLY")
+			if start.Day() > 28 {
+				parts = append(parts, "BYMONTH="+strconv.Itoa(int(start.Month())))
+			}
+		} else {
+			parts = append(parts, "FREQ=MONTHLY")
+			if months > 1 {
+				parts = append(parts, "INTERVAL="+strconv.Itoa(months))
+			}
+		}
+		if start.Day() > 28 {
+			days := make([]string, 0, start.Day()-27)
+			for d := 28; d <= start.Day(); d++ {
+				days = append(days, strconv.Itoa(d))
+			}
+			parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","), "BYSETPOS=-1")
+		}
+	} else if days := cycleDays(sub); days > 0 {
+		if days%7 == 0 {
+			parts = append(parts, "FREQ=WEEKLY")
+			if days > 7 {
+				parts = append(parts, "INTERVAL="+strconv.Itoa(days/7))
+			}
+		} else {
+			parts = append(parts, "FREQ=DAILY", "INTERVAL="+strconv.Itoa(days))
+		}
+	} else {
+		return ""
+	}
+
+	if sub.EndDate != nil {
+		parts = append(parts, "UNTIL="+truncateDay(*sub.EndDate).Format(icsDateFormat))
+	}
+	return strings.Join(parts, ";")
+}
+
+func escapeICSText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// writeICSLine writes a content line folded at 75 octets, without splitting
+// multi-byte characters.
+func writeICSLine(b *strings.Builder, line string) {
+	limit := icsLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts in the limit.
+		limit = icsLineLimit - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+func isRuneStart(c byte) bool {
+	return c&0xC0 != 0x80
+}
@@ -0,0 +1,50 @@
+package subscriptions
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecurrenceRule(t *testing.T) {
+	tenDays := 10
+	endDate := date(2025, time.December, 31)
+
+	tests := []struct {
+		sub      *Subscription
+		expected string
+	}{
+		{&Subscription{StartDate: date(2025, time.March, 3), BillingCycle: Monthly}, "FREQ=MONTHLY"},
+		{&Subscription{StartDate: date(2025, time.January, 31), BillingCycle: Monthly, EndDate: &endDate}, "FREQ=MONTHLY;BYMONTHDAY=28,29,30,31;BYSETPOS=-1;UNTIL=20251231"},
+		{&Subscription{StartDate: date(2025, time.March, 3), BillingCycle: Quarterly}, "FREQ=MONTHLY;INTERVAL=3"},
+		{&Subscription{StartDate: date(2024, time.February, 29), BillingCycle: Annual}, "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=28,29;BYSETPOS=-1"},
+		{&Subscription{StartDate: date(2025, time.March, 3), BillingCycle: Biweekly}, "FREQ=WEEKLY;INTERVAL=2"},
+		{&Subscription{StartDate: date(2025, time.March, 3), BillingCycle: Custom, IntervalDays: &tenDays}, "FREQ=DAILY;INTERVAL=10"},
+		{&Subscription{StartDate: date(2025, time.March, 3), BillingCycle: OneTime}, ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, recurrenceRule(tt.sub))
+	}
+}
+
+func TestBuildCalendarFoldsAndEscapes(t *testing.T) {
+	sub := &Subscription{
+		Id:           "abc",
+		Name:         "Cloud, storage; family plan",
+		Description:  strings.Repeat("é", 60),
+		StartDate:    date(2025, time.March, 3),
+		Price:        9.99,
+		Currency:     "EUR",
+		BillingCycle: Monthly,
+	}
+
+	ics := BuildCalendar([]*Subscription{sub}, date(2025, time.March, 1))
+
+	assert.Contains(t, ics, `SUMMARY:Cloud\, storage\; family plan (9.99 EUR)`)
+	for _, line := range strings.Split(strings.TrimSuffix(ics, "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+}
@@ -13,4 +13,38 @@ var (
 	ErrFailedCreateSub        = errors.New("failed to create subscription")
 	ErrInvalidPeriod          = errors.New("invalid period")
 	ErrInvalidWeek            = errors.New("invalid week")
+	ErrInvalidGranularity     = errors.New("invalid granularity")
+	ErrInvalidCalendarToken   = errors.New("invalid calendar token")
+	ErrInvalidName            = errors.New("name is required and must be at most 30 characters")
+	ErrInvalidBillingCycle    = errors.New("invalid billing cycle value")
+	ErrInvalidIntervalDays    = errors.New("custom billing cycle requires a positive interval_days")
+	ErrInvalidPrice           = errors.New("price must be greater than or equal to 0")
+	ErrInvalidCurrency        = errors.New("invalid currency value")
+	ErrStartDateRequired      = errors.New("start date is required")
+	ErrEndBeforeStart         = errors.New("end date must not be before start date")
+	ErrInvalidImportFormat    = errors.New("invalid import format")
+	ErrTooManyImportRows      = errors.New("too many rows to import")
+	ErrInvalidTrialEnd        = errors.New("trial end must be after the start date")
+	ErrTrialEndRequired       = errors.New("price after trial requires a trial end date")
 )
+
+// IsValidationError reports whether err comes from validating a subscription
+// request, i.e. the request itself is at fault.
+func IsValidationError(err error) bool {
+	for _, target := range []error{
+		ErrInvalidName,
+		ErrInvalidBillingCycle,
+		ErrInvalidIntervalDays,
+		ErrInvalidPrice,
+		ErrInvalidCurrency,
+		ErrStartDateRequired,
+		ErrEndBeforeStart,
+		ErrInvalidTrialEnd,
+		ErrTrialEndRequired,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package subscriptions
+
+import "time"
+
+// maxForecastRange bounds the horizon of a forecast.
+const maxForecastRange = 5 * 366 * 24 * time.Hour
+
+// BuildForecast projects the charges of subs over [from, to) and groups them
+// in buckets of the given granularity. Buckets are aligned on calendar days,
+// ISO weeks or months and clipped to the requested range.
+func BuildForecast(subs []*Subscription, from, to time.Time, granularity Granularity) *Forecast {
+	from, to = truncateDay(from), truncateDay(to)
+	forecast := &Forecast{From: from, To: to, Granularity: granularity, Buckets: []*ForecastBucket{}}
+
+	charges := ChargesInRange(subs, from, to)
+	next := 0
+	for start := from; start.Before(to); {
+		end := bucketEnd(start, granularity)
+		if end.After(to) {
+			end = to
+		}
+
+		bucket := &ForecastBucket{Start: start, End: end, Charges: []*Charge{}}
+		for ; next < len(charges) && charges[next].ChargeDate.Before(end); next++ {
+			charge := charges[next]
+			bucket.Charges = append(bucket.Charges, charge)
+			bucket.Total += charge.ConvertedAmount
+			if charge.DisplayCurrency != "" {
+				forecast.Currency = charge.DisplayCurrency
+			}
+		}
+		bucket.Total = roundCents(bucket.Total)
+		forecast.Total += bucket.Total
+		forecast.Buckets = append(forecast.Buckets, bucket)
+		start = end
+	}
+	forecast.Total = roundCents(forecast.Total)
+	return forecast
+}
+
+// bucketEnd returns the start of the bucket following the one containing day.
+func bucketEnd(day time.Time, granularity Granularity) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, 7-offset)
+	case GranularityMonth:
+		return time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day.AddDate(0, 0, 1)
+	}
+}
+
+func isValidGranularity(granularity Granularity) bool {
+	switch granularity {
+	case GranularityDay, GranularityWeek, GranularityMonth:
+		return true
+	default:
+		return false
+	}
+}
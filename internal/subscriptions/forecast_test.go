@@ -0,0 +1,40 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildForecast(t *testing.T) {
+	subs := []*Subscription{
+		{Name: "music", StartDate: date(2025, time.January, 31), Price: 10, BillingCycle: Monthly},
+		{Name: "gym", StartDate: date(2025, time.February, 3), Price: 5, BillingCycle: Weekly},
+	}
+
+	forecast := BuildForecast(subs, date(2025, time.February, 10), date(2025, time.April, 1), GranularityMonth)
+
+	if assert.Len(t, forecast.Buckets, 2) {
+		feb := forecast.Buckets[0]
+		assert.Equal(t, date(2025, time.February, 10), feb.Start)
+		assert.Equal(t, date(2025, time.March, 1), feb.End)
+		assert.Len(t, feb.Charges, 4)
+		assert.Equal(t, 25.0, feb.Total)
+
+		mar := forecast.Buckets[1]
+		assert.Equal(t, date(2025, time.March, 31), mar.Charges[len(mar.Charges)-1].ChargeDate)
+		assert.Equal(t, 35.0, mar.Total)
+	}
+	assert.Equal(t, 60.0, forecast.Total)
+}
+
+func TestBuildForecastWeeklyBuckets(t *testing.T) {
+	forecast := BuildForecast(nil, date(2025, time.March, 5), date(2025, time.March, 20), GranularityWeek)
+
+	if assert.Len(t, forecast.Buckets, 3) {
+		assert.Equal(t, date(2025, time.March, 10), forecast.Buckets[0].End)
+		assert.Equal(t, date(2025, time.March, 17), forecast.Buckets[1].End)
+		assert.Equal(t, date(2025, time.March, 20), forecast.Buckets[2].End)
+	}
+}
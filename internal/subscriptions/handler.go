@@ -3,10 +3,17 @@ package subscriptions
 import (
 	"context"
 	"figenn/internal/errors"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/media"
 	"figenn/internal/users"
 	"figenn/internal/utils"
+	"log"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -14,30 +21,36 @@ import (
 // mockgen -source=internal/subscriptions/handler.go -destination=internal/subscriptions/mocks/mock_database.go -package=mocks
 type SubscriptionStore interface {
 	CreateSubscription(ctx context.Context, sub *Subscription) error
-	GetActiveSubscriptions(ctx context.Context, userID string, year int, month int) ([]*Subscription, error)
+	GetSubscriptionsInRange(ctx context.Context, userID string, from, to time.Time) ([]*Subscription, error)
 	GetAllSubscriptions(ctx context.Context, userID string, limit, offset int) ([]*Subscription, error)
 	DeleteSubscription(ctx context.Context, userID, subID string) error
-	UpdateSubscription(ctx context.Context, userID, subID string, req UpdateSubscriptionRequest) error
+	UpdateSubscription(ctx context.Context, userID, subID string, fields map[string]interface{}, priceEffectiveFrom *time.Time) error
 	GetSubscription(ctx context.Context, userID, subID string) (*Subscription, error)
-	GetSubscriptionsByCategory(ctx context.Context, userID string) ([]*Subscription, error)
-	GetUpcomingSubscriptions(ctx context.Context, userID string, week int) ([]*Subscription, error)
-	CalculateActiveSubscriptions(ctx context.Context, userID string, year, month *int) ([]*Subscription, error)
+	GetSubscriptionsByCategory(ctx context.Context, userID string) ([]*SubscriptionCategoryCount, error)
 }
 
+// maxImportFileSize bounds the size of an imported file.
+const maxImportFileSize = 5 << 20
+
 type API struct {
-	JWTSecret string
-	s         *Service
+	JWTKeys *jwtkeys.KeySet
+	s       *Service
+	media   *media.Uploader
 }
 
-func NewAPI(secret string, service *Service) *API {
+func NewAPI(keys *jwtkeys.KeySet, service *Service, uploader *media.Uploader) *API {
 	return &API{
-		JWTSecret: secret,
-		s:         service,
+		JWTKeys: keys,
+		s:       service,
+		media:   uploader,
 	}
 }
 
 func (a *API) Bind(rg *echo.Group) {
-	subGroup := rg.Group("/subscriptions", users.CookieAuthMiddleware(a.JWTSecret))
+	// Calendar clients can't send cookies, the feed is authenticated by its token.
+	rg.GET("/subscriptions/calendar.ics", a.GetCalendarFeed)
+
+	subGroup := rg.Group("/subscriptions", users.CookieAuthMiddleware(a.JWTKeys))
 
 	subGroup.GET("", a.GetAllSubscriptions)
 	subGroup.POST("", a.CreateSubscription)
@@ -45,9 +58,18 @@ func (a *API) Bind(rg *echo.Group) {
 	subGroup.DELETE("/:id", a.DeleteSubscription)
 	subGroup.PATCH("/:id", a.UpdateSubscription)
 	subGroup.GET("/:id", a.GetSubscription)
+	subGroup.POST("/:id/logo", a.UploadLogo, media.BodyLimit)
 	subGroup.GET("/calculate", a.CalculateActiveSubscriptions)
 	subGroup.GET("/upcoming", a.GetUpcomingSubscriptions)
+	subGroup.GET("/forecast", a.GetForecast)
+	subGroup.GET("/price-changes", a.GetPriceChanges)
+	subGroup.POST("/calendar/token", a.CreateCalendarToken)
+	subGroup.DELETE("/calendar/token", a.RevokeCalendarToken)
 	subGroup.GET("/by_category", a.GetSubscriptionsByCategory)
+	subGroup.GET("/export", a.ExportSubscriptions)
+	subGroup.POST("/import", a.ImportSubscriptions)
+	subGroup.GET("/reminders", a.GetReminderPreferences)
+	subGroup.PUT("/reminders", a.UpdateReminderPreferences)
 }
 
 func (a *API) CreateSubscription(c echo.Context) error {
@@ -57,8 +79,8 @@ func (a *API) CreateSubscription(c echo.Context) error {
 		return errors.NewBadRequestError("Invalid request format")
 	}
 
-	if !isValidBillingCycle(req.BillingCycle) {
-		return errors.NewBadRequestError("Invalid billing cycle value")
+	if err := req.Validate(); err != nil {
+		return errors.NewBadRequestError(err.Error())
 	}
 
 	userID, err := getUserID(c)
@@ -66,7 +88,7 @@ func (a *API) CreateSubscription(c echo.Context) error {
 		return err
 	}
 
-	if err := a.s.CreateSubscription(ctx, userID, req); err != nil {
+	if _, err := a.s.CreateSubscription(ctx, userID, req); err != nil {
 		return handleServiceError(err)
 	}
 
@@ -153,6 +175,10 @@ func (a *API) UpdateSubscription(c echo.Context) error {
 		return errors.NewBadRequestError("Invalid request format")
 	}
 
+	if req.Currency != nil && !utils.ValidateCurrency(*req.Currency) {
+		return errors.NewBadRequestError("Invalid currency value")
+	}
+
 	if err := a.s.UpdateSubscription(c.Request().Context(), userID, subID, req); err != nil {
 		return handleServiceError(err)
 	}
@@ -160,6 +186,53 @@ func (a *API) UpdateSubscription(c echo.Context) error {
 	return c.JSON(http.StatusOK, echo.Map{"message": "Subscription updated successfully"})
 }
 
+// UploadLogo replaces the logo of a subscription with the image of the "file"
+// multipart field.
+func (a *API) UploadLogo(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	subID := c.Param("id")
+	if subID == "" {
+		return errors.NewBadRequestError("Invalid subscription ID")
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return errors.NewBadRequestError("A file is required")
+	}
+
+	ctx := c.Request().Context()
+	sub, err := a.s.GetSubscription(ctx, userID, subID)
+	if err != nil {
+		return handleServiceError(err)
+	}
+	if sub == nil {
+		return errors.NewNotFoundError("Subscription not found")
+	}
+
+	// Logos are stored per subscription, so that replacing one never removes
+	// the same image used by another subscription.
+	url, err := a.media.Upload(ctx, media.Logo, sub.Id, file)
+	if err != nil {
+		return handleMediaError(err)
+	}
+
+	previous, err := a.s.SetLogo(ctx, userID, subID, url)
+	if err != nil {
+		return handleServiceError(err)
+	}
+	if previous != url {
+		if err := a.media.Remove(ctx, media.Logo, sub.Id, previous); err != nil {
+			log.Printf("subscriptions: failed to remove previous logo: %v", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"logo_url": url})
+}
+
 func (a *API) GetSubscription(c echo.Context) error {
 	userID, err := getUserID(c)
 	if err != nil {
@@ -195,12 +268,12 @@ func (a *API) CalculateActiveSubscriptions(c echo.Context) error {
 		return errors.NewBadRequestError(err.Error())
 	}
 
-	subs, err := a.s.CalculateActiveSubscriptions(c.Request().Context(), userID, year, month)
+	summary, err := a.s.CalculateActiveSubscriptions(c.Request().Context(), userID, year, month)
 	if err != nil {
 		return errors.NewInternalServerError("Failed to fetch subscriptions")
 	}
 
-	return c.JSON(http.StatusOK, subs)
+	return c.JSON(http.StatusOK, summary)
 }
 
 func (a *API) GetUpcomingSubscriptions(c echo.Context) error {
@@ -219,14 +292,212 @@ func (a *API) GetUpcomingSubscriptions(c echo.Context) error {
 		return errors.NewBadRequestError("Invalid week value")
 	}
 
-	subs, err := a.s.GetUpcomingSubscriptions(c.Request().Context(), userID, week)
+	// trial_window is the number of days ahead in which a trial converting to
+	// paid gets flagged.
+	trialWindow := 7
+	if v := c.QueryParam("trial_window"); v != "" {
+		trialWindow, err = strconv.Atoi(v)
+		if err != nil || trialWindow < 0 || trialWindow > maxTrialWindow {
+			return errors.NewBadRequestError("Trial window must be between 0 and 365 days")
+		}
+	}
+
+	subs, err := a.s.GetUpcomingSubscriptions(c.Request().Context(), userID, week, trialWindow)
 	if err != nil {
-		return errors.NewInternalServerError("Failed to fetch subscriptions")
+		return handleServiceError(err)
 	}
 
 	return c.JSON(http.StatusOK, subs)
 }
 
+func (a *API) GetForecast(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, err := dateFromQuery(c, "from", today)
+	if err != nil {
+		return errors.NewBadRequestError("Invalid from date, expected YYYY-MM-DD")
+	}
+
+	to, err := dateFromQuery(c, "to", from.AddDate(1, 0, 0))
+	if err != nil {
+		return errors.NewBadRequestError("Invalid to date, expected YYYY-MM-DD")
+	}
+
+	granularity := Granularity(c.QueryParam("granularity"))
+	if granularity == "" {
+		granularity = GranularityMonth
+	}
+
+	forecast, err := a.s.GetForecast(c.Request().Context(), userID, from, to, granularity)
+	if err != nil {
+		return handleServiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, forecast)
+}
+
+func (a *API) GetPriceChanges(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	changes, err := a.s.GetPriceChanges(c.Request().Context(), userID)
+	if err != nil {
+		return errors.NewInternalServerError("Failed to fetch price changes")
+	}
+
+	return c.JSON(http.StatusOK, changes)
+}
+
+func (a *API) CreateCalendarToken(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.s.CreateCalendarToken(c.Request().Context(), userID)
+	if err != nil {
+		return errors.NewInternalServerError("Failed to create calendar token")
+	}
+
+	feedURL := c.Scheme() + "://" + c.Request().Host + "/api/subscriptions/calendar.ics?token=" + url.QueryEscape(token)
+	return c.JSON(http.StatusCreated, echo.Map{
+		"token": token,
+		"url":   feedURL,
+	})
+}
+
+func (a *API) GetReminderPreferences(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	prefs, err := a.s.GetReminderPreferences(c.Request().Context(), userID)
+	if err != nil {
+		return errors.NewInternalServerError("Failed to fetch reminder preferences")
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+func (a *API) UpdateReminderPreferences(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var prefs ReminderPreferences
+	if err := c.Bind(&prefs); err != nil {
+		return errors.NewBadRequestError("Invalid request format")
+	}
+
+	if err := a.s.UpdateReminderPreferences(c.Request().Context(), userID, prefs); err != nil {
+		return handleServiceError(err)
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+func (a *API) RevokeCalendarToken(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := a.s.RevokeCalendarToken(c.Request().Context(), userID); err != nil {
+		return errors.NewInternalServerError("Failed to revoke calendar token")
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Calendar token revoked successfully"})
+}
+
+func (a *API) GetCalendarFeed(c echo.Context) error {
+	feed, err := a.s.GetCalendarFeed(c.Request().Context(), c.QueryParam("token"))
+	if err != nil {
+		return handleServiceError(err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `inline; filename="figenn.ics"`)
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(feed))
+}
+
+func (a *API) ExportSubscriptions(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	format := ExportFormat(strings.ToLower(c.QueryParam("format")))
+	if format == "" {
+		format = FormatCSV
+	}
+	if !isValidExportFormat(format) {
+		return errors.NewBadRequestError("Format must be one of csv, json or xlsx")
+	}
+
+	data, err := a.s.ExportSubscriptions(c.Request().Context(), userID, format)
+	if err != nil {
+		return errors.NewInternalServerError("Failed to export subscriptions")
+	}
+
+	filename := "subscriptions-" + time.Now().Format(time.DateOnly) + "." + string(format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	return c.Blob(http.StatusOK, format.ContentType(), data)
+}
+
+// ImportSubscriptions reads the multipart "file" field. The format comes from
+// the format query parameter or, failing that, the file extension.
+func (a *API) ImportSubscriptions(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return errors.NewBadRequestError("A file is required")
+	}
+	if file.Size > maxImportFileSize {
+		return errors.NewBadRequestError("File is too large")
+	}
+
+	format := ExportFormat(strings.ToLower(c.QueryParam("format")))
+	if format == "" {
+		format = ExportFormat(strings.ToLower(strings.TrimPrefix(filepath.Ext(file.Filename), ".")))
+	}
+	if !isValidExportFormat(format) {
+		return errors.NewBadRequestError("Format must be one of csv, json or xlsx")
+	}
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	src, err := file.Open()
+	if err != nil {
+		return errors.NewBadRequestError("Unable to read file")
+	}
+	defer src.Close()
+
+	records, err := DecodeSubscriptions(src, format)
+	if err != nil {
+		return handleServiceError(err)
+	}
+
+	result, err := a.s.ImportSubscriptions(c.Request().Context(), userID, records, dryRun)
+	if err != nil {
+		return errors.NewInternalServerError("Failed to import subscriptions")
+	}
+
+	status := http.StatusCreated
+	if dryRun {
+		status = http.StatusOK
+	}
+	return c.JSON(status, result)
+}
+
 func (a *API) GetSubscriptionsByCategory(c echo.Context) error {
 	userID, err := getUserID(c)
 	if err != nil {
@@ -243,7 +514,7 @@ func (a *API) GetSubscriptionsByCategory(c echo.Context) error {
 
 func isValidBillingCycle(cycle BillingCycleType) bool {
 	switch cycle {
-	case Monthly, Quarterly, Annual:
+	case Weekly, Biweekly, Monthly, Quarterly, SemiAnnual, Annual, OneTime, Custom:
 		return true
 	default:
 		return false
@@ -270,6 +541,27 @@ func optionalIntFromQuery(c echo.Context, key string, validate func(string) (int
 	return &v, nil
 }
 
+func dateFromQuery(c echo.Context, key string, fallback time.Time) (time.Time, error) {
+	val := c.QueryParam(key)
+	if val == "" {
+		return fallback, nil
+	}
+	return time.Parse(time.DateOnly, val)
+}
+
+func handleMediaError(err error) error {
+	switch err {
+	case media.ErrFileTooLarge:
+		return errors.NewApiError(http.StatusRequestEntityTooLarge, "Image is too large, the limit is 5 MB")
+	case media.ErrUnsupportedType:
+		return errors.NewApiError(http.StatusUnsupportedMediaType, err.Error())
+	case media.ErrInvalidImage:
+		return errors.NewBadRequestError("The image could not be read")
+	default:
+		return errors.NewInternalServerError("Failed to store the image")
+	}
+}
+
 func handleServiceError(err error) error {
 	switch err {
 	case ErrUserIDAndSubIDRequired:
@@ -278,9 +570,25 @@ func handleServiceError(err error) error {
 		return errors.NewNotFoundError("Subscription not found")
 	case ErrUserPermissionDenied:
 		return errors.NewForbiddenError("You are not authorized to perform this action")
+	case ErrInvalidWeek:
+		return errors.NewBadRequestError("Week must be between 1 and 53")
+	case ErrInvalidCalendarToken:
+		return errors.NewUnauthorizedError("Invalid calendar token")
+	case users.ErrInvalidLeadDays:
+		return errors.NewBadRequestError("Reminder lead days must be between 0 and 30")
+	case ErrInvalidImportFormat:
+		return errors.NewBadRequestError("The file could not be read in the given format")
+	case ErrTooManyImportRows:
+		return errors.NewBadRequestError("Too many rows, the limit is 5000 per import")
+	case ErrInvalidPeriod:
+		return errors.NewBadRequestError("Invalid period, from must be before to and span at most 5 years")
+	case ErrInvalidGranularity:
+		return errors.NewBadRequestError("Granularity must be one of day, week or month")
 	case ErrFailedCreateSub:
 		return errors.NewInternalServerError("Failed to create subscription")
-	default:
-		return errors.NewInternalServerError("Unexpected subscription service error")
 	}
+	if IsValidationError(err) {
+		return errors.NewBadRequestError(err.Error())
+	}
+	return errors.NewInternalServerError("Unexpected subscription service error")
 }
@@ -1,6 +1,10 @@
 package subscriptions
 
-import "time"
+import (
+	"figenn/internal/utils"
+	"time"
+	"unicode/utf8"
+)
 
 // === Domain Model ===
 
@@ -14,11 +18,44 @@ type Subscription struct {
 	StartDate    time.Time        `json:"start_date"`
 	EndDate      *time.Time       `json:"end_date,omitempty"`
 	Price        float64          `json:"price"`
+	Currency     string           `json:"currency"`
 	LogoUrl      *string          `json:"logo_url,omitempty"`
 	IsActive     bool             `json:"is_active"`
 	BillingCycle BillingCycleType `json:"billing_cycle"`
-	CreatedAt    time.Time        `json:"created_at"`
-	UpdatedAt    time.Time        `json:"updated_at"`
+	IntervalDays *int             `json:"interval_days,omitempty"`
+	// TrialEndsAt is the end of a free trial, which is also the first
+	// charge. Billing cycles are counted from it.
+	TrialEndsAt     *time.Time `json:"trial_ends_at,omitempty"`
+	PriceAfterTrial *float64   `json:"price_after_trial,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	// ConvertedPrice is Price expressed in DisplayCurrency, the currency
+	// chosen by the user. Both are filled on read.
+	ConvertedPrice  float64 `json:"converted_price"`
+	DisplayCurrency string  `json:"display_currency,omitempty"`
+
+	// PriceHistory holds the prices of the subscription ordered by effective
+	// date. When empty, Price is assumed to have always applied.
+	PriceHistory []*PricePoint `json:"-"`
+
+	rate float64
+}
+
+// toDisplay converts an amount in the subscription currency into the display
+// currency, when one has been resolved.
+func (s *Subscription) toDisplay(amount float64) float64 {
+	if s.DisplayCurrency == "" {
+		return amount
+	}
+	return roundCents(amount * s.rate)
+}
+
+// PricePoint is a price of a subscription and the day it took effect.
+type PricePoint struct {
+	Price         float64   `json:"price"`
+	Currency      string    `json:"currency"`
+	EffectiveFrom time.Time `json:"effective_from"`
 }
 
 // === Enums ===
@@ -26,9 +63,24 @@ type Subscription struct {
 type BillingCycleType string
 
 const (
-	Monthly   BillingCycleType = "monthly"
-	Quarterly BillingCycleType = "quarterly"
-	Annual    BillingCycleType = "annual"
+	Weekly     BillingCycleType = "weekly"
+	Biweekly   BillingCycleType = "biweekly"
+	Monthly    BillingCycleType = "monthly"
+	Quarterly  BillingCycleType = "quarterly"
+	SemiAnnual BillingCycleType = "semi_annual"
+	Annual     BillingCycleType = "annual"
+	OneTime    BillingCycleType = "one_time"
+	// Custom cycles renew every IntervalDays days.
+	Custom BillingCycleType = "custom"
+)
+
+// Granularity is the size of the buckets of a forecast.
+type Granularity string
+
+const (
+	GranularityDay   Granularity = "day"
+	GranularityWeek  Granularity = "week"
+	GranularityMonth Granularity = "month"
 )
 
 // === API Requests ===
@@ -41,9 +93,54 @@ type CreateSubscriptionRequest struct {
 	StartDate    *time.Time       `json:"start_date" form:"start_date"`
 	EndDate      *time.Time       `json:"end_date" form:"end_date"`
 	Price        float64          `json:"price" form:"price"`
+	Currency     string           `json:"currency" form:"currency"`
 	LogoUrl      string           `json:"logo_url" form:"logo_url"`
 	BillingCycle BillingCycleType `json:"billing_cycle" form:"billing_cycle"`
+	IntervalDays *int             `json:"interval_days" form:"interval_days"`
 	IsRecuring   bool             `json:"is_recuring" form:"is_recuring"`
+	// TrialEndsAt starts the subscription with a free trial. PriceAfterTrial,
+	// when set, replaces Price as the amount charged once it ends.
+	TrialEndsAt     *time.Time `json:"trial_ends_at" form:"trial_ends_at"`
+	PriceAfterTrial *float64   `json:"price_after_trial" form:"price_after_trial"`
+}
+
+// maxNameLength matches the size of the subscriptions.name column.
+const maxNameLength = 30
+
+func (r CreateSubscriptionRequest) Validate() error {
+	if r.Name == "" || utf8.RuneCountInString(r.Name) > maxNameLength {
+		return ErrInvalidName
+	}
+	if !isValidBillingCycle(r.BillingCycle) {
+		return ErrInvalidBillingCycle
+	}
+	if r.BillingCycle == Custom && (r.IntervalDays == nil || *r.IntervalDays <= 0) {
+		return ErrInvalidIntervalDays
+	}
+	if r.Price < 0 {
+		return ErrInvalidPrice
+	}
+	if r.Currency != "" && !utils.ValidateCurrency(r.Currency) {
+		return ErrInvalidCurrency
+	}
+	if r.StartDate == nil {
+		return ErrStartDateRequired
+	}
+	if r.EndDate != nil && r.EndDate.Before(*r.StartDate) {
+		return ErrEndBeforeStart
+	}
+	if r.TrialEndsAt != nil && !r.TrialEndsAt.After(*r.StartDate) {
+		return ErrInvalidTrialEnd
+	}
+	if r.PriceAfterTrial != nil {
+		if r.TrialEndsAt == nil {
+			return ErrTrialEndRequired
+		}
+		if *r.PriceAfterTrial < 0 {
+			return ErrInvalidPrice
+		}
+	}
+	return nil
 }
 
 type UpdateSubscriptionRequest struct {
@@ -54,13 +151,115 @@ type UpdateSubscriptionRequest struct {
 	StartDate   *time.Time `json:"start_date,omitempty" form:"start_date"`
 	EndDate     *time.Time `json:"end_date,omitempty" form:"end_date"`
 	Price       *float64   `json:"price,omitempty" form:"price"`
-	IsActive    *bool      `json:"is_active,omitempty" form:"is_active"`
-	IsRecuring  *bool      `json:"is_recuring,omitempty" form:"is_recuring"`
+	Currency    *string    `json:"currency,omitempty" form:"currency"`
+	// PriceEffectiveFrom is the day a new price takes effect, today if unset.
+	PriceEffectiveFrom *time.Time `json:"price_effective_from,omitempty" form:"price_effective_from"`
+	IsActive           *bool      `json:"is_active,omitempty" form:"is_active"`
+	IsRecuring         *bool      `json:"is_recuring,omitempty" form:"is_recuring"`
+	TrialEndsAt        *time.Time `json:"trial_ends_at,omitempty" form:"trial_ends_at"`
+	PriceAfterTrial    *float64   `json:"price_after_trial,omitempty" form:"price_after_trial"`
 }
 
 // === API Response ===
 
+// Charge is a single billing event of a subscription. Amount is the price
+// that applied on the charge date, ConvertedAmount its display value.
+type Charge struct {
+	*Subscription
+	ChargeDate      time.Time `json:"charge_date"`
+	Amount          float64   `json:"amount"`
+	ConvertedAmount float64   `json:"converted_amount"`
+	// TrialConversion flags the first paid charge of a free trial when it
+	// falls within the trial window asked for.
+	TrialConversion bool `json:"trial_conversion,omitempty"`
+}
+
+// PriceChange is a change of price of a subscription.
+type PriceChange struct {
+	SubscriptionId string    `json:"subscription_id"`
+	Name           string    `json:"name"`
+	Currency       string    `json:"currency"`
+	OldPrice       float64   `json:"old_price"`
+	NewPrice       float64   `json:"new_price"`
+	DeltaPercent   *float64  `json:"delta_percent"`
+	EffectiveFrom  time.Time `json:"effective_from"`
+}
+
 type SubscriptionCategoryCount struct {
-	Category string `json:"category"`
-	Count    int    `json:"count"`
+	Category          string            `json:"category"`
+	Count             int               `json:"count"`
+	MonthlyEquivalent float64           `json:"monthly_equivalent"`
+	Currency          string            `json:"currency"`
+	Original          []*CurrencyAmount `json:"original"`
+}
+
+// CurrencyAmount is an amount in its original currency, before conversion.
+type CurrencyAmount struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// SpendingSummary is the amount charged over a period along with the
+// normalized monthly and yearly cost of the subscriptions running in it.
+type SpendingSummary struct {
+	From              time.Time         `json:"from"`
+	To                time.Time         `json:"to"`
+	Currency          string            `json:"currency"`
+	Total             float64           `json:"total"`
+	MonthlyEquivalent float64           `json:"monthly_equivalent"`
+	YearlyEquivalent  float64           `json:"yearly_equivalent"`
+	ByBillingCycle    []*CycleSpending  `json:"by_billing_cycle"`
+	Original          []*CurrencyAmount `json:"original"`
+}
+
+type CycleSpending struct {
+	BillingCycle      BillingCycleType `json:"billing_cycle"`
+	Count             int              `json:"count"`
+	Total             float64          `json:"total"`
+	MonthlyEquivalent float64          `json:"monthly_equivalent"`
+	YearlyEquivalent  float64          `json:"yearly_equivalent"`
+}
+
+// Forecast is the projection of the charges of a user over a period.
+type Forecast struct {
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Granularity Granularity       `json:"granularity"`
+	Currency    string            `json:"currency"`
+	Total       float64           `json:"total"`
+	Buckets     []*ForecastBucket `json:"buckets"`
+}
+
+type ForecastBucket struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Total   float64   `json:"total"`
+	Charges []*Charge `json:"charges"`
+}
+
+// ImportResult reports the outcome of an import, row by row.
+type ImportResult struct {
+	DryRun     bool               `json:"dry_run"`
+	Total      int                `json:"total"`
+	Created    int                `json:"created"`
+	Valid      int                `json:"valid"`
+	Invalid    int                `json:"invalid"`
+	Duplicates int                `json:"duplicates"`
+	Rows       []*ImportRowResult `json:"rows"`
+}
+
+type ImportRowStatus string
+
+const (
+	ImportRowValid     ImportRowStatus = "valid"
+	ImportRowCreated   ImportRowStatus = "created"
+	ImportRowInvalid   ImportRowStatus = "invalid"
+	ImportRowDuplicate ImportRowStatus = "duplicate"
+)
+
+type ImportRowResult struct {
+	Row    int             `json:"row"`
+	Name   string          `json:"name"`
+	Status ImportRowStatus `json:"status"`
+	Error  string          `json:"error,omitempty"`
 }
@@ -0,0 +1,61 @@
+package subscriptions
+
+import (
+	"sort"
+	"time"
+)
+
+// PriceAt returns the price that applied on day. Days of a free trial cost
+// nothing and days before the first known price use that first price.
+func (s *Subscription) PriceAt(day time.Time) float64 {
+	day = truncateDay(day)
+	if s.InTrial(day) {
+		return 0
+	}
+	if len(s.PriceHistory) == 0 {
+		return s.Price
+	}
+
+	price := s.PriceHistory[0].Price
+	for _, point := range s.PriceHistory {
+		if truncateDay(point.EffectiveFrom).After(day) {
+			break
+		}
+		price = point.Price
+	}
+	return price
+}
+
+// PriceChanges lists the successive price changes found in the history of
+// subs, most recent first. The delta is left empty when the currency changed
+// or the previous price was zero.
+func PriceChanges(subs []*Subscription) []*PriceChange {
+	changes := []*PriceChange{}
+	for _, sub := range subs {
+		for i := 1; i < len(sub.PriceHistory); i++ {
+			prev, curr := sub.PriceHistory[i-1], sub.PriceHistory[i]
+			if prev.Price == curr.Price && prev.Currency == curr.Currency {
+				continue
+			}
+
+			change := &PriceChange{
+				SubscriptionId: sub.Id,
+				Name:           sub.Name,
+				Currency:       curr.Currency,
+				OldPrice:       prev.Price,
+				NewPrice:       curr.Price,
+				EffectiveFrom:  curr.EffectiveFrom,
+			}
+			if prev.Currency == curr.Currency && prev.Price != 0 {
+				delta := roundCents((curr.Price - prev.Price) / prev.Price * 100)
+				change.DeltaPercent = &delta
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].EffectiveFrom.After(changes[j].EffectiveFrom)
+	})
+	return changes
+}
@@ -0,0 +1,37 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChargesUseHistoricalPrice(t *testing.T) {
+	sub := &Subscription{
+		Id:           "sub",
+		Name:         "video",
+		StartDate:    date(2025, time.January, 15),
+		Price:        15,
+		Currency:     "EUR",
+		BillingCycle: Monthly,
+		PriceHistory: []*PricePoint{
+			{Price: 12, Currency: "EUR", EffectiveFrom: date(2025, time.January, 15)},
+			{Price: 15, Currency: "EUR", EffectiveFrom: date(2025, time.March, 1)},
+		},
+	}
+
+	charges := ChargesInRange([]*Subscription{sub}, date(2025, time.January, 1), date(2025, time.April, 1))
+	if assert.Len(t, charges, 3) {
+		assert.Equal(t, 12.0, charges[0].Amount)
+		assert.Equal(t, 12.0, charges[1].Amount)
+		assert.Equal(t, 15.0, charges[2].Amount)
+	}
+
+	changes := PriceChanges([]*Subscription{sub})
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, 12.0, changes[0].OldPrice)
+		assert.Equal(t, 15.0, changes[0].NewPrice)
+		assert.Equal(t, 25.0, *changes[0].DeltaPercent)
+	}
+}
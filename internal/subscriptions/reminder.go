@@ -0,0 +1,168 @@
+package subscriptions
+
+import (
+	"context"
+	"figenn/internal/mailer"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReminderRecipient is a user who wants to be told about upcoming charges
+// LeadDays days in advance.
+type ReminderRecipient struct {
+	UserID    string
+	Email     string
+	FirstName string
+	LeadDays  int
+}
+
+// Reminder is an upcoming charge a user should be reminded of.
+type Reminder struct {
+	Subscription *Subscription
+	ChargeDate   time.Time
+	Amount       float64
+}
+
+type ReminderPreferences struct {
+	LeadDays int `json:"lead_days"`
+}
+
+// DueReminders returns the charges of subs falling within the next leadDays
+// days, today excluded. Using a window rather than the exact day means
+// charges are still reminded after the scheduler missed a day; the caller is
+// responsible for not sending them twice.
+func DueReminders(subs []*Subscription, leadDays int, today time.Time) []*Reminder {
+	if leadDays <= 0 {
+		return nil
+	}
+	from := truncateDay(today).AddDate(0, 0, 1)
+	to := from.AddDate(0, 0, leadDays)
+
+	charges := ChargesInRange(subs, from, to)
+	reminders := make([]*Reminder, len(charges))
+	for i, charge := range charges {
+		reminders[i] = &Reminder{Subscription: charge.Subscription, ChargeDate: charge.ChargeDate, Amount: charge.Amount}
+	}
+	return reminders
+}
+
+// ReminderService sends renewal reminders by email.
+type ReminderService struct {
+	r      *Repository
+	mailer mailer.Mailer
+}
+
+func NewReminderService(repo *Repository, mailerClient mailer.Mailer) *ReminderService {
+	return &ReminderService{r: repo, mailer: mailerClient}
+}
+
+// SendRenewalReminders emails every user the charges due within their lead
+// time. Each charge is claimed in the database before the email goes out, so
+// a reminder is never sent twice, even across restarts or replicas. Claims
+// are released when sending fails so the next run retries them.
+func (s *ReminderService) SendRenewalReminders(ctx context.Context, now time.Time) error {
+	recipients, err := s.r.GetReminderRecipients(ctx)
+	if err != nil {
+		return err
+	}
+
+	today := truncateDay(now)
+	sent := 0
+	for _, recipient := range recipients {
+		n, err := s.remindUser(ctx, recipient, today)
+		if err != nil {
+			log.Printf("failed to send renewal reminders to user %s: %v", recipient.UserID, err)
+			continue
+		}
+		sent += n
+	}
+	if sent > 0 {
+		log.Printf("sent %d renewal reminders", sent)
+	}
+	return nil
+}
+
+func (s *ReminderService) remindUser(ctx context.Context, recipient *ReminderRecipient, today time.Time) (int, error) {
+	from := today.AddDate(0, 0, 1)
+	to := from.AddDate(0, 0, recipient.LeadDays)
+	subs, err := s.r.GetSubscriptionsInRange(ctx, recipient.UserID, from, to)
+	if err != nil {
+		return 0, err
+	}
+	if len(subs) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, len(subs))
+	for i, sub := range subs {
+		ids[i] = sub.Id
+	}
+	history, err := s.r.GetPriceHistory(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	for _, sub := range subs {
+		sub.PriceHistory = history[sub.Id]
+	}
+
+	var claimed []*Reminder
+	for _, reminder := range DueReminders(subs, recipient.LeadDays, today) {
+		ok, err := s.r.ClaimReminder(ctx, reminder.Subscription.Id, reminder.ChargeDate)
+		if err != nil {
+			s.release(ctx, claimed)
+			return 0, err
+		}
+		if ok {
+			claimed = append(claimed, reminder)
+		}
+	}
+	if len(claimed) == 0 {
+		return 0, nil
+	}
+
+	_, err = s.mailer.SendMail(ctx, mailer.Config{
+		To:      recipient.Email,
+		Subject: reminderSubject(claimed),
+		Html:    reminderHTML(recipient.FirstName, claimed),
+	})
+	if err != nil {
+		s.release(ctx, claimed)
+		return 0, err
+	}
+	return len(claimed), nil
+}
+
+func (s *ReminderService) release(ctx context.Context, reminders []*Reminder) {
+	for _, reminder := range reminders {
+		if err := s.r.ReleaseReminder(ctx, reminder.Subscription.Id, reminder.ChargeDate); err != nil {
+			log.Printf("failed to release reminder of subscription %s: %v", reminder.Subscription.Id, err)
+		}
+	}
+}
+
+func reminderSubject(reminders []*Reminder) string {
+	if len(reminders) == 1 {
+		return reminders[0].Subscription.Name + " renews on " + reminders[0].ChargeDate.Format("January 2")
+	}
+	return strconv.Itoa(len(reminders)) + " subscriptions renew soon"
+}
+
+func reminderHTML(firstName string, reminders []*Reminder) string {
+	var b strings.Builder
+	b.WriteString("<p>Hello " + html.EscapeString(firstName) + ",</p>")
+	b.WriteString("<p>The following subscriptions will renew soon:</p><ul>")
+	for _, reminder := range reminders {
+		fmt.Fprintf(&b, "<li>%s: %.2f %s on %s</li>",
+			html.EscapeString(reminder.Subscription.Name),
+			reminder.Amount,
+			html.EscapeString(reminder.Subscription.Currency),
+			reminder.ChargeDate.Format("Monday, January 2"),
+		)
+	}
+	b.WriteString("</ul><p>You can change when you receive these reminders in your settings.</p>")
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDueReminders(t *testing.T) {
+	today := date(2025, time.April, 28)
+	subs := []*Subscription{
+		{Id: "netflix", StartDate: date(2025, time.January, 30), BillingCycle: Monthly, Price: 13.49},
+		{Id: "gym", StartDate: date(2025, time.March, 1), BillingCycle: Monthly, Price: 30},
+		{Id: "today", StartDate: date(2025, time.February, 28), BillingCycle: Monthly, Price: 5},
+	}
+
+	reminders := DueReminders(subs, 3, today)
+	assert.Len(t, reminders, 2)
+	assert.Equal(t, "netflix", reminders[0].Subscription.Id)
+	assert.Equal(t, date(2025, time.April, 30), reminders[0].ChargeDate)
+	assert.Equal(t, 13.49, reminders[0].Amount)
+	assert.Equal(t, "gym", reminders[1].Subscription.Id)
+	assert.Equal(t, date(2025, time.May, 1), reminders[1].ChargeDate)
+
+	assert.Len(t, DueReminders(subs, 2, today), 1)
+	assert.Empty(t, DueReminders(subs, 0, today))
+}
+
+func TestReminderHTMLEscapesNames(t *testing.T) {
+	reminders := []*Reminder{{Subscription: &Subscription{Name: "<b>Netflix</b>", Currency: "EUR"}, ChargeDate: date(2025, time.April, 30), Amount: 13.49}}
+
+	body := reminderHTML("Ana", reminders)
+	assert.Contains(t, body, "&lt;b&gt;Netflix&lt;/b&gt;: 13.49 EUR on Wednesday, April 30")
+	assert.Equal(t, "<b>Netflix</b> renews on April 30", reminderSubject(reminders))
+}
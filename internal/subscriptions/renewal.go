@@ -0,0 +1,167 @@
+package subscriptions
+
+import (
+	"sort"
+	"time"
+)
+
+// ChargeDates returns every date in [from, to) on which sub is charged.
+// Dates are computed from the billing start so month-end anchors are kept:
+// a subscription started on Jan 31 is charged on Feb 28 (or 29), then Mar 31.
+// The end date, when set, is the last day a charge can happen.
+func ChargeDates(sub *Subscription, from, to time.Time) []time.Time {
+	from, to = truncateDay(from), truncateDay(to)
+	if sub == nil || !from.Before(to) {
+		return nil
+	}
+
+	var dates []time.Time
+	for n := firstOccurrenceIndex(sub, from); ; n++ {
+		date, ok := occurrence(sub, n)
+		if !ok || !date.Before(to) {
+			break
+		}
+		if sub.EndDate != nil && date.After(truncateDay(*sub.EndDate)) {
+			break
+		}
+		if !date.Before(from) {
+			dates = append(dates, date)
+		}
+	}
+	return dates
+}
+
+// NextChargeDate returns the first charge of sub on or after the given day.
+func NextChargeDate(sub *Subscription, after time.Time) (time.Time, bool) {
+	after = truncateDay(after)
+	for n := firstOccurrenceIndex(sub, after); ; n++ {
+		date, ok := occurrence(sub, n)
+		if !ok {
+			return time.Time{}, false
+		}
+		if sub.EndDate != nil && date.After(truncateDay(*sub.EndDate)) {
+			return time.Time{}, false
+		}
+		if !date.Before(after) {
+			return date, true
+		}
+	}
+}
+
+// ChargesInRange expands subs into their individual charges in [from, to),
+// ordered by date. Each charge carries the price that applied on its date.
+func ChargesInRange(subs []*Subscription, from, to time.Time) []*Charge {
+	var charges []*Charge
+	for _, sub := range subs {
+		for _, date := range ChargeDates(sub, from, to) {
+			amount := sub.PriceAt(date)
+			charges = append(charges, &Charge{
+				Subscription:    sub,
+				ChargeDate:      date,
+				Amount:          amount,
+				ConvertedAmount: sub.toDisplay(amount),
+			})
+		}
+	}
+	sort.SliceStable(charges, func(i, j int) bool {
+		return charges[i].ChargeDate.Before(charges[j].ChargeDate)
+	})
+	return charges
+}
+
+// occurrence returns the n-th charge date (0 being the billing start).
+// The boolean is false when the cycle has no n-th charge.
+func occurrence(sub *Subscription, n int) (time.Time, bool) {
+	start := billingStart(sub)
+	if n < 0 {
+		return time.Time{}, false
+	}
+
+	if months := cycleMonths(sub.BillingCycle); months > 0 {
+		return addMonthsClamped(start, n*months), true
+	}
+	if days := cycleDays(sub); days > 0 {
+		return start.AddDate(0, 0, n*days), true
+	}
+	if sub.BillingCycle == OneTime && n == 0 {
+		return start, true
+	}
+	return time.Time{}, false
+}
+
+// firstOccurrenceIndex skips the occurrences that are known to fall before
+// day, so long-running subscriptions don't get replayed from their start.
+func firstOccurrenceIndex(sub *Subscription, day time.Time) int {
+	start := billingStart(sub)
+	if !day.After(start) {
+		return 0
+	}
+
+	n := 0
+	if months := cycleMonths(sub.BillingCycle); months > 0 {
+		elapsed := (day.Year()-start.Year())*12 + int(day.Month()-start.Month())
+		n = elapsed/months - 1
+	} else if days := cycleDays(sub); days > 0 {
+		elapsed := int(day.Sub(start).Hours() / 24)
+		n = elapsed/days - 1
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// billingStart returns the first charge date of sub: the end of its free
+// trial when it has one, its start date otherwise.
+func billingStart(sub *Subscription) time.Time {
+	start := truncateDay(sub.StartDate)
+	if sub.TrialEndsAt != nil && sub.TrialEndsAt.After(start) {
+		return truncateDay(*sub.TrialEndsAt)
+	}
+	return start
+}
+
+func cycleMonths(cycle BillingCycleType) int {
+	switch cycle {
+	case Monthly:
+		return 1
+	case Quarterly:
+		return 3
+	case SemiAnnual:
+		return 6
+	case Annual:
+		return 12
+	default:
+		return 0
+	}
+}
+
+func cycleDays(sub *Subscription) int {
+	switch sub.BillingCycle {
+	case Weekly:
+		return 7
+	case Biweekly:
+		return 14
+	case Custom:
+		if sub.IntervalDays != nil && *sub.IntervalDays > 0 {
+			return *sub.IntervalDays
+		}
+	}
+	return 0
+}
+
+// addMonthsClamped adds months to t, clamping the day to the last day of the
+// target month instead of overflowing into the next one like time.AddDate.
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(firstOfMonth.Year(), firstOfMonth.Month(), day, 0, 0, 0, 0, time.UTC)
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
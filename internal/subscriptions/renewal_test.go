@@ -0,0 +1,132 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestChargeDates(t *testing.T) {
+	tenDays := 10
+	endDate := date(2025, time.March, 15)
+
+	tests := []struct {
+		name     string
+		sub      *Subscription
+		from, to time.Time
+		expected []time.Time
+	}{
+		{
+			name:     "monthly clamps to month end",
+			sub:      &Subscription{StartDate: date(2025, time.January, 31), BillingCycle: Monthly},
+			from:     date(2025, time.January, 1),
+			to:       date(2025, time.May, 1),
+			expected: []time.Time{date(2025, time.January, 31), date(2025, time.February, 28), date(2025, time.March, 31), date(2025, time.April, 30)},
+		},
+		{
+			name:     "monthly clamps to leap day",
+			sub:      &Subscription{StartDate: date(2023, time.December, 31), BillingCycle: Monthly},
+			from:     date(2024, time.February, 1),
+			to:       date(2024, time.March, 1),
+			expected: []time.Time{date(2024, time.February, 29)},
+		},
+		{
+			name:     "weekly",
+			sub:      &Subscription{StartDate: date(2025, time.March, 3), BillingCycle: Weekly},
+			from:     date(2025, time.March, 1),
+			to:       date(2025, time.March, 20),
+			expected: []time.Time{date(2025, time.March, 3), date(2025, time.March, 10), date(2025, time.March, 17)},
+		},
+		{
+			name:     "biweekly skips ahead",
+			sub:      &Subscription{StartDate: date(2024, time.January, 1), BillingCycle: Biweekly},
+			from:     date(2025, time.January, 1),
+			to:       date(2025, time.January, 31),
+			expected: []time.Time{date(2025, time.January, 13), date(2025, time.January, 27)},
+		},
+		{
+			name:     "quarterly",
+			sub:      &Subscription{StartDate: date(2024, time.November, 30), BillingCycle: Quarterly},
+			from:     date(2025, time.January, 1),
+			to:       date(2026, time.January, 1),
+			expected: []time.Time{date(2025, time.February, 28), date(2025, time.May, 30), date(2025, time.August, 30), date(2025, time.November, 30)},
+		},
+		{
+			name:     "semi annual",
+			sub:      &Subscription{StartDate: date(2024, time.August, 31), BillingCycle: SemiAnnual},
+			from:     date(2025, time.January, 1),
+			to:       date(2026, time.January, 1),
+			expected: []time.Time{date(2025, time.February, 28), date(2025, time.August, 31)},
+		},
+		{
+			name:     "annual from leap day",
+			sub:      &Subscription{StartDate: date(2024, time.February, 29), BillingCycle: Annual},
+			from:     date(2025, time.January, 1),
+			to:       date(2029, time.January, 1),
+			expected: []time.Time{date(2025, time.February, 28), date(2026, time.February, 28), date(2027, time.February, 28), date(2028, time.February, 29)},
+		},
+		{
+			name:     "one time inside range",
+			sub:      &Subscription{StartDate: date(2025, time.June, 12), BillingCycle: OneTime},
+			from:     date(2025, time.June, 1),
+			to:       date(2025, time.July, 1),
+			expected: []time.Time{date(2025, time.June, 12)},
+		},
+		{
+			name: "one time outside range",
+			sub:  &Subscription{StartDate: date(2025, time.May, 12), BillingCycle: OneTime},
+			from: date(2025, time.June, 1),
+			to:   date(2025, time.July, 1),
+		},
+		{
+			name:     "custom interval",
+			sub:      &Subscription{StartDate: date(2025, time.January, 1), BillingCycle: Custom, IntervalDays: &tenDays},
+			from:     date(2025, time.January, 15),
+			to:       date(2025, time.February, 5),
+			expected: []time.Time{date(2025, time.January, 21), date(2025, time.January, 31)},
+		},
+		{
+			name: "custom without interval",
+			sub:  &Subscription{StartDate: date(2025, time.January, 1), BillingCycle: Custom},
+			from: date(2025, time.January, 1),
+			to:   date(2025, time.February, 1),
+		},
+		{
+			name:     "end date is inclusive",
+			sub:      &Subscription{StartDate: date(2025, time.January, 15), EndDate: &endDate, BillingCycle: Monthly},
+			from:     date(2025, time.January, 1),
+			to:       date(2025, time.December, 1),
+			expected: []time.Time{date(2025, time.January, 15), date(2025, time.February, 15), date(2025, time.March, 15)},
+		},
+		{
+			name: "not started yet",
+			sub:  &Subscription{StartDate: date(2025, time.July, 1), BillingCycle: Monthly},
+			from: date(2025, time.June, 1),
+			to:   date(2025, time.July, 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ChargeDates(tt.sub, tt.from, tt.to))
+		})
+	}
+}
+
+func TestNextChargeDate(t *testing.T) {
+	sub := &Subscription{StartDate: date(2025, time.January, 31), BillingCycle: Monthly}
+
+	next, ok := NextChargeDate(sub, date(2025, time.February, 1))
+	assert.True(t, ok)
+	assert.Equal(t, date(2025, time.February, 28), next)
+
+	endDate := date(2025, time.February, 1)
+	sub.EndDate = &endDate
+	_, ok = NextChargeDate(sub, date(2025, time.February, 1))
+	assert.False(t, ok)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
 )
 
 type Repository struct {
@@ -18,23 +19,72 @@ func NewRepository(db database.DbService) *Repository {
 	return &Repository{db: db}
 }
 
-func (r *Repository) CreateSubscription(ctx context.Context, sub *Subscription) error {
-	query, args, err := squirrel.Insert("subscriptions").
-		Columns("user_id", "name", "category", "color", "description", "start_date", "end_date", "price", "logo_url", "billing_cycle", "is_active").
-		Values(sub.UserId, sub.Name, sub.Category, sub.Color, sub.Description, sub.StartDate, sub.EndDate, sub.Price, sub.LogoUrl, sub.BillingCycle, sub.IsActive).
-		Suffix("RETURNING id").
+var subscriptionColumns = []string{
+	"id", "user_id", "name", "category", "color", "description", "start_date", "end_date", "price", "currency",
+	"logo_url", "is_active", "billing_cycle", "interval_days", "trial_ends_at", "price_after_trial",
+}
+
+func scanSubscription(row pgx.Row) (*Subscription, error) {
+	sub := new(Subscription)
+	err := row.Scan(
+		&sub.Id, &sub.UserId, &sub.Name, &sub.Category, &sub.Color, &sub.Description, &sub.StartDate, &sub.EndDate,
+		&sub.Price, &sub.Currency, &sub.LogoUrl, &sub.IsActive, &sub.BillingCycle, &sub.IntervalDays,
+		&sub.TrialEndsAt, &sub.PriceAfterTrial,
+	)
+	return sub, err
+}
+
+// GetUserSubscriptions returns every subscription of a user.
+func (r *Repository) GetUserSubscriptions(ctx context.Context, userID string) ([]*Subscription, error) {
+	query, args, err := squirrel.Select(subscriptionColumns...).
+		From("subscriptions").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("start_date ASC", "name ASC").
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
-		return errors.New("failed to build insert query")
+		return nil, errors.New("failed to build select query")
 	}
-	return r.db.Pool().QueryRow(ctx, query, args...).Scan(&sub.Id)
+	return r.querySubscriptions(ctx, query, args...)
+}
+
+// CreateSubscription inserts sub along with its initial price, effective
+// from its start date.
+func (r *Repository) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	return r.CreateSubscriptions(ctx, []*Subscription{sub})
+}
+
+// CreateSubscriptions inserts subs and their initial prices in a single
+// transaction: either all of them are created or none is.
+func (r *Repository) CreateSubscriptions(ctx context.Context, subs []*Subscription) error {
+	tx, err := r.db.Pool().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, sub := range subs {
+		query, args, err := squirrel.Insert("subscriptions").
+			Columns("user_id", "name", "category", "color", "description", "start_date", "end_date", "price", "currency", "logo_url", "billing_cycle", "interval_days", "trial_ends_at", "price_after_trial", "is_active").
+			Values(sub.UserId, sub.Name, sub.Category, sub.Color, sub.Description, sub.StartDate, sub.EndDate, sub.Price, sub.Currency, sub.LogoUrl, sub.BillingCycle, sub.IntervalDays, sub.TrialEndsAt, sub.PriceAfterTrial, sub.IsActive).
+			Suffix("RETURNING id").
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return errors.New("failed to build insert query")
+		}
+		if err := tx.QueryRow(ctx, query, args...).Scan(&sub.Id); err != nil {
+			return err
+		}
+		if err := recordPrice(ctx, tx, sub.Id, sub.StartDate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
 
 func (r *Repository) GetAllSubscriptions(ctx context.Context, userID string, limit, offset int) ([]*Subscription, error) {
-	query, args, err := squirrel.Select(
-		"id", "user_id", "name", "category", "color", "description", "start_date", "end_date", "price", "logo_url", "is_active", "billing_cycle",
-	).
+	query, args, err := squirrel.Select(subscriptionColumns...).
 		From("subscriptions").
 		Where(squirrel.Eq{"user_id": userID}).
 		Limit(uint64(limit)).
@@ -44,24 +94,7 @@ func (r *Repository) GetAllSubscriptions(ctx context.Context, userID string, lim
 	if err != nil {
 		return nil, errors.New("failed to build select query")
 	}
-	rows, err := r.db.Pool().Query(ctx, query, args...)
-	if err != nil {
-		return nil, errors.New("failed to execute query")
-	}
-	defer rows.Close()
-	var subscriptions []*Subscription
-	for rows.Next() {
-		sub := new(Subscription)
-		err := rows.Scan(
-			&sub.Id, &sub.UserId, &sub.Name, &sub.Category, &sub.Color, &sub.Description, &sub.StartDate,
-			&sub.EndDate, &sub.Price, &sub.LogoUrl, &sub.IsActive, &sub.BillingCycle,
-		)
-		if err != nil {
-			return nil, errors.New("failed to scan row")
-		}
-		subscriptions = append(subscriptions, sub)
-	}
-	return subscriptions, rows.Err()
+	return r.querySubscriptions(ctx, query, args...)
 }
 
 func (r *Repository) DeleteSubscription(ctx context.Context, userID, subID string) error {
@@ -76,7 +109,9 @@ func (r *Repository) DeleteSubscription(ctx context.Context, userID, subID strin
 	return err
 }
 
-func (r *Repository) UpdateSubscription(ctx context.Context, userID, subID string, fields map[string]interface{}) error {
+// UpdateSubscription applies fields to a subscription. When priceEffectiveFrom
+// is set, the resulting price is recorded in the price history from that day.
+func (r *Repository) UpdateSubscription(ctx context.Context, userID, subID string, fields map[string]interface{}, priceEffectiveFrom *time.Time) error {
 	query, args, err := squirrel.Update("subscriptions").
 		SetMap(fields).
 		Where(squirrel.Eq{"user_id": userID, "id": subID}).
@@ -85,14 +120,115 @@ func (r *Repository) UpdateSubscription(ctx context.Context, userID, subID strin
 	if err != nil {
 		return errors.New("failed to build update query")
 	}
-	_, err = r.db.Pool().Exec(ctx, query, args...)
+
+	tx, err := r.db.Pool().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrSubscriptionNotFound
+	}
+	if priceEffectiveFrom != nil {
+		if err := recordPrice(ctx, tx, subID, *priceEffectiveFrom); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// recordPrice copies the current price of a subscription into its history.
+// A price already recorded for the same day is replaced.
+func recordPrice(ctx context.Context, tx pgx.Tx, subID string, effectiveFrom time.Time) error {
+	query := `
+		INSERT INTO subscription_price_history (subscription_id, price, currency, effective_from)
+		SELECT id, price, currency, $2 FROM subscriptions WHERE id = $1
+		ON CONFLICT (subscription_id, effective_from)
+		DO UPDATE SET price = EXCLUDED.price, currency = EXCLUDED.currency
+	`
+	_, err := tx.Exec(ctx, query, subID, effectiveFrom)
 	return err
 }
 
+// GetPriceHistory returns the price history of each given subscription,
+// ordered by effective date.
+func (r *Repository) GetPriceHistory(ctx context.Context, subIDs []string) (map[string][]*PricePoint, error) {
+	history := make(map[string][]*PricePoint)
+	if len(subIDs) == 0 {
+		return history, nil
+	}
+
+	query, args, err := squirrel.Select("subscription_id", "price", "currency", "effective_from").
+		From("subscription_price_history").
+		Where(squirrel.Eq{"subscription_id": subIDs}).
+		OrderBy("subscription_id", "effective_from ASC").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, errors.New("failed to build price history query")
+	}
+
+	rows, err := r.db.Pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, errors.New("failed to execute price history query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subID string
+		point := new(PricePoint)
+		if err := rows.Scan(&subID, &point.Price, &point.Currency, &point.EffectiveFrom); err != nil {
+			return nil, errors.New("failed to scan price history row")
+		}
+		history[subID] = append(history[subID], point)
+	}
+	return history, rows.Err()
+}
+
+// GetUserPriceHistory returns every subscription of a user that has a price
+// history, with only its id, name and history filled.
+func (r *Repository) GetUserPriceHistory(ctx context.Context, userID string) ([]*Subscription, error) {
+	query, args, err := squirrel.Select("s.id", "s.name", "h.price", "h.currency", "h.effective_from").
+		From("subscription_price_history AS h").
+		InnerJoin("subscriptions AS s ON s.id = h.subscription_id").
+		Where(squirrel.Eq{"s.user_id": userID}).
+		OrderBy("s.id", "h.effective_from ASC").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, errors.New("failed to build price history query")
+	}
+
+	rows, err := r.db.Pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, errors.New("failed to execute price history query")
+	}
+	defer rows.Close()
+
+	var subscriptions []*Subscription
+	var current *Subscription
+	for rows.Next() {
+		var subID, name string
+		point := new(PricePoint)
+		if err := rows.Scan(&subID, &name, &point.Price, &point.Currency, &point.EffectiveFrom); err != nil {
+			return nil, errors.New("failed to scan price history row")
+		}
+		if current == nil || current.Id != subID {
+			current = &Subscription{Id: subID, Name: name}
+			subscriptions = append(subscriptions, current)
+		}
+		current.PriceHistory = append(current.PriceHistory, point)
+	}
+	return subscriptions, rows.Err()
+}
+
 func (r *Repository) GetSubscriptionByID(ctx context.Context, userID, subID string) (*Subscription, error) {
-	query, args, err := squirrel.Select(
-		"id", "user_id", "name", "category", "color", "description", "start_date", "price", "logo_url", "is_active", "billing_cycle",
-	).
+	query, args, err := squirrel.Select(subscriptionColumns...).
 		From("subscriptions").
 		Where(squirrel.Eq{"user_id": userID, "id": subID}).
 		PlaceholderFormat(squirrel.Dollar).
@@ -100,11 +236,7 @@ func (r *Repository) GetSubscriptionByID(ctx context.Context, userID, subID stri
 	if err != nil {
 		return nil, errors.New("failed to build select query")
 	}
-	sub := new(Subscription)
-	err = r.db.Pool().QueryRow(ctx, query, args...).Scan(
-		&sub.Id, &sub.UserId, &sub.Name, &sub.Category, &sub.Color, &sub.Description, &sub.StartDate, &sub.Price,
-		&sub.LogoUrl, &sub.IsActive, &sub.BillingCycle,
-	)
+	sub, err := scanSubscription(r.db.Pool().QueryRow(ctx, query, args...))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -114,129 +246,206 @@ func (r *Repository) GetSubscriptionByID(ctx context.Context, userID, subID stri
 	return sub, nil
 }
 
-func (r *Repository) GetSubscriptionsByCategory(ctx context.Context, userID string) ([]*SubscriptionCategoryCount, error) {
-	query, args, err := squirrel.Select("category", "COUNT(*) AS count").
+// GetActiveSubscriptions returns every subscription of a user flagged as
+// active, whatever its dates.
+func (r *Repository) GetActiveSubscriptions(ctx context.Context, userID string) ([]*Subscription, error) {
+	query, args, err := squirrel.Select(subscriptionColumns...).
 		From("subscriptions").
 		Where(squirrel.Eq{"user_id": userID, "is_active": true}).
-		GroupBy("category").
-		OrderBy("count DESC").
+		OrderBy("start_date ASC").
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
-		return nil, errors.New("failed to build category count query")
+		return nil, errors.New("failed to build select query")
 	}
-	rows, err := r.db.Pool().Query(ctx, query, args...)
+	return r.querySubscriptions(ctx, query, args...)
+}
+
+// GetUserCurrency returns the display currency chosen by the user.
+func (r *Repository) GetUserCurrency(ctx context.Context, userID string) (string, error) {
+	query, args, err := squirrel.Select("COALESCE(currency, 'EUR')").
+		From("users").
+		Where(squirrel.Eq{"id": userID}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
 	if err != nil {
-		return nil, errors.New("failed to execute category count query")
+		return "", errors.New("failed to build select query")
 	}
-	defer rows.Close()
-	var result []*SubscriptionCategoryCount
-	for rows.Next() {
-		item := new(SubscriptionCategoryCount)
-		if err := rows.Scan(&item.Category, &item.Count); err != nil {
-			return nil, errors.New("failed to scan category count row")
-		}
-		result = append(result, item)
+
+	var currency string
+	if err := r.db.Pool().QueryRow(ctx, query, args...).Scan(&currency); err != nil {
+		return "", errors.New("failed to fetch user currency")
 	}
-	return result, rows.Err()
+	return currency, nil
 }
 
-func (r *Repository) GetUpcomingSubscriptions(ctx context.Context, userID string, week int) ([]*Subscription, error) {
-	query, args, err := squirrel.Select("id", "user_id", "name", "start_date", "color", "logo_url", "price").
+// GetSubscriptionsInRange returns the active subscriptions of a user that may
+// be charged between from and to. The exact charge dates are left to the
+// renewal engine.
+func (r *Repository) GetSubscriptionsInRange(ctx context.Context, userID string, from, to time.Time) ([]*Subscription, error) {
+	query, args, err := squirrel.Select(subscriptionColumns...).
 		From("subscriptions").
 		Where(squirrel.Eq{"user_id": userID, "is_active": true}).
-		Where(squirrel.Expr("EXTRACT(WEEK FROM start_date) = ?", week)).
-		Where(squirrel.Expr("EXTRACT(YEAR FROM start_date) = ?", time.Now().Year())).
+		Where(squirrel.Lt{"start_date": to}).
+		Where(squirrel.Or{squirrel.Eq{"end_date": nil}, squirrel.GtOrEq{"end_date": from}}).
 		OrderBy("start_date ASC").
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
 		return nil, errors.New("failed to build select query")
 	}
+
+	return r.querySubscriptions(ctx, query, args...)
+}
+
+func (r *Repository) querySubscriptions(ctx context.Context, query string, args ...interface{}) ([]*Subscription, error) {
 	rows, err := r.db.Pool().Query(ctx, query, args...)
 	if err != nil {
 		return nil, errors.New("failed to execute query")
 	}
 	defer rows.Close()
+
 	var subscriptions []*Subscription
 	for rows.Next() {
-		sub := new(Subscription)
-		err := rows.Scan(&sub.Id, &sub.UserId, &sub.Name, &sub.StartDate, &sub.Color, &sub.LogoUrl, &sub.Price)
+		sub, err := scanSubscription(rows)
 		if err != nil {
-			return nil, errors.New("failed to scan row")
+			return nil, errors.New("failed to scan subscription row")
 		}
 		subscriptions = append(subscriptions, sub)
 	}
 	return subscriptions, rows.Err()
 }
 
-func (r *Repository) CalculateActiveSubscriptionPrice(ctx context.Context, userID string, year, month *int) (float64, error) {
-	q := squirrel.Select("COALESCE(SUM(price), 0)").
-		From("subscriptions").
-		Where(squirrel.Eq{"user_id": userID, "is_active": true})
+// SetCalendarToken stores the hash of the calendar feed token of a user,
+// replacing the previous one.
+func (r *Repository) SetCalendarToken(ctx context.Context, userID, tokenHash string) error {
+	query := `
+		INSERT INTO calendar_tokens (user_id, token_hash, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id)
+		DO UPDATE SET token_hash = $2, created_at = $3
+	`
+	_, err := r.db.Pool().Exec(ctx, query, userID, tokenHash, time.Now())
+	return err
+}
 
-	if year != nil {
-		q = q.Where(squirrel.Expr(
-			"EXTRACT(YEAR FROM start_date) <= ? AND (end_date IS NULL OR EXTRACT(YEAR FROM end_date) >= ?)",
-			*year, *year,
-		))
-	}
-	if month != nil {
-		q = q.Where(squirrel.Expr(
-			"EXTRACT(MONTH FROM start_date) <= ? AND (end_date IS NULL OR EXTRACT(MONTH FROM end_date) >= ?)",
-			*month, *month,
-		))
+func (r *Repository) DeleteCalendarToken(ctx context.Context, userID string) error {
+	query, args, err := squirrel.Delete("calendar_tokens").
+		Where(squirrel.Eq{"user_id": userID}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.New("failed to build delete query")
 	}
+	_, err = r.db.Pool().Exec(ctx, query, args...)
+	return err
+}
 
-	sqlQuery, args, err := q.PlaceholderFormat(squirrel.Dollar).ToSql()
+// GetUserIDByCalendarToken returns the owner of a calendar feed token hash.
+func (r *Repository) GetUserIDByCalendarToken(ctx context.Context, tokenHash string) (string, error) {
+	query, args, err := squirrel.Select("user_id").
+		From("calendar_tokens").
+		Where(squirrel.Eq{"token_hash": tokenHash}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
 	if err != nil {
-		return 0, errors.New("failed to build select query")
+		return "", errors.New("failed to build select query")
 	}
 
-	var total float64
-	err = r.db.Pool().QueryRow(ctx, sqlQuery, args...).Scan(&total)
+	var userID string
+	err = r.db.Pool().QueryRow(ctx, query, args...).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrInvalidCalendarToken
+	}
 	if err != nil {
-		return 0, errors.New("failed to execute query")
+		return "", errors.New("failed to execute query")
 	}
-	return total, nil
+	return userID, nil
 }
 
-func (r *Repository) GetActiveSubscriptions(ctx context.Context, userID string, year, month int) ([]*Subscription, error) {
-	query := `
-		SELECT id, user_id, name, category, color, description, start_date, end_date, price,
-			   logo_url, is_active, billing_cycle
-		FROM subscriptions
-		WHERE user_id = $1
-		AND is_active = TRUE
-		AND (
-			(EXTRACT(YEAR FROM start_date) = $2 AND EXTRACT(MONTH FROM start_date) = $3)
-			OR (end_date IS NOT NULL AND EXTRACT(YEAR FROM end_date) = $2 AND EXTRACT(MONTH FROM end_date) = $3)
-			OR (billing_cycle = 'monthly' AND start_date <= DATE($2 || '-' || $3 || '-01'))
-			OR (billing_cycle = 'quarterly' AND start_date <= DATE($2 || '-' || $3 || '-01') AND MOD(EXTRACT(MONTH FROM start_date) - $3 + 12 * (EXTRACT(YEAR FROM start_date) - $2), 3) = 0)
-			OR (billing_cycle = 'semi_annual' AND start_date <= DATE($2 || '-' || $3 || '-01') AND MOD(EXTRACT(MONTH FROM start_date) - $3 + 12 * (EXTRACT(YEAR FROM start_date) - $2), 6) = 0)
-			OR (billing_cycle = 'annual' AND start_date <= DATE($2 || '-' || $3 || '-01') AND EXTRACT(MONTH FROM start_date) = $3)
-			OR (billing_cycle = 'one_time' AND EXTRACT(YEAR FROM start_date) = $2 AND EXTRACT(MONTH FROM start_date) = $3)
-		)
-		ORDER BY start_date ASC`
-
-	rows, err := r.db.Pool().Query(ctx, query, userID, year, month)
-	if err != nil {
-		return nil, errors.New("failed to execute active subscriptions query")
+// GetReminderRecipients returns the users with renewal reminders enabled.
+func (r *Repository) GetReminderRecipients(ctx context.Context) ([]*ReminderRecipient, error) {
+	query, args, err := squirrel.Select("id", "email", "first_name", "reminder_lead_days").
+		From("users").
+		Where(squirrel.Gt{"reminder_lead_days": 0}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, errors.New("failed to build select query")
+	}
+
+	rows, err := r.db.Pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, errors.New("failed to execute query")
 	}
 	defer rows.Close()
 
-	var subscriptions []*Subscription
+	var recipients []*ReminderRecipient
 	for rows.Next() {
-		sub := new(Subscription)
-		err := rows.Scan(
-			&sub.Id, &sub.UserId, &sub.Name, &sub.Category, &sub.Color,
-			&sub.Description, &sub.StartDate, &sub.EndDate, &sub.Price,
-			&sub.LogoUrl, &sub.IsActive, &sub.BillingCycle,
-		)
-		if err != nil {
-			return nil, errors.New("failed to scan active subscription row")
+		recipient := new(ReminderRecipient)
+		if err := rows.Scan(&recipient.UserID, &recipient.Email, &recipient.FirstName, &recipient.LeadDays); err != nil {
+			return nil, errors.New("failed to scan reminder recipient row")
 		}
-		subscriptions = append(subscriptions, sub)
+		recipients = append(recipients, recipient)
 	}
-	return subscriptions, rows.Err()
+	return recipients, rows.Err()
+}
+
+// ClaimReminder records that the charge of a subscription on chargeDate is
+// being reminded. It returns false when it was already claimed.
+func (r *Repository) ClaimReminder(ctx context.Context, subID string, chargeDate time.Time) (bool, error) {
+	query := `
+		INSERT INTO subscription_reminders (subscription_id, charge_date, sent_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (subscription_id, charge_date) DO NOTHING
+	`
+	tag, err := r.db.Pool().Exec(ctx, query, subID, chargeDate, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleaseReminder forgets a claim so the reminder can be sent again.
+func (r *Repository) ReleaseReminder(ctx context.Context, subID string, chargeDate time.Time) error {
+	query, args, err := squirrel.Delete("subscription_reminders").
+		Where(squirrel.Eq{"subscription_id": subID, "charge_date": chargeDate}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.New("failed to build delete query")
+	}
+	_, err = r.db.Pool().Exec(ctx, query, args...)
+	return err
+}
+
+func (r *Repository) GetReminderLeadDays(ctx context.Context, userID string) (int, error) {
+	query, args, err := squirrel.Select("reminder_lead_days").
+		From("users").
+		Where(squirrel.Eq{"id": userID}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, errors.New("failed to build select query")
+	}
+
+	var leadDays int
+	if err := r.db.Pool().QueryRow(ctx, query, args...).Scan(&leadDays); err != nil {
+		return 0, errors.New("failed to fetch reminder lead days")
+	}
+	return leadDays, nil
+}
+
+func (r *Repository) SetReminderLeadDays(ctx context.Context, userID string, leadDays int) error {
+	query, args, err := squirrel.Update("users").
+		Set("reminder_lead_days", leadDays).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": userID}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.New("failed to build update query")
+	}
+	_, err = r.db.Pool().Exec(ctx, query, args...)
+	return err
 }
@@ -2,47 +2,108 @@ package subscriptions
 
 import (
 	"context"
+	"figenn/internal/exchange"
+	"figenn/internal/users"
+	"figenn/internal/utils"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type Service struct {
-	r *Repository
+	r     *Repository
+	rates exchange.RateProvider
 }
 
-func NewService(repo *Repository) *Service {
+func NewService(repo *Repository, rates exchange.RateProvider) *Service {
 	return &Service{
-		r: repo,
+		r:     repo,
+		rates: rates,
 	}
 }
 
-func (s *Service) CreateSubscription(ctx context.Context, userID string, req CreateSubscriptionRequest) error {
-	sub := &Subscription{
-		UserId:       userID,
-		Name:         req.Name,
-		Category:     req.Category,
-		Color:        req.Color,
-		Description:  req.Description,
-		StartDate:    *req.StartDate,
-		EndDate:      req.EndDate,
-		Price:        req.Price,
-		LogoUrl:      &req.LogoUrl,
-		BillingCycle: req.BillingCycle,
-		IsActive:     true,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+func (s *Service) CreateSubscription(ctx context.Context, userID string, req CreateSubscriptionRequest) (*Subscription, error) {
+	if req.Currency == "" {
+		currency, err := s.r.GetUserCurrency(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		req.Currency = currency
+	}
+
+	sub := newSubscription(userID, req)
+	if err := s.r.CreateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func newSubscription(userID string, req CreateSubscriptionRequest) *Subscription {
+	price := req.Price
+	if req.PriceAfterTrial != nil {
+		price = *req.PriceAfterTrial
+	}
+	return &Subscription{
+		UserId:          userID,
+		Name:            req.Name,
+		Category:        req.Category,
+		Color:           req.Color,
+		Description:     req.Description,
+		StartDate:       *req.StartDate,
+		EndDate:         req.EndDate,
+		Price:           price,
+		Currency:        req.Currency,
+		LogoUrl:         &req.LogoUrl,
+		BillingCycle:    req.BillingCycle,
+		IntervalDays:    req.IntervalDays,
+		TrialEndsAt:     req.TrialEndsAt,
+		PriceAfterTrial: req.PriceAfterTrial,
+		IsActive:        true,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
 	}
-	return s.r.CreateSubscription(ctx, sub)
 }
 
 func (s *Service) ListActiveSubscriptions(ctx context.Context, userID string, year, month int) ([]*Subscription, error) {
 	if userID == "" {
 		return nil, ErrUserIDAndSubIDRequired
 	}
-	return s.r.GetActiveSubscriptions(ctx, userID, year, month)
+
+	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	subs, err := s.r.GetSubscriptionsInRange(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var active []*Subscription
+	for _, sub := range subs {
+		if len(ChargeDates(sub, from, to)) > 0 {
+			active = append(active, sub)
+		}
+	}
+	if _, err := s.convertPrices(ctx, userID, active); err != nil {
+		return nil, err
+	}
+	return active, nil
 }
 
 func (s *Service) GetAllSubscriptions(ctx context.Context, userID string, limit, offset int) ([]*Subscription, error) {
-	return s.r.GetAllSubscriptions(ctx, userID, limit, offset)
+	subs, err := s.r.GetAllSubscriptions(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.convertPrices(ctx, userID, subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// GetUserSubscriptions returns every subscription of the user, unconverted.
+func (s *Service) GetUserSubscriptions(ctx context.Context, userID string) ([]*Subscription, error) {
+	return s.r.GetUserSubscriptions(ctx, userID)
 }
 
 func (s *Service) DeleteSubscription(ctx context.Context, userID, subID string) error {
@@ -59,6 +120,9 @@ func (s *Service) UpdateSubscription(ctx context.Context, userID, subID string,
 
 	fields := make(map[string]interface{})
 	if req.Name != nil {
+		if *req.Name == "" || utf8.RuneCountInString(*req.Name) > maxNameLength {
+			return ErrInvalidName
+		}
 		fields["name"] = *req.Name
 	}
 	if req.Category != nil {
@@ -77,37 +141,445 @@ func (s *Service) UpdateSubscription(ctx context.Context, userID, subID string,
 		fields["end_date"] = *req.EndDate
 	}
 	if req.Price != nil {
+		if *req.Price < 0 {
+			return ErrInvalidPrice
+		}
 		fields["price"] = *req.Price
 	}
+	if req.Currency != nil {
+		if !utils.ValidateCurrency(*req.Currency) {
+			return ErrInvalidCurrency
+		}
+		fields["currency"] = *req.Currency
+	}
 	if req.IsActive != nil {
 		fields["is_active"] = *req.IsActive
 	}
 	if req.IsRecuring != nil {
 		fields["is_recuring"] = *req.IsRecuring
 	}
+	if req.TrialEndsAt != nil {
+		fields["trial_ends_at"] = *req.TrialEndsAt
+	}
+	if req.PriceAfterTrial != nil {
+		if *req.PriceAfterTrial < 0 {
+			return ErrInvalidPrice
+		}
+		fields["price_after_trial"] = *req.PriceAfterTrial
+		if req.Price == nil {
+			fields["price"] = *req.PriceAfterTrial
+			req.Price = req.PriceAfterTrial
+		}
+	}
 
 	if len(fields) == 0 {
 		return ErrNoFieldsToUpdate
 	}
+	if req.StartDate != nil || req.EndDate != nil || req.TrialEndsAt != nil {
+		if err := s.checkDates(ctx, userID, subID, req.StartDate, req.EndDate, req.TrialEndsAt); err != nil {
+			return err
+		}
+	}
+
+	var priceEffectiveFrom *time.Time
+	if req.Price != nil || req.Currency != nil {
+		effectiveFrom := truncateDay(time.Now())
+		if req.PriceEffectiveFrom != nil {
+			effectiveFrom = truncateDay(*req.PriceEffectiveFrom)
+		}
+		priceEffectiveFrom = &effectiveFrom
+	}
+
+	return s.r.UpdateSubscription(ctx, userID, subID, fields, priceEffectiveFrom)
+}
 
-	return s.r.UpdateSubscription(ctx, userID, subID, fields)
+// checkDates ensures the subscription still ends, and its trial ends after,
+// its start once updated, the dates left unset keeping their current value.
+func (s *Service) checkDates(ctx context.Context, userID, subID string, startDate, endDate, trialEndsAt *time.Time) error {
+	if startDate == nil || endDate == nil || trialEndsAt == nil {
+		sub, err := s.r.GetSubscriptionByID(ctx, userID, subID)
+		if err != nil {
+			return err
+		}
+		if sub == nil {
+			return ErrSubscriptionNotFound
+		}
+		if startDate == nil {
+			startDate = &sub.StartDate
+		}
+		if endDate == nil {
+			endDate = sub.EndDate
+		}
+		if trialEndsAt == nil {
+			trialEndsAt = sub.TrialEndsAt
+		}
+	}
+	if endDate != nil && truncateDay(*endDate).Before(truncateDay(*startDate)) {
+		return ErrEndBeforeStart
+	}
+	if trialEndsAt != nil && !truncateDay(*trialEndsAt).After(truncateDay(*startDate)) {
+		return ErrInvalidTrialEnd
+	}
+	return nil
+}
+
+// SetLogo points the subscription to an uploaded logo and returns the previous
+// one, for the caller to delete it.
+func (s *Service) SetLogo(ctx context.Context, userID, subID, url string) (string, error) {
+	if userID == "" || subID == "" {
+		return "", ErrUserIDAndSubIDRequired
+	}
+	sub, err := s.r.GetSubscriptionByID(ctx, userID, subID)
+	if err != nil {
+		return "", err
+	}
+	if sub == nil {
+		return "", ErrSubscriptionNotFound
+	}
+	if err := s.r.UpdateSubscription(ctx, userID, subID, map[string]interface{}{"logo_url": url}, nil); err != nil {
+		return "", err
+	}
+	if sub.LogoUrl == nil {
+		return "", nil
+	}
+	return *sub.LogoUrl, nil
 }
 
 func (s *Service) GetSubscription(ctx context.Context, userID, subID string) (*Subscription, error) {
 	if userID == "" || subID == "" {
 		return nil, ErrUserIDAndSubIDRequired
 	}
-	return s.r.GetSubscriptionByID(ctx, userID, subID)
+	sub, err := s.r.GetSubscriptionByID(ctx, userID, subID)
+	if err != nil || sub == nil {
+		return sub, err
+	}
+	if _, err := s.convertPrices(ctx, userID, []*Subscription{sub}); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *Service) CalculateActiveSubscriptions(ctx context.Context, userID string, year, month *int) (*SpendingSummary, error) {
+	from, to := calculationPeriod(year, month, time.Now())
+	subs, err := s.subscriptionsInRange(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := s.convertPrices(ctx, userID, subs)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := SummarizeSpending(subs, from, to)
+	summary.Currency = currency
+	return summary, nil
+}
+
+// GetUpcomingSubscriptions returns the charges of the given ISO week of the
+// current year, along with the first charges of free trials ending within
+// trialWindow days from today, whichever week they fall in. Those are flagged.
+func (s *Service) GetUpcomingSubscriptions(ctx context.Context, userID string, week, trialWindow int) ([]*Charge, error) {
+	if week < 1 || week > 53 {
+		return nil, ErrInvalidWeek
+	}
+
+	now := time.Now()
+	from := utils.ISOWeekStart(now.Year(), week)
+	to := from.AddDate(0, 0, 7)
+	// Load the subscriptions of both the week and the trial window.
+	loadFrom, loadTo := from, to
+	if today := truncateDay(now); today.Before(loadFrom) {
+		loadFrom = today
+	}
+	if windowEnd := truncateDay(now).AddDate(0, 0, trialWindow+1); windowEnd.After(loadTo) {
+		loadTo = windowEnd
+	}
+	subs, err := s.subscriptionsInRange(ctx, userID, loadFrom, loadTo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.convertPrices(ctx, userID, subs); err != nil {
+		return nil, err
+	}
+	charges := ChargesInRange(subs, from, to)
+	return withTrialConversions(charges, trialConversions(subs, now, trialWindow)), nil
 }
 
-func (s *Service) CalculateActiveSubscriptions(ctx context.Context, userID string, year, month *int) (float64, error) {
-	return s.r.CalculateActiveSubscriptionPrice(ctx, userID, year, month)
+func (s *Service) GetForecast(ctx context.Context, userID string, from, to time.Time, granularity Granularity) (*Forecast, error) {
+	if !from.Before(to) || to.Sub(from) > maxForecastRange {
+		return nil, ErrInvalidPeriod
+	}
+	if !isValidGranularity(granularity) {
+		return nil, ErrInvalidGranularity
+	}
+
+	subs, err := s.subscriptionsInRange(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := s.convertPrices(ctx, userID, subs)
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := BuildForecast(subs, from, to, granularity)
+	forecast.Currency = currency
+	return forecast, nil
 }
 
-func (s *Service) GetUpcomingSubscriptions(ctx context.Context, userID string, week int) ([]*Subscription, error) {
-	return s.r.GetUpcomingSubscriptions(ctx, userID, week)
+func (s *Service) GetPriceChanges(ctx context.Context, userID string) ([]*PriceChange, error) {
+	subs, err := s.r.GetUserPriceHistory(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return PriceChanges(subs), nil
 }
 
 func (s *Service) GetSubscriptionsByCategory(ctx context.Context, userID string) ([]*SubscriptionCategoryCount, error) {
-	return s.r.GetSubscriptionsByCategory(ctx, userID)
+	subs, err := s.r.GetActiveSubscriptions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := s.convertPrices(ctx, userID, subs)
+	if err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[string]*SubscriptionCategoryCount)
+	original := make(map[string]map[string]float64)
+	for _, sub := range subs {
+		item, ok := byCategory[sub.Category]
+		if !ok {
+			item = &SubscriptionCategoryCount{Category: sub.Category, Currency: currency}
+			byCategory[sub.Category] = item
+			original[sub.Category] = make(map[string]float64)
+		}
+		monthly := ChargesPerYear(sub) / 12
+		item.Count++
+		item.MonthlyEquivalent += monthly * sub.ConvertedPrice
+		original[sub.Category][sub.Currency] += monthly * sub.Price
+	}
+
+	result := make([]*SubscriptionCategoryCount, 0, len(byCategory))
+	for category, item := range byCategory {
+		item.MonthlyEquivalent = roundCents(item.MonthlyEquivalent)
+		item.Original = currencyAmounts(original[category])
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Category < result[j].Category
+	})
+	return result, nil
+}
+
+func (s *Service) ExportSubscriptions(ctx context.Context, userID string, format ExportFormat) ([]byte, error) {
+	if !isValidExportFormat(format) {
+		return nil, ErrInvalidImportFormat
+	}
+	subs, err := s.r.GetUserSubscriptions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSubscriptions(subs, format)
+}
+
+// ImportSubscriptions validates records with the rules of CreateSubscription
+// and creates the valid ones, unless dryRun is set. A record matching an
+// existing subscription, or an earlier record, on name, start date and price
+// is reported as a duplicate and skipped.
+func (s *Service) ImportSubscriptions(ctx context.Context, userID string, records []*SubscriptionRecord, dryRun bool) (*ImportResult, error) {
+	existing, err := s.r.GetUserSubscriptions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := s.r.GetUserCurrency(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(existing))
+	for _, sub := range existing {
+		seen[duplicateKey(sub.Name, sub.StartDate, sub.Price)] = true
+	}
+
+	result := &ImportResult{DryRun: dryRun, Total: len(records), Rows: make([]*ImportRowResult, 0, len(records))}
+	var toCreate []*Subscription
+	var createdRows []*ImportRowResult
+	for i, record := range records {
+		row := &ImportRowResult{Row: i + 1, Name: record.Name}
+		if record.row > 0 {
+			row.Row = record.row
+		}
+		result.Rows = append(result.Rows, row)
+
+		req, err := record.toRequest()
+		if err == nil {
+			err = req.Validate()
+		}
+		if err != nil {
+			row.Status = ImportRowInvalid
+			row.Error = err.Error()
+			result.Invalid++
+			continue
+		}
+
+		key := duplicateKey(req.Name, *req.StartDate, req.Price)
+		if seen[key] {
+			row.Status = ImportRowDuplicate
+			result.Duplicates++
+			continue
+		}
+		seen[key] = true
+
+		if req.Currency == "" {
+			req.Currency = currency
+		}
+		sub := newSubscription(userID, req)
+		if record.IsActive != nil {
+			sub.IsActive = *record.IsActive
+		}
+		toCreate = append(toCreate, sub)
+		createdRows = append(createdRows, row)
+		row.Status = ImportRowValid
+		result.Valid++
+	}
+
+	if dryRun || len(toCreate) == 0 {
+		return result, nil
+	}
+	if err := s.r.CreateSubscriptions(ctx, toCreate); err != nil {
+		return nil, err
+	}
+	for _, row := range createdRows {
+		row.Status = ImportRowCreated
+	}
+	result.Created = len(toCreate)
+	return result, nil
+}
+
+func duplicateKey(name string, startDate time.Time, price float64) string {
+	return strings.ToLower(strings.TrimSpace(name)) + "|" + startDate.Format(time.DateOnly) + "|" + strconv.FormatFloat(price, 'f', 2, 64)
+}
+
+// CreateCalendarToken issues a new calendar feed token for the user. Any
+// previous token stops working.
+func (s *Service) CreateCalendarToken(ctx context.Context, userID string) (string, error) {
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := s.r.SetCalendarToken(ctx, userID, utils.HashToken(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *Service) RevokeCalendarToken(ctx context.Context, userID string) error {
+	return s.r.DeleteCalendarToken(ctx, userID)
+}
+
+func (s *Service) GetReminderPreferences(ctx context.Context, userID string) (*ReminderPreferences, error) {
+	leadDays, err := s.r.GetReminderLeadDays(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &ReminderPreferences{LeadDays: leadDays}, nil
+}
+
+// UpdateReminderPreferences sets how many days before a charge the user is
+// reminded of it. Zero disables reminders.
+func (s *Service) UpdateReminderPreferences(ctx context.Context, userID string, prefs ReminderPreferences) error {
+	if prefs.LeadDays < 0 || prefs.LeadDays > users.MaxReminderLeadDays {
+		return users.ErrInvalidLeadDays
+	}
+	return s.r.SetReminderLeadDays(ctx, userID, prefs.LeadDays)
+}
+
+// GetCalendarFeed renders the ICS feed of the owner of token.
+func (s *Service) GetCalendarFeed(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", ErrInvalidCalendarToken
+	}
+	userID, err := s.r.GetUserIDByCalendarToken(ctx, utils.HashToken(token))
+	if err != nil {
+		return "", err
+	}
+
+	subs, err := s.r.GetActiveSubscriptions(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return BuildCalendar(subs, time.Now()), nil
+}
+
+// subscriptionsInRange loads the subscriptions charged in [from, to) with
+// their price history, so charges can use the price of their date.
+func (s *Service) subscriptionsInRange(ctx context.Context, userID string, from, to time.Time) ([]*Subscription, error) {
+	subs, err := s.r.GetSubscriptionsInRange(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(subs))
+	for i, sub := range subs {
+		ids[i] = sub.Id
+	}
+	history, err := s.r.GetPriceHistory(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		sub.PriceHistory = history[sub.Id]
+	}
+	return subs, nil
+}
+
+// convertPrices fills the converted price of subs in the user's currency and
+// returns that currency. Rates are looked up once per source currency.
+func (s *Service) convertPrices(ctx context.Context, userID string, subs []*Subscription) (string, error) {
+	currency, err := s.r.GetUserCurrency(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	rates := make(map[string]float64)
+	for _, sub := range subs {
+		rate, ok := rates[sub.Currency]
+		if !ok {
+			rate, err = s.rates.Rate(ctx, sub.Currency, currency)
+			if err != nil {
+				return "", err
+			}
+			rates[sub.Currency] = rate
+		}
+		sub.rate = rate
+		sub.DisplayCurrency = currency
+		sub.ConvertedPrice = sub.toDisplay(sub.Price)
+	}
+	return currency, nil
+}
+
+// calculationPeriod resolves the optional year and month filters into a
+// [from, to) range. A year alone covers the whole year, a month alone covers
+// that month of the current year and no filter covers the current month.
+func calculationPeriod(year, month *int, now time.Time) (time.Time, time.Time) {
+	y := now.Year()
+	if year != nil {
+		y = *year
+	}
+
+	if month == nil && year != nil {
+		from := time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(1, 0, 0)
+	}
+
+	m := now.Month()
+	if month != nil {
+		m = time.Month(*month)
+	}
+	from := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(0, 1, 0)
 }
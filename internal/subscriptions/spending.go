@@ -0,0 +1,101 @@
+package subscriptions
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// billingCycles lists the cycles in the order they are reported.
+var billingCycles = []BillingCycleType{Weekly, Biweekly, Monthly, Quarterly, SemiAnnual, Annual, Custom, OneTime}
+
+// ChargesPerYear returns how many times sub is charged over an average year.
+// One-time purchases don't recur and therefore have no yearly equivalent.
+func ChargesPerYear(sub *Subscription) float64 {
+	if months := cycleMonths(sub.BillingCycle); months > 0 {
+		return 12 / float64(months)
+	}
+	if days := cycleDays(sub); days > 0 {
+		return 365.25 / float64(days)
+	}
+	return 0
+}
+
+// SummarizeSpending computes what subs actually cost in [from, to) along with
+// their normalized monthly and yearly equivalents, overall and per cycle.
+// Charges use the price that applied on their date while equivalents use the
+// current price. Amounts are expressed in the display currency of the
+// subscriptions, while Original keeps the charged amounts in the currencies
+// they are billed in.
+func SummarizeSpending(subs []*Subscription, from, to time.Time) *SpendingSummary {
+	summary := &SpendingSummary{From: from, To: to}
+	byCycle := make(map[BillingCycleType]*CycleSpending)
+	original := make(map[string]float64)
+
+	for _, sub := range subs {
+		dates := ChargeDates(sub, from, to)
+		var charged float64
+		for _, day := range dates {
+			price := sub.PriceAt(day)
+			charged += sub.toDisplay(price)
+			if sub.Currency != "" {
+				original[sub.Currency] += price
+			}
+		}
+		yearly := ChargesPerYear(sub) * sub.toDisplay(sub.Price)
+		if len(dates) == 0 && yearly == 0 {
+			continue
+		}
+		if sub.DisplayCurrency != "" {
+			summary.Currency = sub.DisplayCurrency
+		}
+
+		cs, ok := byCycle[sub.BillingCycle]
+		if !ok {
+			cs = &CycleSpending{BillingCycle: sub.BillingCycle}
+			byCycle[sub.BillingCycle] = cs
+		}
+		cs.Count++
+		cs.Total += charged
+		cs.YearlyEquivalent += yearly
+		cs.MonthlyEquivalent += yearly / 12
+	}
+
+	summary.ByBillingCycle = make([]*CycleSpending, 0, len(byCycle))
+	for _, cycle := range billingCycles {
+		cs, ok := byCycle[cycle]
+		if !ok {
+			continue
+		}
+		summary.Total += cs.Total
+		summary.MonthlyEquivalent += cs.MonthlyEquivalent
+		summary.YearlyEquivalent += cs.YearlyEquivalent
+
+		cs.Total = roundCents(cs.Total)
+		cs.MonthlyEquivalent = roundCents(cs.MonthlyEquivalent)
+		cs.YearlyEquivalent = roundCents(cs.YearlyEquivalent)
+		summary.ByBillingCycle = append(summary.ByBillingCycle, cs)
+	}
+
+	summary.Original = currencyAmounts(original)
+	summary.Total = roundCents(summary.Total)
+	summary.MonthlyEquivalent = roundCents(summary.MonthlyEquivalent)
+	summary.YearlyEquivalent = roundCents(summary.YearlyEquivalent)
+	return summary
+}
+
+// currencyAmounts turns per-currency totals into a list sorted by currency.
+func currencyAmounts(totals map[string]float64) []*CurrencyAmount {
+	amounts := make([]*CurrencyAmount, 0, len(totals))
+	for currency, amount := range totals {
+		amounts = append(amounts, &CurrencyAmount{Currency: currency, Amount: roundCents(amount)})
+	}
+	sort.Slice(amounts, func(i, j int) bool {
+		return amounts[i].Currency < amounts[j].Currency
+	})
+	return amounts
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,34 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeSpending(t *testing.T) {
+	subs := []*Subscription{
+		{StartDate: date(2025, time.January, 10), Price: 10, BillingCycle: Monthly},
+		{StartDate: date(2025, time.February, 1), Price: 120, BillingCycle: Annual},
+		{StartDate: date(2025, time.January, 20), Price: 30, BillingCycle: Quarterly},
+		{StartDate: date(2025, time.March, 5), Price: 50, BillingCycle: OneTime},
+	}
+
+	summary := SummarizeSpending(subs, date(2025, time.March, 1), date(2025, time.April, 1))
+
+	assert.Equal(t, 60.0, summary.Total)
+	assert.Equal(t, 30.0, summary.MonthlyEquivalent)
+	assert.Equal(t, 360.0, summary.YearlyEquivalent)
+	if assert.Len(t, summary.ByBillingCycle, 4) {
+		assert.Equal(t, Monthly, summary.ByBillingCycle[0].BillingCycle)
+		assert.Equal(t, 10.0, summary.ByBillingCycle[0].Total)
+		assert.Equal(t, Quarterly, summary.ByBillingCycle[1].BillingCycle)
+		assert.Equal(t, 0.0, summary.ByBillingCycle[1].Total)
+		assert.Equal(t, 10.0, summary.ByBillingCycle[1].MonthlyEquivalent)
+		assert.Equal(t, Annual, summary.ByBillingCycle[2].BillingCycle)
+		assert.Equal(t, 120.0, summary.ByBillingCycle[2].YearlyEquivalent)
+		assert.Equal(t, OneTime, summary.ByBillingCycle[3].BillingCycle)
+		assert.Equal(t, 50.0, summary.ByBillingCycle[3].Total)
+	}
+}
@@ -0,0 +1,362 @@
+package subscriptions
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ExportFormat is a file format subscriptions can be exported to and
+// imported from.
+type ExportFormat string
+
+const (
+	FormatCSV  ExportFormat = "csv"
+	FormatJSON ExportFormat = "json"
+	FormatXLSX ExportFormat = "xlsx"
+)
+
+// maxImportRows bounds the number of subscriptions imported at once.
+const maxImportRows = 5000
+
+const xlsxSheet = "Subscriptions"
+
+// recordColumns is the header shared by the CSV and XLSX files. It matches
+// the JSON names of SubscriptionRecord.
+var recordColumns = []string{
+	"name", "category", "color", "description", "start_date", "end_date", "price", "currency",
+	"billing_cycle", "interval_days", "logo_url", "is_active", "trial_ends_at", "price_after_trial",
+}
+
+// SubscriptionRecord is the flat representation of a subscription used by
+// exports and imports.
+type SubscriptionRecord struct {
+	Name         string  `json:"name"`
+	Category     string  `json:"category"`
+	Color        string  `json:"color"`
+	Description  string  `json:"description"`
+	StartDate    string  `json:"start_date"`
+	EndDate      string  `json:"end_date,omitempty"`
+	Price        float64 `json:"price"`
+	Currency     string  `json:"currency"`
+	BillingCycle string  `json:"billing_cycle"`
+	IntervalDays *int    `json:"interval_days,omitempty"`
+	LogoUrl      string  `json:"logo_url,omitempty"`
+	IsActive     *bool   `json:"is_active,omitempty"`
+	// TrialEndsAt and PriceAfterTrial describe a free trial, see Subscription.
+	TrialEndsAt     string   `json:"trial_ends_at,omitempty"`
+	PriceAfterTrial *float64 `json:"price_after_trial,omitempty"`
+
+	// row is the line of the record in a tabular file, header included.
+	row int
+	// parseErr is set when a tabular cell or a JSON item couldn't be parsed.
+	parseErr error
+}
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+func isValidExportFormat(format ExportFormat) bool {
+	switch format {
+	case FormatCSV, FormatJSON, FormatXLSX:
+		return true
+	default:
+		return false
+	}
+}
+
+func toRecord(sub *Subscription) *SubscriptionRecord {
+	isActive := sub.IsActive
+	record := &SubscriptionRecord{
+		Name:            sub.Name,
+		Category:        sub.Category,
+		Color:           sub.Color,
+		Description:     sub.Description,
+		StartDate:       sub.StartDate.Format(time.DateOnly),
+		Price:           sub.Price,
+		Currency:        sub.Currency,
+		BillingCycle:    string(sub.BillingCycle),
+		IntervalDays:    sub.IntervalDays,
+		IsActive:        &isActive,
+		PriceAfterTrial: sub.PriceAfterTrial,
+	}
+	if sub.EndDate != nil {
+		record.EndDate = sub.EndDate.Format(time.DateOnly)
+	}
+	if sub.TrialEndsAt != nil {
+		record.TrialEndsAt = sub.TrialEndsAt.Format(time.DateOnly)
+	}
+	if sub.LogoUrl != nil {
+		record.LogoUrl = *sub.LogoUrl
+	}
+	return record
+}
+
+// toRequest converts an imported record into a creation request. Dates
+// accept both YYYY-MM-DD and RFC 3339.
+func (r *SubscriptionRecord) toRequest() (CreateSubscriptionRequest, error) {
+	if r.parseErr != nil {
+		return CreateSubscriptionRequest{Name: r.Name}, r.parseErr
+	}
+
+	req := CreateSubscriptionRequest{
+		Name:            strings.TrimSpace(r.Name),
+		Category:        r.Category,
+		Color:           r.Color,
+		Description:     r.Description,
+		Price:           r.Price,
+		Currency:        strings.ToUpper(strings.TrimSpace(r.Currency)),
+		LogoUrl:         r.LogoUrl,
+		BillingCycle:    BillingCycleType(strings.ToLower(strings.TrimSpace(r.BillingCycle))),
+		IntervalDays:    r.IntervalDays,
+		PriceAfterTrial: r.PriceAfterTrial,
+	}
+
+	if r.StartDate != "" {
+		start, err := parseRecordDate(r.StartDate)
+		if err != nil {
+			return req, fmt.Errorf("invalid start_date %q", r.StartDate)
+		}
+		req.StartDate = &start
+	}
+	if r.EndDate != "" {
+		end, err := parseRecordDate(r.EndDate)
+		if err != nil {
+			return req, fmt.Errorf("invalid end_date %q", r.EndDate)
+		}
+		req.EndDate = &end
+	}
+	if r.TrialEndsAt != "" {
+		trialEnd, err := parseRecordDate(r.TrialEndsAt)
+		if err != nil {
+			return req, fmt.Errorf("invalid trial_ends_at %q", r.TrialEndsAt)
+		}
+		req.TrialEndsAt = &trialEnd
+	}
+	return req, nil
+}
+
+func parseRecordDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return truncateDay(t), nil
+}
+
+// EncodeSubscriptions writes subs in the given format.
+func EncodeSubscriptions(subs []*Subscription, format ExportFormat) ([]byte, error) {
+	records := make([]*SubscriptionRecord, len(subs))
+	for i, sub := range subs {
+		records[i] = toRecord(sub)
+	}
+
+	switch format {
+	case FormatJSON:
+		return json.MarshalIndent(records, "", "  ")
+	case FormatCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.Write(recordColumns); err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if err := w.Write(recordRow(record)); err != nil {
+				return nil, err
+			}
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	case FormatXLSX:
+		f := excelize.NewFile()
+		defer f.Close()
+		if err := f.SetSheetName("Sheet1", xlsxSheet); err != nil {
+			return nil, err
+		}
+		rows := [][]string{recordColumns}
+		for _, record := range records {
+			rows = append(rows, recordRow(record))
+		}
+		for i, row := range rows {
+			cell, err := excelize.CoordinatesToCellName(1, i+1)
+			if err != nil {
+				return nil, err
+			}
+			values := make([]interface{}, len(row))
+			for j, v := range row {
+				values[j] = v
+			}
+			if err := f.SetSheetRow(xlsxSheet, cell, &values); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := f.WriteToBuffer()
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, ErrInvalidImportFormat
+	}
+}
+
+// DecodeSubscriptions reads records in the given format. Tabular formats
+// must start with a header row naming the columns, in any order.
+func DecodeSubscriptions(r io.Reader, format ExportFormat) ([]*SubscriptionRecord, error) {
+	switch format {
+	case FormatJSON:
+		var records []*SubscriptionRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, ErrInvalidImportFormat
+		}
+		for i, record := range records {
+			if record == nil {
+				records[i] = &SubscriptionRecord{row: i + 1, parseErr: fmt.Errorf("row %d is not an object", i+1)}
+			}
+		}
+		return records, checkImportSize(len(records))
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, ErrInvalidImportFormat
+		}
+		return recordsFromRows(rows)
+	case FormatXLSX:
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, ErrInvalidImportFormat
+		}
+		defer f.Close()
+		rows, err := f.GetRows(f.GetSheetName(0))
+		if err != nil {
+			return nil, ErrInvalidImportFormat
+		}
+		return recordsFromRows(rows)
+	default:
+		return nil, ErrInvalidImportFormat
+	}
+}
+
+func checkImportSize(n int) error {
+	if n > maxImportRows {
+		return ErrTooManyImportRows
+	}
+	return nil
+}
+
+func recordRow(r *SubscriptionRecord) []string {
+	intervalDays := ""
+	if r.IntervalDays != nil {
+		intervalDays = strconv.Itoa(*r.IntervalDays)
+	}
+	isActive := "true"
+	if r.IsActive != nil {
+		isActive = strconv.FormatBool(*r.IsActive)
+	}
+	priceAfterTrial := ""
+	if r.PriceAfterTrial != nil {
+		priceAfterTrial = strconv.FormatFloat(*r.PriceAfterTrial, 'f', 2, 64)
+	}
+	return []string{
+		r.Name, r.Category, r.Color, r.Description, r.StartDate, r.EndDate,
+		strconv.FormatFloat(r.Price, 'f', 2, 64), r.Currency, r.BillingCycle, intervalDays, r.LogoUrl, isActive,
+		r.TrialEndsAt, priceAfterTrial,
+	}
+}
+
+// recordsFromRows maps tabular rows to records using the header row. Values
+// that can't be parsed are reported on their row rather than failing the
+// whole file. Blank rows are skipped.
+func recordsFromRows(rows [][]string) ([]*SubscriptionRecord, error) {
+	if len(rows) == 0 {
+		return nil, ErrInvalidImportFormat
+	}
+	if err := checkImportSize(len(rows) - 1); err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	for i, column := range rows[0] {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	if _, ok := index["name"]; !ok {
+		return nil, ErrInvalidImportFormat
+	}
+
+	records := make([]*SubscriptionRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		get := func(column string) string {
+			i, ok := index[column]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		record := &SubscriptionRecord{
+			Name:         get("name"),
+			Category:     get("category"),
+			Color:        get("color"),
+			Description:  get("description"),
+			StartDate:    get("start_date"),
+			EndDate:      get("end_date"),
+			Currency:     get("currency"),
+			BillingCycle: get("billing_cycle"),
+			LogoUrl:      get("logo_url"),
+			TrialEndsAt:  get("trial_ends_at"),
+			row:          i + 2,
+		}
+		price, err := strconv.ParseFloat(strings.ReplaceAll(get("price"), ",", "."), 64)
+		if err != nil {
+			record.parseErr = fmt.Errorf("invalid price %q", get("price"))
+		}
+		record.Price = price
+		if v := get("interval_days"); v != "" {
+			days, err := strconv.Atoi(v)
+			if err != nil {
+				record.parseErr = fmt.Errorf("invalid interval_days %q", v)
+			}
+			record.IntervalDays = &days
+		}
+		if v := get("price_after_trial"); v != "" {
+			priceAfterTrial, err := strconv.ParseFloat(strings.ReplaceAll(v, ",", "."), 64)
+			if err != nil {
+				record.parseErr = fmt.Errorf("invalid price_after_trial %q", v)
+			}
+			record.PriceAfterTrial = &priceAfterTrial
+		}
+		if v := get("is_active"); v != "" {
+			isActive, err := strconv.ParseBool(v)
+			if err != nil {
+				record.parseErr = fmt.Errorf("invalid is_active %q", v)
+			}
+			record.IsActive = &isActive
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,78 @@
+package subscriptions
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionsRoundTrip(t *testing.T) {
+	twentyEightDays := 28
+	logo := "https://example.com/logo.png"
+	end := date(2025, time.December, 31)
+	subs := []*Subscription{
+		{Name: "Netflix", Category: "Streaming", Color: "#E50914", StartDate: date(2025, time.January, 31), EndDate: &end, Price: 13.49, Currency: "EUR", BillingCycle: Monthly, LogoUrl: &logo, IsActive: true},
+		{Name: "Gym", Category: "Sport", StartDate: date(2025, time.March, 1), Price: 30, Currency: "USD", BillingCycle: Custom, IntervalDays: &twentyEightDays},
+	}
+
+	for _, format := range []ExportFormat{FormatCSV, FormatJSON, FormatXLSX} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := EncodeSubscriptions(subs, format)
+			assert.NoError(t, err)
+
+			records, err := DecodeSubscriptions(bytes.NewReader(data), format)
+			assert.NoError(t, err)
+			if !assert.Len(t, records, 2) {
+				return
+			}
+
+			req, err := records[0].toRequest()
+			assert.NoError(t, err)
+			assert.Equal(t, "Netflix", req.Name)
+			assert.Equal(t, date(2025, time.January, 31), *req.StartDate)
+			assert.Equal(t, end, *req.EndDate)
+			assert.Equal(t, 13.49, req.Price)
+			assert.Equal(t, Monthly, req.BillingCycle)
+			assert.Equal(t, logo, req.LogoUrl)
+
+			req, err = records[1].toRequest()
+			assert.NoError(t, err)
+			assert.Equal(t, 28, *req.IntervalDays)
+			assert.Equal(t, "USD", req.Currency)
+			assert.False(t, *records[1].IsActive)
+		})
+	}
+}
+
+func TestDecodeSubscriptionsReportsInvalidCells(t *testing.T) {
+	csv := "price,name,start_date\n9.99,Spotify,2025-02-01\n,,\nabc,Broken,2025-02-01\n"
+
+	records, err := DecodeSubscriptions(strings.NewReader(csv), FormatCSV)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, 9.99, records[0].Price)
+	assert.Equal(t, 2, records[0].row)
+	assert.Equal(t, 4, records[1].row)
+
+	_, err = records[1].toRequest()
+	assert.Error(t, err)
+}
+
+func TestDecodeSubscriptionsRequiresHeader(t *testing.T) {
+	_, err := DecodeSubscriptions(strings.NewReader("Spotify,9.99\n"), FormatCSV)
+	assert.ErrorIs(t, err, ErrInvalidImportFormat)
+}
+
+func TestDecodeSubscriptionsReportsNullItems(t *testing.T) {
+	records, err := DecodeSubscriptions(strings.NewReader(`[null, {"name": "Spotify"}]`), FormatJSON)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, 1, records[0].row)
+	assert.Equal(t, "Spotify", records[1].Name)
+
+	_, err = records[0].toRequest()
+	assert.EqualError(t, err, "row 1 is not an object")
+}
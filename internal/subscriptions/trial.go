@@ -0,0 +1,56 @@
+package subscriptions
+
+import (
+	"sort"
+	"time"
+)
+
+// maxTrialWindow bounds how far ahead trial conversions can be flagged.
+const maxTrialWindow = 365
+
+// InTrial reports whether day falls within the free trial of s.
+func (s *Subscription) InTrial(day time.Time) bool {
+	if s.TrialEndsAt == nil {
+		return false
+	}
+	day = truncateDay(day)
+	return !day.Before(truncateDay(s.StartDate)) && day.Before(truncateDay(*s.TrialEndsAt))
+}
+
+// trialConversions returns the charges that end a free trial within
+// windowDays days from today, today included, flagged as conversions.
+func trialConversions(subs []*Subscription, today time.Time, windowDays int) []*Charge {
+	from := truncateDay(today)
+	var conversions []*Charge
+	for _, charge := range ChargesInRange(subs, from, from.AddDate(0, 0, windowDays+1)) {
+		if charge.TrialEndsAt != nil && charge.ChargeDate.Equal(truncateDay(*charge.TrialEndsAt)) {
+			charge.TrialConversion = true
+			conversions = append(conversions, charge)
+		}
+	}
+	return conversions
+}
+
+// withTrialConversions flags the conversions found among charges and adds the
+// other ones, keeping the charges ordered by date.
+func withTrialConversions(charges, conversions []*Charge) []*Charge {
+	type chargeKey struct {
+		id   string
+		date time.Time
+	}
+	byKey := make(map[chargeKey]*Charge, len(charges))
+	for _, charge := range charges {
+		byKey[chargeKey{charge.Id, charge.ChargeDate}] = charge
+	}
+	for _, conversion := range conversions {
+		if charge, ok := byKey[chargeKey{conversion.Id, conversion.ChargeDate}]; ok {
+			charge.TrialConversion = true
+			continue
+		}
+		charges = append(charges, conversion)
+	}
+	sort.SliceStable(charges, func(i, j int) bool {
+		return charges[i].ChargeDate.Before(charges[j].ChargeDate)
+	})
+	return charges
+}
@@ -0,0 +1,56 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrialDelaysCharges(t *testing.T) {
+	trialEnd := date(2025, time.March, 15)
+	sub := &Subscription{StartDate: date(2025, time.March, 1), TrialEndsAt: &trialEnd, BillingCycle: Monthly, Price: 9.99}
+
+	dates := ChargeDates(sub, date(2025, time.March, 1), date(2025, time.June, 1))
+	assert.Equal(t, []time.Time{date(2025, time.March, 15), date(2025, time.April, 15), date(2025, time.May, 15)}, dates)
+
+	assert.Equal(t, 0.0, sub.PriceAt(date(2025, time.March, 14)))
+	assert.Equal(t, 9.99, sub.PriceAt(date(2025, time.March, 15)))
+
+	summary := SummarizeSpending([]*Subscription{sub}, date(2025, time.March, 1), date(2025, time.March, 15))
+	assert.Equal(t, 0.0, summary.Total)
+}
+
+func TestTrialConversions(t *testing.T) {
+	trialEnd := date(2025, time.May, 5)
+	trial := &Subscription{Id: "trial", StartDate: date(2025, time.April, 21), TrialEndsAt: &trialEnd, BillingCycle: Monthly, Price: 9.99}
+	regular := &Subscription{Id: "regular", StartDate: date(2025, time.January, 5), BillingCycle: Monthly, Price: 5}
+	subs := []*Subscription{trial, regular}
+
+	charges := ChargesInRange(subs, date(2025, time.May, 1), date(2025, time.May, 8))
+	charges = withTrialConversions(charges, trialConversions(subs, date(2025, time.May, 1), 7))
+	assert.Len(t, charges, 2)
+	assert.True(t, charges[0].TrialConversion)
+	assert.False(t, charges[1].TrialConversion)
+
+	conversions := trialConversions(subs, date(2025, time.May, 1), 3)
+	assert.Empty(t, conversions)
+}
+
+func TestTrialConversionsOutsideTheWeek(t *testing.T) {
+	trialEnd := date(2025, time.May, 12)
+	trial := &Subscription{Id: "trial", StartDate: date(2025, time.April, 28), TrialEndsAt: &trialEnd, BillingCycle: Monthly, Price: 9.99}
+	regular := &Subscription{Id: "regular", StartDate: date(2025, time.January, 3), BillingCycle: Monthly, Price: 5}
+	subs := []*Subscription{trial, regular}
+
+	// The trial converts the week after the one asked for, but within the
+	// window.
+	charges := ChargesInRange(subs, date(2025, time.April, 28), date(2025, time.May, 5))
+	charges = withTrialConversions(charges, trialConversions(subs, date(2025, time.May, 1), 14))
+	assert.Len(t, charges, 2)
+	assert.Equal(t, "regular", charges[0].Id)
+	assert.False(t, charges[0].TrialConversion)
+	assert.Equal(t, "trial", charges[1].Id)
+	assert.Equal(t, trialEnd, charges[1].ChargeDate)
+	assert.True(t, charges[1].TrialConversion)
+}
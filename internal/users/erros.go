@@ -10,3 +10,19 @@ var (
 	ErrUnauthorized       = errors.New("unauthorized")
 	ErrNotFound           = errors.New("not found")
 )
+
+var (
+	ErrNoFieldsToUpdate  = errors.New("no fields to update")
+	ErrInvalidName       = errors.New("first and last name must be 1 to 30 characters")
+	ErrInvalidUsername   = errors.New("username must be 3 to 30 lowercase letters, digits, '.', '_' or '-'")
+	ErrUsernameTaken     = errors.New("username already taken")
+	ErrInvalidCountry    = errors.New("country must be at most 30 characters")
+	ErrInvalidCurrency   = errors.New("unsupported currency")
+	ErrInvalidPictureURL = errors.New("profile picture must be an https URL of at most 512 characters")
+	ErrInvalidLocale     = errors.New("locale must look like 'fr' or 'en-US'")
+	ErrInvalidTimezone   = errors.New("unknown timezone")
+	ErrInvalidWeekStart  = errors.New("week start must be monday, sunday or saturday")
+	ErrInvalidLeadDays   = errors.New("reminder lead days must be between 0 and 30")
+	ErrInvalidColor      = errors.New("subscription colors must be #rrggbb hex values")
+	ErrTooManyColors     = errors.New("too many subscription colors")
+)
@@ -1,27 +1,37 @@
 package users
 
 import (
+	"errors"
+	"figenn/internal/jwtkeys"
+	"figenn/internal/media"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 )
 
 type API struct {
-	JWTSecret string
-	s         *Service
+	JWTKeys *jwtkeys.KeySet
+	s       *Service
+	media   *media.Uploader
 }
 
-func NewAPI(secret string, service *Service) *API {
+func NewAPI(keys *jwtkeys.KeySet, service *Service, uploader *media.Uploader) *API {
 	return &API{
-		JWTSecret: secret,
-		s:         service,
+		JWTKeys: keys,
+		s:       service,
+		media:   uploader,
 	}
 }
 
 func (a *API) Bind(rg *echo.Group) {
-	userGroup := rg.Group("/user", CookieAuthMiddleware(a.JWTSecret))
+	userGroup := rg.Group("/user", CookieAuthMiddleware(a.JWTKeys))
 	userGroup.GET("/me", a.Me)
+	userGroup.PATCH("/me", a.UpdateMe)
+	userGroup.POST("/me/avatar", a.UploadAvatar, media.BodyLimit)
+	userGroup.GET("/me/preferences", a.GetPreferences)
+	userGroup.PATCH("/me/preferences", a.UpdatePreferences)
 }
 
 func (a *API) Me(c echo.Context) error {
@@ -40,3 +50,125 @@ func (a *API) Me(c echo.Context) error {
 		"user": u,
 	})
 }
+
+func (a *API) UpdateMe(c echo.Context) error {
+	ctx := c.Request().Context()
+	userId := c.Get("user_id").(string)
+
+	var req UpdateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "invalid request body",
+		})
+	}
+
+	u, err := a.s.UpdateUser(ctx, userId, req)
+	if err != nil {
+		return writeError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user": u,
+	})
+}
+
+// UploadAvatar replaces the profile picture with the image of the "file"
+// multipart field.
+func (a *API) UploadAvatar(c echo.Context) error {
+	ctx := c.Request().Context()
+	userId := c.Get("user_id").(string)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "a file is required",
+		})
+	}
+
+	url, err := a.media.Upload(ctx, media.Avatar, userId, file)
+	if err != nil {
+		return writeError(c, err)
+	}
+
+	previous, err := a.s.SetProfilePicture(ctx, userId, url)
+	if err != nil {
+		return writeError(c, err)
+	}
+	if previous != url {
+		if err := a.media.Remove(ctx, media.Avatar, userId, previous); err != nil {
+			log.Printf("users: failed to remove previous avatar: %v", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"profile_picture_url": url,
+	})
+}
+
+func (a *API) GetPreferences(c echo.Context) error {
+	ctx := c.Request().Context()
+	userId := c.Get("user_id").(string)
+
+	prefs, err := a.s.GetPreferences(ctx, userId)
+	if err != nil {
+		return writeError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"preferences": prefs,
+	})
+}
+
+func (a *API) UpdatePreferences(c echo.Context) error {
+	ctx := c.Request().Context()
+	userId := c.Get("user_id").(string)
+
+	var req UpdatePreferencesRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "invalid request body",
+		})
+	}
+
+	prefs, err := a.s.UpdatePreferences(ctx, userId, req)
+	if err != nil {
+		return writeError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"preferences": prefs,
+	})
+}
+
+func writeError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrUsernameTaken):
+		status = http.StatusConflict
+	case errors.Is(err, media.ErrFileTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, media.ErrUnsupportedType):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrNoFieldsToUpdate),
+		errors.Is(err, ErrInvalidName),
+		errors.Is(err, ErrInvalidUsername),
+		errors.Is(err, ErrInvalidCountry),
+		errors.Is(err, ErrInvalidCurrency),
+		errors.Is(err, ErrInvalidPictureURL),
+		errors.Is(err, ErrInvalidLocale),
+		errors.Is(err, ErrInvalidTimezone),
+		errors.Is(err, ErrInvalidWeekStart),
+		errors.Is(err, ErrInvalidLeadDays),
+		errors.Is(err, ErrInvalidColor),
+		errors.Is(err, ErrTooManyColors),
+		errors.Is(err, media.ErrInvalidImage):
+		status = http.StatusBadRequest
+	default:
+		err = ErrInternalServer
+	}
+	return c.JSON(status, map[string]interface{}{
+		"error": err.Error(),
+	})
+}
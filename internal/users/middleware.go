@@ -1,14 +1,18 @@
 package users
 
 import (
-	"errors"
+	"figenn/internal/jwtkeys"
 	"net/http"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
-func CookieAuthMiddleware(secret string) echo.MiddlewareFunc {
+// CookieAuthMiddleware authenticates the request with its access token. It
+// does not look the session up: once a session is revoked, its refresh token
+// is refused right away but its access token keeps working until it expires.
+// The access token lifetime, TokenDuration, is the revocation window and must
+// stay short.
+func CookieAuthMiddleware(keys *jwtkeys.KeySet) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			cookie, err := c.Cookie("accessToken")
@@ -16,21 +20,13 @@ func CookieAuthMiddleware(secret string) echo.MiddlewareFunc {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Missing access token")
 			}
 
-			token, err := jwt.Parse(cookie.Value, func(t *jwt.Token) (interface{}, error) {
-				if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, errors.New("unexpected signing method")
-				}
-				return []byte(secret), nil
-			})
-			if err != nil || !token.Valid {
+			// Only access tokens open a session, not the refresh, MFA
+			// challenge or email link tokens signed by the same keys.
+			claims, err := keys.Parse(cookie.Value, jwtkeys.AudienceAccess)
+			if err != nil {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token")
 			}
 
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token claims")
-			}
-
 			userID, ok := claims["user_id"].(string)
 			if !ok || userID == "" {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Missing user ID in token")
@@ -41,6 +37,9 @@ func CookieAuthMiddleware(secret string) echo.MiddlewareFunc {
 			if email, ok := claims["email"].(string); ok {
 				c.Set("email", email)
 			}
+			if sessionID, ok := claims["sid"].(string); ok {
+				c.Set("session_id", sessionID)
+			}
 
 			return next(c)
 		}
@@ -72,3 +71,29 @@ func PremiumMiddleware(s *Service) echo.MiddlewareFunc {
 		}
 	}
 }
+
+// VerifiedEmailMiddleware restricts a route to users who confirmed their
+// email address. It must run after CookieAuthMiddleware.
+func VerifiedEmailMiddleware(s *Service) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(string)
+			if !ok || userID == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+			}
+
+			verified, err := s.IsEmailVerified(c.Request().Context(), userID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+			}
+			if !verified {
+				return c.JSON(http.StatusForbidden, echo.Map{
+					"error":                       "Please verify your email address first",
+					"email_verification_required": true,
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
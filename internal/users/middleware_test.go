@@ -0,0 +1,62 @@
+package users
+
+import (
+	"figenn/internal/jwtkeys"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieAuthMiddlewareRequiresAccessAudience(t *testing.T) {
+	keys := jwtkeys.NewHMACKeySet("secret")
+	e := echo.New()
+	e.GET("/me", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("user_id").(string))
+	}, CookieAuthMiddleware(keys))
+
+	call := func(audience string) int {
+		token, err := keys.Sign(audience, jwt.MapClaims{"user_id": "42", "exp": time.Now().Add(time.Minute).Unix()})
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.AddCookie(&http.Cookie{Name: "accessToken", Value: token})
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, call(jwtkeys.AudienceAccess))
+	assert.Equal(t, http.StatusUnauthorized, call(jwtkeys.AudienceRefresh))
+	assert.Equal(t, http.StatusUnauthorized, call(jwtkeys.AudienceVerifyEmail))
+}
+
+// Revoked sessions are not looked up: the access token expiry is what ends
+// them.
+func TestCookieAuthMiddlewareRejectsExpiredTokens(t *testing.T) {
+	keys := jwtkeys.NewHMACKeySet("secret")
+	e := echo.New()
+	e.GET("/me", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("session_id").(string))
+	}, CookieAuthMiddleware(keys))
+
+	call := func(expiresIn time.Duration) int {
+		token, err := keys.Sign(jwtkeys.AudienceAccess, jwt.MapClaims{
+			"user_id": "42",
+			"sid":     "7",
+			"exp":     time.Now().Add(expiresIn).Unix(),
+		})
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.AddCookie(&http.Cookie{Name: "accessToken", Value: token})
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, call(time.Minute))
+	assert.Equal(t, http.StatusUnauthorized, call(-time.Minute))
+}
@@ -24,6 +24,7 @@ type User struct {
 	LastLogin           *time.Time `json:"last_login,omitempty" form:"last_login"`
 	TwoFAEnabled        bool       `json:"two_fa_enabled" form:"two_fa_enabled"`
 	TwoFACode           string     `json:"two_fa_code,omitempty" form:"two_fa_code"`
+	EmailVerifiedAt     *time.Time `json:"email_verified_at,omitempty" form:"email_verified_at"`
 	CreatedAt           time.Time  `json:"created_at" form:"created_at"`
 	UpdatedAt           time.Time  `json:"updated_at" form:"updated_at"`
 }
@@ -33,13 +34,16 @@ type UserRequest struct {
 	FirstName         string    `json:"first_name" form:"first_name"`
 	LastName          string    `json:"last_name" form:"last_name"`
 	Email             string    `json:"email" form:"email"`
+	Username          string    `json:"username,omitempty" form:"username"`
 	Country           string    `json:"country,omitempty" form:"country"`
+	Currency          string    `json:"currency,omitempty" form:"currency"`
 	ProfilePictureUrl string    `json:"profile_picture_url,omitempty" form:"profile_picture_url"`
 	CreatedAt         time.Time `json:"created_at" form:"created_at"`
 	StripeCustomerID  string    `json:"stripe_customer_id,omitempty" form:"stripe_customer_id"`
 	SubscriptionType  string    `json:"subscription_type,omitempty" form:"subscription_type"`
 	Status            string    `json:"status,omitempty" form:"status"`
 	TwoFAEnabled      bool      `json:"two_fa_enabled" form:"two_fa_enabled"`
+	EmailVerified     bool      `json:"email_verified" form:"email_verified"`
 }
 
 type UserSubscription struct {
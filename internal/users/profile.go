@@ -0,0 +1,194 @@
+package users
+
+import (
+	"figenn/internal/utils"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLength         = 30
+	maxPictureURLLength   = 512
+	maxSubscriptionColors = 50
+	maxCategoryLength     = 50
+)
+
+// MaxReminderLeadDays is the longest a user can ask to be reminded ahead of a
+// charge, as checked by the users.reminder_lead_days column.
+const MaxReminderLeadDays = 30
+
+var (
+	usernamePattern = regexp.MustCompile(`^[a-z0-9_.-]{3,30}$`)
+	localePattern   = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+	colorPattern    = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// UpdateUserRequest is a partial profile update: nil fields are left as is.
+type UpdateUserRequest struct {
+	FirstName         *string `json:"first_name,omitempty" form:"first_name"`
+	LastName          *string `json:"last_name,omitempty" form:"last_name"`
+	Username          *string `json:"username,omitempty" form:"username"`
+	Country           *string `json:"country,omitempty" form:"country"`
+	Currency          *string `json:"currency,omitempty" form:"currency"`
+	ProfilePictureUrl *string `json:"profile_picture_url,omitempty" form:"profile_picture_url"`
+}
+
+// fields validates the request and returns the columns to update.
+func (req UpdateUserRequest) fields() (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	for column, value := range map[string]*string{"first_name": req.FirstName, "last_name": req.LastName} {
+		if value == nil {
+			continue
+		}
+		name := strings.TrimSpace(*value)
+		if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+			return nil, ErrInvalidName
+		}
+		fields[column] = name
+	}
+	if req.Username != nil {
+		username := strings.ToLower(strings.TrimSpace(*req.Username))
+		if !usernamePattern.MatchString(username) {
+			return nil, ErrInvalidUsername
+		}
+		fields["username"] = username
+	}
+	if req.Country != nil {
+		country := strings.TrimSpace(*req.Country)
+		if utf8.RuneCountInString(country) > maxNameLength {
+			return nil, ErrInvalidCountry
+		}
+		fields["country"] = country
+	}
+	if req.Currency != nil {
+		currency := strings.ToUpper(strings.TrimSpace(*req.Currency))
+		if !utils.ValidateCurrency(currency) {
+			return nil, ErrInvalidCurrency
+		}
+		fields["currency"] = currency
+	}
+	if req.ProfilePictureUrl != nil {
+		picture := strings.TrimSpace(*req.ProfilePictureUrl)
+		if !validPictureURL(picture) {
+			return nil, ErrInvalidPictureURL
+		}
+		fields["profile_picture_url"] = picture
+	}
+
+	if len(fields) == 0 {
+		return nil, ErrNoFieldsToUpdate
+	}
+	return fields, nil
+}
+
+func validPictureURL(raw string) bool {
+	if len(raw) > maxPictureURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// Preferences are the user's display settings. Everything but the reminder
+// lead time is stored in the users.preferences document.
+type Preferences struct {
+	Locale             string            `json:"locale"`
+	Timezone           string            `json:"timezone"`
+	WeekStart          string            `json:"week_start"`
+	ReminderLeadDays   int               `json:"reminder_lead_days"`
+	SubscriptionColors map[string]string `json:"subscription_colors"`
+}
+
+// preferencesDocument is what lands in users.preferences.
+type preferencesDocument struct {
+	Locale             string            `json:"locale,omitempty"`
+	Timezone           string            `json:"timezone,omitempty"`
+	WeekStart          string            `json:"week_start,omitempty"`
+	SubscriptionColors map[string]string `json:"subscription_colors,omitempty"`
+}
+
+// DefaultPreferences fills whatever the user never set.
+func DefaultPreferences() Preferences {
+	return Preferences{
+		Locale:             "en",
+		Timezone:           "UTC",
+		WeekStart:          "monday",
+		ReminderLeadDays:   3,
+		SubscriptionColors: map[string]string{},
+	}
+}
+
+// UpdatePreferencesRequest is a partial preferences update. A non-nil
+// SubscriptionColors replaces the whole map, skipping empty colors.
+type UpdatePreferencesRequest struct {
+	Locale             *string           `json:"locale,omitempty"`
+	Timezone           *string           `json:"timezone,omitempty"`
+	WeekStart          *string           `json:"week_start,omitempty"`
+	ReminderLeadDays   *int              `json:"reminder_lead_days,omitempty"`
+	SubscriptionColors map[string]string `json:"subscription_colors,omitempty"`
+}
+
+// preferencesPatch is a validated preferences update: document is merged
+// into users.preferences and leadDays, when set, replaces
+// users.reminder_lead_days.
+type preferencesPatch struct {
+	document map[string]interface{}
+	leadDays *int
+}
+
+// patch validates the request and returns the changes to store.
+func (req UpdatePreferencesRequest) patch() (preferencesPatch, error) {
+	patch := preferencesPatch{document: make(map[string]interface{})}
+	if req.Locale != nil {
+		if !localePattern.MatchString(*req.Locale) {
+			return patch, ErrInvalidLocale
+		}
+		patch.document["locale"] = *req.Locale
+	}
+	if req.Timezone != nil {
+		if *req.Timezone == "" || *req.Timezone == "Local" {
+			return patch, ErrInvalidTimezone
+		}
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			return patch, ErrInvalidTimezone
+		}
+		patch.document["timezone"] = *req.Timezone
+	}
+	if req.WeekStart != nil {
+		switch *req.WeekStart {
+		case "monday", "sunday", "saturday":
+			patch.document["week_start"] = *req.WeekStart
+		default:
+			return patch, ErrInvalidWeekStart
+		}
+	}
+	if req.ReminderLeadDays != nil {
+		if *req.ReminderLeadDays < 0 || *req.ReminderLeadDays > MaxReminderLeadDays {
+			return patch, ErrInvalidLeadDays
+		}
+		patch.leadDays = req.ReminderLeadDays
+	}
+	if req.SubscriptionColors != nil {
+		colors := make(map[string]string, len(req.SubscriptionColors))
+		for category, color := range req.SubscriptionColors {
+			category = strings.TrimSpace(category)
+			if color == "" {
+				continue
+			}
+			if category == "" || len(category) > maxCategoryLength || !colorPattern.MatchString(color) {
+				return patch, ErrInvalidColor
+			}
+			colors[category] = strings.ToLower(color)
+		}
+		if len(colors) > maxSubscriptionColors {
+			return patch, ErrTooManyColors
+		}
+		patch.document["subscription_colors"] = colors
+	}
+	return patch, nil
+}
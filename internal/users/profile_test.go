@@ -0,0 +1,69 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestUpdateUserRequestFields(t *testing.T) {
+	fields, err := UpdateUserRequest{
+		FirstName: ptr("  Ada "),
+		Username:  ptr("Ada.L"),
+		Currency:  ptr("usd"),
+	}.fields()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"first_name": "Ada",
+		"username":   "ada.l",
+		"currency":   "USD",
+	}, fields)
+
+	_, err = UpdateUserRequest{}.fields()
+	assert.ErrorIs(t, err, ErrNoFieldsToUpdate)
+
+	_, err = UpdateUserRequest{LastName: ptr("   ")}.fields()
+	assert.ErrorIs(t, err, ErrInvalidName)
+
+	_, err = UpdateUserRequest{Username: ptr("a b")}.fields()
+	assert.ErrorIs(t, err, ErrInvalidUsername)
+
+	_, err = UpdateUserRequest{Currency: ptr("JPY")}.fields()
+	assert.ErrorIs(t, err, ErrInvalidCurrency)
+
+	_, err = UpdateUserRequest{ProfilePictureUrl: ptr("http://example.com/a.png")}.fields()
+	assert.ErrorIs(t, err, ErrInvalidPictureURL)
+}
+
+func TestUpdatePreferencesRequestPatch(t *testing.T) {
+	patch, err := UpdatePreferencesRequest{
+		Locale:             ptr("fr-FR"),
+		Timezone:           ptr("Europe/Paris"),
+		ReminderLeadDays:   ptr(7),
+		SubscriptionColors: map[string]string{"Streaming": "#FF0000", "Music": ""},
+	}.patch()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"locale":              "fr-FR",
+		"timezone":            "Europe/Paris",
+		"subscription_colors": map[string]string{"Streaming": "#ff0000"},
+	}, patch.document)
+	assert.Equal(t, ptr(7), patch.leadDays)
+
+	patch, err = UpdatePreferencesRequest{SubscriptionColors: map[string]string{}}.patch()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{}, patch.document["subscription_colors"], "an empty map clears the colors")
+
+	for req, want := range map[*UpdatePreferencesRequest]error{
+		{Locale: ptr("french")}:                             ErrInvalidLocale,
+		{Timezone: ptr("Mars/Olympus")}:                     ErrInvalidTimezone,
+		{WeekStart: ptr("friday")}:                          ErrInvalidWeekStart,
+		{ReminderLeadDays: ptr(MaxReminderLeadDays + 1)}:    ErrInvalidLeadDays,
+		{SubscriptionColors: map[string]string{"x": "red"}}: ErrInvalidColor,
+	} {
+		_, err := req.patch()
+		assert.ErrorIs(t, err, want)
+	}
+}
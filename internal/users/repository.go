@@ -2,11 +2,14 @@ package users
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"figenn/internal/database"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type Repository struct {
@@ -28,11 +31,14 @@ func (r *Repository) GetUser(ctx context.Context, id string) (*UserRequest, erro
 			"u.email",
 			"u.first_name",
 			"u.last_name",
+			"COALESCE(u.username, '')",
 			"u.profile_picture_url",
 			"u.country",
+			"COALESCE(u.currency, 'EUR')",
 			"u.created_at",
 			"u.stripe_customer_id",
 			"u.two_fa_enabled",
+			"u.email_verified_at IS NOT NULL",
 			"us.subscription_type",
 			"us.status").
 		From("users AS u").
@@ -57,11 +63,14 @@ func (r *Repository) GetUser(ctx context.Context, id string) (*UserRequest, erro
 		&u.Email,
 		&u.FirstName,
 		&u.LastName,
+		&u.Username,
 		&u.ProfilePictureUrl,
 		&u.Country,
+		&u.Currency,
 		&u.CreatedAt,
 		&u.StripeCustomerID,
 		&u.TwoFAEnabled,
+		&u.EmailVerified,
 		&u.SubscriptionType,
 		&u.Status,
 	)
@@ -76,6 +85,16 @@ func (r *Repository) GetUser(ctx context.Context, id string) (*UserRequest, erro
 	return &u, nil
 }
 
+// IsEmailVerified reports whether the user confirmed their email address.
+func (r *Repository) IsEmailVerified(ctx context.Context, id string) (bool, error) {
+	var verified bool
+	err := r.s.Pool().QueryRow(ctx, `SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`, id).Scan(&verified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	return verified, err
+}
+
 func (r *Repository) GetUserByEmail(email string) (*User, error) {
 	query, args, err := squirrel.
 		Select("*").
@@ -146,3 +165,125 @@ func (r *Repository) GetActiveSubscriptionByCustomerID(stripeCustomerID string)
 
 	return &sub, nil
 }
+
+const uniqueViolation = "23505"
+
+// UpdateUser writes the given profile columns.
+func (r *Repository) UpdateUser(ctx context.Context, id string, fields map[string]interface{}) error {
+	query, args, err := squirrel.Update("users").
+		SetMap(fields).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": id}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := r.s.Pool().Exec(ctx, query, args...)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrUsernameTaken
+	}
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetPreferences loads the stored preferences on top of the defaults.
+func (r *Repository) GetPreferences(ctx context.Context, id string) (*Preferences, error) {
+	var (
+		raw      []byte
+		leadDays int
+	)
+	err := r.s.Pool().QueryRow(ctx,
+		`SELECT preferences, reminder_lead_days FROM users WHERE id = $1`, id,
+	).Scan(&raw, &leadDays)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePreferences(raw, leadDays)
+}
+
+// UpdatePreferences merges patch into the stored preferences in a single
+// statement, so that concurrent updates of different fields all stick, and
+// returns the result.
+func (r *Repository) UpdatePreferences(ctx context.Context, id string, patch preferencesPatch) (*Preferences, error) {
+	doc, err := json.Marshal(patch.document)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := squirrel.Update("users").
+		Set("preferences", squirrel.Expr("preferences || ?::jsonb", doc)).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING preferences, reminder_lead_days").
+		PlaceholderFormat(squirrel.Dollar)
+	if patch.leadDays != nil {
+		builder = builder.Set("reminder_lead_days", *patch.leadDays)
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		raw      []byte
+		leadDays int
+	)
+	err = r.s.Pool().QueryRow(ctx, query, args...).Scan(&raw, &leadDays)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePreferences(raw, leadDays)
+}
+
+// decodePreferences lays the users.preferences document over the defaults.
+func decodePreferences(raw []byte, leadDays int) (*Preferences, error) {
+	var doc preferencesDocument
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	prefs := DefaultPreferences()
+	if doc.Locale != "" {
+		prefs.Locale = doc.Locale
+	}
+	if doc.Timezone != "" {
+		prefs.Timezone = doc.Timezone
+	}
+	if doc.WeekStart != "" {
+		prefs.WeekStart = doc.WeekStart
+	}
+	if doc.SubscriptionColors != nil {
+		prefs.SubscriptionColors = doc.SubscriptionColors
+	}
+	prefs.ReminderLeadDays = leadDays
+	return &prefs, nil
+}
+
+// SetProfilePicture replaces the profile picture and returns the previous one.
+func (r *Repository) SetProfilePicture(ctx context.Context, id, url string) (string, error) {
+	var previous string
+	err := r.s.Pool().QueryRow(ctx, `
+		UPDATE users AS u SET profile_picture_url = $2, updated_at = NOW()
+		FROM (SELECT id, COALESCE(profile_picture_url, '') AS url FROM users WHERE id = $1 FOR UPDATE) AS old
+		WHERE u.id = old.id
+		RETURNING old.url`, id, url,
+	).Scan(&previous)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return previous, err
+}
@@ -40,6 +40,17 @@ func (s *Service) GetUserInfos(ctx context.Context, id string) (*UserRequest, er
 	return user, nil
 }
 
+// Forget evicts a user from the cache, after their account changed.
+func (s *Service) Forget(id string) {
+	s.cache.Remove(id)
+}
+
+// IsEmailVerified reports whether the user confirmed their email address.
+// It isn't cached: users are expected to verify then retry right away.
+func (s *Service) IsEmailVerified(ctx context.Context, id string) (bool, error) {
+	return s.repo.IsEmailVerified(ctx, id)
+}
+
 func (s *Service) IsPremiumUser(stripeCustomerID string) (bool, error) {
 	sub, err := s.repo.GetActiveSubscriptionByCustomerID(stripeCustomerID)
 	if err != nil {
@@ -47,3 +58,45 @@ func (s *Service) IsPremiumUser(stripeCustomerID string) (bool, error) {
 	}
 	return sub.SubscriptionType == "pro" || sub.SubscriptionType == "premium", nil
 }
+
+// UpdateUser applies a partial profile update.
+func (s *Service) UpdateUser(ctx context.Context, id string, req UpdateUserRequest) (*UserRequest, error) {
+	fields, err := req.fields()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateUser(ctx, id, fields); err != nil {
+		return nil, err
+	}
+	s.Forget(id)
+	return s.GetUserInfos(ctx, id)
+}
+
+func (s *Service) GetPreferences(ctx context.Context, id string) (*Preferences, error) {
+	return s.repo.GetPreferences(ctx, id)
+}
+
+// UpdatePreferences merges the request into the stored preferences.
+func (s *Service) UpdatePreferences(ctx context.Context, id string, req UpdatePreferencesRequest) (*Preferences, error) {
+	patch, err := req.patch()
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := s.repo.UpdatePreferences(ctx, id, patch)
+	if err != nil {
+		return nil, err
+	}
+	s.Forget(id)
+	return prefs, nil
+}
+
+// SetProfilePicture points the user to an uploaded avatar and returns the
+// previous picture, for the caller to delete it.
+func (s *Service) SetProfilePicture(ctx context.Context, id, url string) (string, error) {
+	previous, err := s.repo.SetProfilePicture(ctx, id, url)
+	if err != nil {
+		return "", err
+	}
+	s.Forget(id)
+	return previous, nil
+}
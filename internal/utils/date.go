@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
 func ValidateYear(year string) (int, error) {
@@ -20,3 +21,10 @@ func ValidateMonth(month string) (int, error) {
 	}
 	return m, nil
 }
+
+// ISOWeekStart returns the Monday (UTC) that starts the given ISO 8601 week.
+func ISOWeekStart(year, week int) time.Time {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	offset := (int(jan4.Weekday()) + 6) % 7
+	return jan4.AddDate(0, 0, -offset+(week-1)*7)
+}
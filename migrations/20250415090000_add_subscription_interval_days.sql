@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE subscriptions ADD COLUMN interval_days INTEGER CHECK (interval_days > 0);
+
+-- +goose Down
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS interval_days;
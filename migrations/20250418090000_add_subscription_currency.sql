@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE subscriptions ADD COLUMN currency VARCHAR(10) NOT NULL DEFAULT 'EUR';
+
+UPDATE subscriptions s
+SET currency = u.currency
+FROM users u
+WHERE s.user_id = u.id AND u.currency IS NOT NULL;
+
+CREATE TABLE exchange_rates (
+    currency VARCHAR(10) PRIMARY KEY,
+    rate DECIMAL(18,8) NOT NULL CHECK (rate > 0),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO exchange_rates (currency, rate) VALUES
+    ('EUR', 1),
+    ('USD', 1.08),
+    ('GBP', 0.85),
+    ('CHF', 0.95);
+
+-- +goose Down
+DROP TABLE IF EXISTS exchange_rates;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS currency;
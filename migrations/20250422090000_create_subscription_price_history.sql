@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE subscription_price_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    price DECIMAL(10,2) NOT NULL CHECK (price >= 0),
+    currency VARCHAR(10) NOT NULL,
+    effective_from DATE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (subscription_id, effective_from)
+);
+
+INSERT INTO subscription_price_history (subscription_id, price, currency, effective_from)
+SELECT id, price, currency, start_date FROM subscriptions;
+
+-- +goose Down
+DROP TABLE IF EXISTS subscription_price_history;
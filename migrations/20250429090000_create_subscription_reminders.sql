@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN reminder_lead_days INTEGER NOT NULL DEFAULT 3
+    CHECK (reminder_lead_days BETWEEN 0 AND 30);
+
+CREATE TABLE subscription_reminders (
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    charge_date DATE NOT NULL,
+    sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (subscription_id, charge_date)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS subscription_reminders;
+ALTER TABLE users DROP COLUMN IF EXISTS reminder_lead_days;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE subscriptions
+    ADD COLUMN trial_ends_at DATE,
+    ADD COLUMN price_after_trial DECIMAL(10,2) CHECK (price_after_trial >= 0),
+    ADD CONSTRAINT subscriptions_trial_after_start CHECK (trial_ends_at IS NULL OR trial_ends_at > start_date);
+
+-- +goose Down
+ALTER TABLE subscriptions
+    DROP CONSTRAINT IF EXISTS subscriptions_trial_after_start,
+    DROP COLUMN IF EXISTS price_after_trial,
+    DROP COLUMN IF EXISTS trial_ends_at;
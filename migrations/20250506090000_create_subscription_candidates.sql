@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE subscription_candidates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    merchant_key VARCHAR(100) NOT NULL,
+    name VARCHAR(30) NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    billing_cycle VARCHAR(20) NOT NULL,
+    first_charge_date DATE NOT NULL,
+    last_charge_date DATE NOT NULL,
+    next_charge_date DATE NOT NULL,
+    occurrences INTEGER NOT NULL,
+    confidence DECIMAL(3,2) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, merchant_key, currency)
+);
+
+CREATE INDEX idx_subscription_candidates_user_status ON subscription_candidates(user_id, status);
+
+-- +goose Down
+DROP TABLE IF EXISTS subscription_candidates;
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE powens_webhook_events (
+    id VARCHAR(64) PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_powens_webhook_events_pending ON powens_webhook_events(status, received_at)
+    WHERE status <> 'processed';
+
+-- +goose Down
+DROP TABLE IF EXISTS powens_webhook_events;
@@ -0,0 +1,55 @@
+-- +goose Up
+CREATE TABLE bank_connections (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    powens_id BIGINT NOT NULL UNIQUE,
+    connector_id BIGINT NOT NULL,
+    state VARCHAR(50),
+    error_message TEXT,
+    sync_cursor TIMESTAMP,
+    last_synced_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE bank_accounts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    connection_id UUID NOT NULL REFERENCES bank_connections(id) ON DELETE CASCADE,
+    powens_id BIGINT NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    number VARCHAR(100),
+    iban VARCHAR(50),
+    type VARCHAR(50) NOT NULL,
+    balance DECIMAL(14,2) NOT NULL DEFAULT 0,
+    currency VARCHAR(10) NOT NULL,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE bank_transactions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    account_id UUID NOT NULL REFERENCES bank_accounts(id) ON DELETE CASCADE,
+    powens_id BIGINT NOT NULL UNIQUE,
+    date DATE NOT NULL,
+    value DECIMAL(14,2) NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    wording TEXT NOT NULL,
+    original_wording TEXT NOT NULL DEFAULT '',
+    type VARCHAR(50) NOT NULL DEFAULT '',
+    coming BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_bank_connections_user ON bank_connections(user_id);
+CREATE INDEX idx_bank_accounts_user ON bank_accounts(user_id);
+CREATE INDEX idx_bank_transactions_user_date ON bank_transactions(user_id, date DESC);
+CREATE INDEX idx_bank_transactions_account_date ON bank_transactions(account_id, date DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS bank_transactions;
+DROP TABLE IF EXISTS bank_accounts;
+DROP TABLE IF EXISTS bank_connections;
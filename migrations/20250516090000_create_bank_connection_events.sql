@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE bank_connections ADD COLUMN state_changed_at TIMESTAMP;
+
+CREATE TABLE bank_connection_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    connection_id UUID NOT NULL REFERENCES bank_connections(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    previous_state VARCHAR(50),
+    state VARCHAR(50),
+    error_message TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_bank_connection_events_connection ON bank_connection_events(connection_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS bank_connection_events;
+ALTER TABLE bank_connections DROP COLUMN IF EXISTS state_changed_at;
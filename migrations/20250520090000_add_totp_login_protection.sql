@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN totp_last_step BIGINT,
+    ADD COLUMN totp_failed_attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN totp_locked_until TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totp_last_step,
+    DROP COLUMN IF EXISTS totp_failed_attempts,
+    DROP COLUMN IF EXISTS totp_locked_until;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE totp_recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_totp_recovery_codes_user ON totp_recovery_codes(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS totp_recovery_codes;
@@ -0,0 +1,32 @@
+-- +goose Up
+-- A session is a refresh token family: every token rotated out of it is
+-- kept so a replay can be told apart from a forged token.
+CREATE TABLE refresh_token_history (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    rotated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_token_history_session ON refresh_token_history(session_id);
+
+INSERT INTO refresh_token_history (token_hash, session_id, rotated_at)
+SELECT previous_token_hash, id, last_used_at FROM sessions WHERE previous_token_hash IS NOT NULL;
+
+ALTER TABLE sessions DROP COLUMN previous_token_hash;
+
+CREATE TABLE security_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID REFERENCES sessions(id) ON DELETE SET NULL,
+    type VARCHAR(50) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_security_events_user ON security_events(user_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS security_events;
+ALTER TABLE sessions ADD COLUMN previous_token_hash VARCHAR(64);
+DROP TABLE IF EXISTS refresh_token_history;
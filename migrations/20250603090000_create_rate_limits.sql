@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE rate_limit_buckets (
+    key VARCHAR(512) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE rate_limit_failures (
+    key VARCHAR(512) PRIMARY KEY,
+    count INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP,
+    locked_until TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS rate_limit_failures;
+DROP TABLE IF EXISTS rate_limit_buckets;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Display preferences that don't need their own column. The reminder lead
+-- time stays in users.reminder_lead_days since the reminder job queries it.
+ALTER TABLE users
+    ADD COLUMN preferences JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS preferences;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- Encrypted values carry the envelope (key id, sealed data key, nonce and
+-- tag) on top of the secret, they no longer fit the original sizes.
+ALTER TABLE users ALTER COLUMN two_fa_secret TYPE TEXT;
+ALTER TABLE powens_accounts ALTER COLUMN access_token TYPE TEXT;
+
+-- +goose Down
+-- Fails while encrypted values are stored: they don't fit back.
+ALTER TABLE powens_accounts ALTER COLUMN access_token TYPE VARCHAR(512);
+ALTER TABLE users ALTER COLUMN two_fa_secret TYPE VARCHAR(64);